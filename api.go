@@ -0,0 +1,321 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+type apiUser struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+}
+
+type apiFeed struct {
+	ID            uuid.UUID  `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Name          string     `json:"name"`
+	URL           string     `json:"url"`
+	UserID        uuid.UUID  `json:"user_id"`
+	LastFetchedAt *time.Time `json:"last_fetched_at,omitempty"`
+	DeadAt        *time.Time `json:"dead_at,omitempty"`
+}
+
+type apiFollow struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	FeedID    uuid.UUID `json:"feed_id"`
+	FeedName  string    `json:"feed_name"`
+}
+
+type apiPost struct {
+	ID          uuid.UUID `json:"id"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Description string    `json:"description,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+	FeedID      uuid.UUID `json:"feed_id"`
+	Author      string    `json:"author,omitempty"`
+	Categories  []string  `json:"categories,omitempty"`
+}
+
+func toAPIUser(user database.User) apiUser {
+    return apiUser{
+        ID: user.ID,
+        CreatedAt: user.CreatedAt,
+        Name: user.Name,
+        Role: user.Role,
+    }
+}
+
+func toAPIFeed(feed database.Feed) apiFeed {
+    res := apiFeed{
+        ID: feed.ID,
+        CreatedAt: feed.CreatedAt,
+        UpdatedAt: feed.UpdatedAt,
+        Name: feed.Name,
+        URL: feed.Url,
+        UserID: feed.UserID,
+    }
+    if feed.LastFetchedAt.Valid {
+        res.LastFetchedAt = &feed.LastFetchedAt.Time
+    }
+    if feed.DeadAt.Valid {
+        res.DeadAt = &feed.DeadAt.Time
+    }
+    return res
+}
+
+func toAPIPost(post database.Post) apiPost {
+    return apiPost{
+        ID: post.ID,
+        Title: post.Title,
+        URL: post.Url,
+        Description: post.Description.String,
+        PublishedAt: post.PublishedAt,
+        FeedID: post.FeedID,
+        Author: post.Author.String,
+        Categories: post.Categories,
+    }
+}
+
+func registerAPIRoutes(mux *http.ServeMux, s *state) {
+    mux.HandleFunc("GET /api/users", apiLoggedIn(s, apiGetUsers))
+    mux.HandleFunc("POST /api/users", apiLoggedIn(s, apiCreateUser))
+    mux.HandleFunc("GET /api/feeds", apiLoggedIn(s, apiGetFeeds))
+    mux.HandleFunc("POST /api/feeds", apiLoggedIn(s, apiCreateFeed))
+    mux.HandleFunc("GET /api/follows", apiLoggedIn(s, apiGetFollows))
+    mux.HandleFunc("POST /api/follows", apiLoggedIn(s, apiCreateFollow))
+    mux.HandleFunc("DELETE /api/follows/{feedID}", apiLoggedIn(s, apiDeleteFollow))
+    mux.HandleFunc("GET /api/posts", apiLoggedIn(s, apiGetPosts))
+}
+
+func apiGetUsers(s *state, w http.ResponseWriter, r *http.Request, _ database.User) {
+    users, err := s.db.GetUsers(r.Context())
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+
+    res := make([]apiUser, 0, len(users))
+    for _, user := range(users) {
+        res = append(res, toAPIUser(user))
+    }
+    respondWithJSON(w, http.StatusOK, res)
+}
+
+func apiCreateUser(s *state, w http.ResponseWriter, r *http.Request, caller database.User) {
+    if !isAdmin(caller) {
+        respondWithError(w, http.StatusForbidden, "Only admins with a password can create users")
+        return
+    }
+
+    var params struct {
+        Name    string  `json:"name"`
+    }
+    if err := decodeJSON(r, &params); err != nil {
+        respondWithError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if strings.TrimSpace(params.Name) == "" {
+        respondWithError(w, http.StatusBadRequest, "name is required")
+        return
+    }
+
+    user, err := s.db.CreateUser(r.Context(), database.CreateUserParams{
+        ID: uuid.New(),
+        CreatedAt: time.Now(),
+        UpdatedAt: time.Now(),
+        Name: params.Name,
+    })
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+
+    respondWithJSON(w, http.StatusCreated, toAPIUser(user))
+}
+
+func apiGetFeeds(s *state, w http.ResponseWriter, r *http.Request, _ database.User) {
+    limit, offset, err := pageParams(r)
+    if err != nil {
+        respondWithError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    feeds, err := s.db.GetFeedsPage(r.Context(), database.GetFeedsPageParams{
+        Limit: limit,
+        Offset: offset,
+    })
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+
+    res := make([]apiFeed, 0, len(feeds))
+    for _, feed := range(feeds) {
+        res = append(res, toAPIFeed(feed))
+    }
+    respondWithJSON(w, http.StatusOK, res)
+}
+
+func apiCreateFeed(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    var params struct {
+        Name    string  `json:"name"`
+        URL     string  `json:"url"`
+    }
+    if err := decodeJSON(r, &params); err != nil {
+        respondWithError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if params.Name == "" || params.URL == "" {
+        respondWithError(w, http.StatusBadRequest, "name and url are required")
+        return
+    }
+
+    feed, err := addFeed(r.Context(), s, user, params.Name, params.URL)
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+
+    respondWithJSON(w, http.StatusCreated, toAPIFeed(feed))
+}
+
+func apiGetFollows(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    follows, err := s.db.GetFeedFollowsForUser(r.Context(), user.ID)
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+
+    res := make([]apiFollow, 0, len(follows))
+    for _, follow := range(follows) {
+        res = append(res, apiFollow{
+            ID: follow.ID,
+            CreatedAt: follow.CreatedAt,
+            FeedID: follow.FeedID,
+            FeedName: follow.FeedName,
+        })
+    }
+    respondWithJSON(w, http.StatusOK, res)
+}
+
+func apiCreateFollow(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    var params struct {
+        FeedURL     string  `json:"feed_url"`
+    }
+    if err := decodeJSON(r, &params); err != nil {
+        respondWithError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if params.FeedURL == "" {
+        respondWithError(w, http.StatusBadRequest, "feed_url is required")
+        return
+    }
+
+    feed, err := s.db.GetFeedByURL(r.Context(), params.FeedURL)
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+
+    follow, err := s.db.CreateFeedFollow(r.Context(), database.CreateFeedFollowParams{
+        ID: uuid.New(),
+        CreatedAt: time.Now(),
+        UpdatedAt: time.Now(),
+        UserID: user.ID,
+        FeedID: feed.ID,
+    })
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+
+    respondWithJSON(w, http.StatusCreated, apiFollow{
+        ID: follow.ID,
+        CreatedAt: follow.CreatedAt,
+        FeedID: follow.FeedID,
+        FeedName: follow.FeedName,
+    })
+}
+
+func apiDeleteFollow(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    feedID, err := uuid.Parse(r.PathValue("feedID"))
+    if err != nil {
+        respondWithError(w, http.StatusBadRequest, "Invalid feed ID")
+        return
+    }
+
+    deleted, err := s.db.DeleteFeedFollow(r.Context(), database.DeleteFeedFollowParams{
+        UserID: user.ID,
+        FeedID: feedID,
+    })
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+    if deleted == 0 {
+        respondWithError(w, http.StatusNotFound, "Not following this feed")
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// apiGetPosts lists posts from followed feeds, newest first. Optional
+// filters: feed_id, since (RFC 3339) and q (case-insensitive title substring,
+// matched literally).
+func apiGetPosts(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    limit, offset, err := pageParams(r)
+    if err != nil {
+        respondWithError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    params := database.GetPostsForUserPageParams{
+        UserID: user.ID,
+        PageLimit: limit,
+        PageOffset: offset,
+    }
+
+    query := r.URL.Query()
+    if value := query.Get("feed_id"); value != "" {
+        feedID, err := uuid.Parse(value)
+        if err != nil {
+            respondWithError(w, http.StatusBadRequest, "Invalid feed_id")
+            return
+        }
+        params.FeedID = uuid.NullUUID{UUID: feedID, Valid: true}
+    }
+    if value := query.Get("since"); value != "" {
+        since, err := time.Parse(time.RFC3339, value)
+        if err != nil {
+            respondWithError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+            return
+        }
+        params.Since = sql.NullTime{Time: since, Valid: true}
+    }
+    if value := query.Get("q"); value != "" {
+        params.Query = sql.NullString{String: value, Valid: true}
+    }
+
+    posts, err := s.db.GetPostsForUserPage(r.Context(), params)
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+
+    res := make([]apiPost, 0, len(posts))
+    for _, post := range(posts) {
+        res = append(res, toAPIPost(post))
+    }
+    respondWithJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+const (
+	apiKeyPrefix    = "gator_"
+	apiKeyPrefixLen = 8
+)
+
+// generateAPIKey returns a new random key together with the short prefix
+// shown in listings and the hash stored in the database. The key itself is
+// never stored.
+func generateAPIKey() (key, prefix, hash string, err error) {
+    key, err = randomToken(apiKeyPrefix)
+    if err != nil {
+        return "", "", "", err
+    }
+
+    secret := strings.TrimPrefix(key, apiKeyPrefix)
+    return key, secret[:apiKeyPrefixLen], hashToken(key), nil
+}
+
+// randomToken returns prefix followed by 32 random bytes, URL-safe encoded.
+func randomToken(prefix string) (string, error) {
+    buf := make([]byte, 32)
+    _, err := rand.Read(buf)
+    if err != nil {
+        return "", fmt.Errorf("Failed to generate token: %w", err)
+    }
+
+    return prefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+func handlerAPIKey(s *state, cmd command, user database.User) error {
+    if len(cmd.args) < 1 {
+        return errors.New("The apikey command expects a subcommand: create, list or revoke")
+    }
+
+    switch cmd.args[0] {
+    case "create":
+        return apiKeyCreate(s, cmd.args[1:], user)
+    case "list":
+        return apiKeyList(s, cmd.args[1:], user)
+    case "revoke":
+        return apiKeyRevoke(s, cmd.args[1:], user)
+    default:
+        return fmt.Errorf("Unknown apikey subcommand: %s", cmd.args[0])
+    }
+}
+
+func apiKeyCreate(s *state, args []string, user database.User) error {
+    if len(args) > 1 {
+        return errors.New("The apikey create command expects ZERO or ONE arguments")
+    }
+
+    name := "default"
+    if len(args) == 1 {
+        name = args[0]
+    }
+
+    key, prefix, hash, err := generateAPIKey()
+    if err != nil {
+        return err
+    }
+
+    _, err = s.db.CreateAPIKey(context.Background(), database.CreateAPIKeyParams{
+        ID: uuid.New(),
+        CreatedAt: time.Now(),
+        UserID: user.ID,
+        Name: name,
+        Prefix: prefix,
+        KeyHash: hash,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to store API key: %w", err)
+    }
+
+    fmt.Printf("API key %s created for %s:\n%s\nStore it now, it will not be shown again.\n", prefix, user.Name, key)
+    return nil
+}
+
+func apiKeyList(s *state, args []string, user database.User) error {
+    if len(args) != 0 {
+        return errors.New("The apikey list command expects ZERO arguments")
+    }
+
+    keys, err := s.db.GetAPIKeysForUser(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to fetch API keys: %w", err)
+    }
+
+    for _, key := range(keys) {
+        status := "active"
+        if key.RevokedAt.Valid {
+            status = "revoked " + key.RevokedAt.Time.Format(time.RFC1123)
+        }
+        lastUsed := "never"
+        if key.LastUsedAt.Valid {
+            lastUsed = key.LastUsedAt.Time.Format(time.RFC1123)
+        }
+
+        fmt.Printf("---\nPrefix: %s\nName: %s\nCreated: %v\nLast used: %s\nStatus: %s\n",
+            key.Prefix, key.Name, key.CreatedAt.Format(time.RFC1123), lastUsed, status)
+    }
+
+    return nil
+}
+
+func apiKeyRevoke(s *state, args []string, user database.User) error {
+    if len(args) != 1 {
+        return errors.New("The apikey revoke command expects ONE argument")
+    }
+
+    prefix := strings.TrimPrefix(args[0], apiKeyPrefix)
+    if len(prefix) > apiKeyPrefixLen {
+        prefix = prefix[:apiKeyPrefixLen]
+    }
+
+    revoked, err := s.db.RevokeAPIKey(context.Background(), database.RevokeAPIKeyParams{
+        UserID: user.ID,
+        Prefix: prefix,
+        RevokedAt: sql.NullTime{Time: time.Now(), Valid: true},
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to revoke API key: %w", err)
+    }
+    if revoked == 0 {
+        return fmt.Errorf("No active API key with prefix %s", prefix)
+    }
+
+    fmt.Printf("API key %s revoked\n", prefix)
+    return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zulkou/blog-aggregator/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const sessionTTL = 30 * 24 * time.Hour
+
+func hashPassword(password string) (sql.NullString, error) {
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return sql.NullString{}, fmt.Errorf("Failed to hash password: %w", err)
+    }
+    return sql.NullString{String: string(hash), Valid: true}, nil
+}
+
+func checkPassword(user database.User, password string) error {
+    if !user.PasswordHash.Valid {
+        return nil
+    }
+
+    err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash.String), []byte(password))
+    if err != nil {
+        return errors.New("Invalid username or password")
+    }
+    return nil
+}
+
+// createSession stores a new session for user and returns its token.
+func createSession(ctx context.Context, s *state, user database.User) (string, error) {
+    buf := make([]byte, 32)
+    _, err := rand.Read(buf)
+    if err != nil {
+        return "", fmt.Errorf("Failed to generate session token: %w", err)
+    }
+    token := base64.RawURLEncoding.EncodeToString(buf)
+
+    err = s.db.CreateSession(ctx, database.CreateSessionParams{
+        TokenHash: hashToken(token),
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(sessionTTL),
+        UserID: user.ID,
+    })
+    if err != nil {
+        return "", fmt.Errorf("Failed to create session: %w", err)
+    }
+
+    return token, nil
+}
+
+// startSession creates a new session for user and stores its token in the
+// config file in place of a bare user name.
+func startSession(s *state, user database.User) error {
+    token, err := createSession(context.Background(), s, user)
+    if err != nil {
+        return err
+    }
+
+    return s.cfg.SetSession(user.Name, token)
+}
+
+// currentUser resolves the logged in user from the session token. Configs
+// written before sessions existed only carry a name, which is still accepted
+// for accounts without a password.
+func currentUser(s *state) (database.User, error) {
+    if s.cfg.SessionToken != "" {
+        user, err := s.db.GetUserBySession(context.Background(), database.GetUserBySessionParams{
+            TokenHash: hashToken(s.cfg.SessionToken),
+            ExpiresAt: time.Now(),
+        })
+        if errors.Is(err, sql.ErrNoRows) {
+            return database.User{}, errors.New("Your session has expired, please log in again")
+        }
+        return user, err
+    }
+
+    if s.cfg.CurrentUserName == "" {
+        return database.User{}, errors.New("You need to logged in to use this function")
+    }
+
+    user, err := s.db.GetUserByName(context.Background(), s.cfg.CurrentUserName)
+    if err != nil {
+        return database.User{}, err
+    }
+    if user.PasswordHash.Valid {
+        return database.User{}, errors.New("This account has a password, please log in again")
+    }
+
+    return user, nil
+}
+
+func handlerPasswd(s *state, cmd command, user database.User) error {
+    if len(cmd.args) != 0 {
+        return errors.New("The passwd command expects ZERO arguments")
+    }
+
+    if user.PasswordHash.Valid {
+        current, err := promptPassword("Current password: ")
+        if err != nil {
+            return err
+        }
+        err = checkPassword(user, current)
+        if err != nil {
+            return err
+        }
+    }
+
+    password, err := promptNewPassword("New password: ")
+    if err != nil {
+        return err
+    }
+    if password == "" {
+        return errors.New("The new password must not be empty")
+    }
+
+    hash, err := hashPassword(password)
+    if err != nil {
+        return err
+    }
+
+    err = s.db.SetUserPassword(context.Background(), database.SetUserPasswordParams{
+        ID: user.ID,
+        PasswordHash: hash,
+        UpdatedAt: time.Now(),
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to store password: %w", err)
+    }
+
+    // Changing the password signs out every other session.
+    err = s.db.DeleteSessionsForUser(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to clear sessions: %w", err)
+    }
+
+    err = startSession(s, user)
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Password updated for %s\n", user.Name)
+    return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+const (
+    // clusterWindow is how far apart in publication two copies of a story
+    // may be.
+    clusterWindow = 72 * time.Hour
+    // clusterMaxDistance is how many of the 64 fingerprint bits two posts
+    // may differ in and still be the same story. A post and an excerpt of
+    // half of it differ in about 11, unrelated posts in over 30.
+    clusterMaxDistance = 12
+
+    // Only the start of a description counts, so a feed carrying the full
+    // text and one carrying an excerpt still match.
+    fingerprintWords = 100
+    // Posts with fewer words than this are too short to tell apart and are
+    // never clustered.
+    fingerprintMinWords = 8
+    fingerprintShingle = 4
+
+    // Splitting fingerprints into one more band than clusterMaxDistance
+    // means two within it agree on at least one whole band.
+    fingerprintBands = clusterMaxDistance + 1
+    fingerprintBandBits = (64 + fingerprintBands - 1) / fingerprintBands
+
+    fingerprintBackfillBatch = 500
+)
+
+func fingerprintTokens(text string) []string {
+    return strings.FieldsFunc(strings.ToLower(plainText(text)), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+}
+
+// mix64 spreads FNV's output over all 64 bits; on its own it leaves the
+// hashes of short strings too alike in the high bits.
+func mix64(x uint64) uint64 {
+    x ^= x >> 30
+    x *= 0xbf58476d1ce4e5b9
+    x ^= x >> 27
+    x *= 0x94d049bb133111eb
+    x ^= x >> 31
+    return x
+}
+
+// simHash fingerprints a post from its title and the start of its
+// description, so near-duplicates get fingerprints a few bits apart. Its
+// features are the overlapping four letter runs of the normalized text,
+// which keeps short posts from hinging on a handful of words.
+func simHash(title, description string) (uint64, bool) {
+    words := fingerprintTokens(description)
+    if len(words) > fingerprintWords {
+        words = words[:fingerprintWords]
+    }
+    words = append(fingerprintTokens(title), words...)
+    if len(words) < fingerprintMinWords {
+        return 0, false
+    }
+
+    var weights [64]int
+    text := []rune(strings.Join(words, " "))
+    for i := 0; i + fingerprintShingle <= len(text); i++ {
+        h := fnv.New64a()
+        h.Write([]byte(string(text[i:i + fingerprintShingle])))
+        sum := mix64(h.Sum64())
+        for bit := range(64) {
+            if sum & (1 << bit) != 0 {
+                weights[bit]++
+            } else {
+                weights[bit]--
+            }
+        }
+    }
+
+    var fingerprint uint64
+    for bit, weight := range(weights) {
+        if weight > 0 {
+            fingerprint |= 1 << bit
+        }
+    }
+    return fingerprint, true
+}
+
+// bands splits a fingerprint into the runs of bits candidates are looked up
+// by, each tagged with its position so equal bits elsewhere do not match.
+// 020_fingerprint_bands.sql computes the same for existing rows.
+func bands(fingerprint uint64) []int32 {
+    res := make([]int32, fingerprintBands)
+    for i := range(fingerprintBands) {
+        run := fingerprint >> (i * fingerprintBandBits) & (1 << fingerprintBandBits - 1)
+        res[i] = int32(i << fingerprintBandBits | int(run))
+    }
+    return res
+}
+
+// clusterPost fingerprints a new post and files it with the closest
+// near-duplicate another feed published within clusterWindow of it, or else
+// starts a cluster of its own. Copies within one feed are left apart.
+func clusterPost(ctx context.Context, s *state, post database.Post) error {
+    fingerprint, ok := simHash(post.Title, post.Description.String)
+    if !ok {
+        return nil
+    }
+
+    postBands := bands(fingerprint)
+    candidates, err := s.db.GetClusterCandidates(ctx, database.GetClusterCandidatesParams{
+        Bands: postBands,
+        Since: post.PublishedAt.Add(-clusterWindow),
+        Until: post.PublishedAt.Add(clusterWindow),
+        FeedID: post.FeedID,
+    })
+    if err != nil {
+        return err
+    }
+
+    cluster := post.ID
+    best := clusterMaxDistance + 1
+    for _, candidate := range(candidates) {
+        distance := bits.OnesCount64(uint64(candidate.Fingerprint) ^ fingerprint)
+        if distance < best {
+            best = distance
+            cluster = candidate.ClusterID
+        }
+    }
+
+    return s.db.CreatePostFingerprint(ctx, database.CreatePostFingerprintParams{
+        PostID: post.ID,
+        PublishedAt: post.PublishedAt,
+        Fingerprint: int64(fingerprint),
+        ClusterID: cluster,
+        FeedID: post.FeedID,
+        Bands: postBands,
+    })
+}
+
+// foldedPost is a post shown for its whole cluster, with the other feeds
+// that carried the story.
+type foldedPost struct {
+    post    database.Post
+    alsoIn  []string
+}
+
+// foldClusters shows each story once, at its newest copy, naming the other
+// feeds that carried it. Posts keep their order.
+func foldClusters(s *state, feeds map[uuid.UUID]database.GetFeedFollowsWithFoldersRow, posts []database.Post) ([]*foldedPost, error) {
+    ids := make([]uuid.UUID, len(posts))
+    for i, post := range(posts) {
+        ids[i] = post.ID
+    }
+    clusters, err := s.db.GetPostClusters(context.Background(), ids)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to fetch post clusters: %w", err)
+    }
+    clusterOf := make(map[uuid.UUID]uuid.UUID, len(clusters))
+    for _, cluster := range(clusters) {
+        clusterOf[cluster.PostID] = cluster.ClusterID
+    }
+
+    var folded []*foldedPost
+    byCluster := make(map[uuid.UUID]*foldedPost)
+    for _, post := range(posts) {
+        cluster, ok := clusterOf[post.ID]
+        if !ok {
+            cluster = post.ID
+        }
+        // A feed's own copies only share a cluster through another feed's
+        // copy, and are listed like separate posts.
+        if shown, ok := byCluster[cluster]; ok && post.FeedID != shown.post.FeedID {
+            feed := feeds[post.FeedID]
+            name := feed.FeedName
+            if feed.Title.Valid {
+                name = feed.Title.String
+            }
+            if !slices.Contains(shown.alsoIn, name) {
+                shown.alsoIn = append(shown.alsoIn, name)
+            }
+            continue
+        }
+        entry := &foldedPost{post: post}
+        if _, ok := byCluster[cluster]; !ok {
+            byCluster[cluster] = entry
+        }
+        folded = append(folded, entry)
+    }
+    return folded, nil
+}
+
+// handlerFingerprint fingerprints and clusters the posts stored before
+// duplicate folding, oldest first, so browse folds them too.
+func handlerFingerprint(s *state, cmd command, _ database.User) error {
+    if len(cmd.args) != 0 {
+        return errors.New("The fingerprint command expects ZERO arguments")
+    }
+
+    params := database.GetUnfingerprintedPostsParams{PageLimit: fingerprintBackfillBatch}
+    done := 0
+    for {
+        posts, err := s.db.GetUnfingerprintedPosts(context.Background(), params)
+        if err != nil {
+            return fmt.Errorf("Failed to fetch posts: %w", err)
+        }
+        for _, post := range(posts) {
+            err = clusterPost(context.Background(), s, post)
+            if err != nil {
+                return fmt.Errorf("Failed to cluster post %s: %w", post.Url, err)
+            }
+        }
+        done += len(posts)
+        if len(posts) < fingerprintBackfillBatch {
+            break
+        }
+        last := posts[len(posts) - 1]
+        params.AfterPublishedAt = last.PublishedAt
+        params.AfterID = last.ID
+        fmt.Printf("Checked %d posts...\n", done)
+    }
+
+    fmt.Printf("Checked %d posts without a fingerprint\n", done)
+    return nil
+}
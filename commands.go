@@ -5,16 +5,28 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
-    "strings"
 
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/zulkou/blog-aggregator/internal/database"
-	"github.com/zulkou/blog-aggregator/rss"
 )
 
+const (
+    roleAdmin   = "admin"
+    roleMember  = "member"
+)
+
+// isAdmin reports whether user may act as an admin. Anyone with access to
+// the database can log in to an account without a password, so the role
+// only counts once the account has one.
+func isAdmin(user database.User) bool {
+    return user.Role == roleAdmin && user.PasswordHash.Valid
+}
+
 type command struct {
     name    string
     args    []string
@@ -39,11 +51,7 @@ func (c *commands) run(s *state, cmd command) error {
 
 func middlewareLoggedIn(handler func(s *state, cmd command, user database.User) error) func(*state, command) error {
     return func(s *state, cmd command) error {
-        if s.cfg.CurrentUserName == "" {
-            return errors.New("You need to logged in to use this function")
-        }
-
-        user, err := s.db.GetUserByName(context.Background(), s.cfg.CurrentUserName)
+        user, err := currentUser(s)
         if err != nil {
             return fmt.Errorf("Authentication error: %w", err)
         }
@@ -52,77 +60,65 @@ func middlewareLoggedIn(handler func(s *state, cmd command, user database.User)
     }
 }
 
-func scrapeFeeds(s *state) error {
-    feed, err := s.db.GetNextFeedToFetch(context.Background())
-    if err != nil {
-        fmt.Printf("Reading DB fault: %v\n", err)
-        return fmt.Errorf("Failed to fetch next feed: %w", err)
-    }
+// middlewareAdmin is middlewareLoggedIn restricted to users with the admin role.
+func middlewareAdmin(handler func(s *state, cmd command, user database.User) error) func(*state, command) error {
+    return middlewareLoggedIn(func(s *state, cmd command, user database.User) error {
+        if user.Role != roleAdmin {
+            return fmt.Errorf("The %s command is restricted to admins", cmd.name)
+        }
+        if !isAdmin(user) {
+            return fmt.Errorf("The %s command needs an admin account with a password, set one with passwd", cmd.name)
+        }
 
-    err = s.db.MarkFeedFetched(context.Background(), database.MarkFeedFetchedParams{
-        ID: feed.ID,
-        LastFetchedAt: sql.NullTime{Time: time.Now(), Valid: true},
-        UpdatedAt: time.Now(),
+        return handler(s, cmd, user)
     })
-    if err != nil {
-        fmt.Printf("Marking fault: %v\n", err)
-        return fmt.Errorf("Failed to mark fetched feed: %w", err)
-    }
+}
 
-    rssfeed, err := rss.FetchFeed(context.Background(), feed.Url)
-    if err != nil {
-        fmt.Printf("Fetching fault: %v\n", err)
-        return fmt.Errorf("Failed to fetch feed content: %w", err)
+// splitFlags separates boolean --flags from positional arguments. Only the
+// listed flag names are accepted.
+func splitFlags(args []string, allowed ...string) (map[string]bool, []string, error) {
+    flags := make(map[string]bool)
+    var rest []string
+
+    for _, arg := range(args) {
+        name, ok := strings.CutPrefix(arg, "--")
+        if !ok {
+            rest = append(rest, arg)
+            continue
+        }
+        if !slices.Contains(allowed, name) {
+            return nil, nil, fmt.Errorf("Unknown flag: %s", arg)
+        }
+        flags[name] = true
     }
 
-    for _, rssitem := range(rssfeed.Channel.Item) {
-        pubDate, err := time.Parse(time.RFC1123Z, rssitem.PubDate)
-        if err != nil {
-            pubDate, err = time.Parse(time.RFC1123, rssitem.PubDate)
-            if err != nil {
-                pubDate, err = time.Parse(time.RFC822, rssitem.PubDate)
-                if err != nil {
-                    pubDate, err = time.Parse("2006-01-02T15:04:05Z", rssitem.PubDate)
-                    if err != nil {
-                        fmt.Printf("Could not parse date: %s, error: %v\n", rssitem.PubDate, err)
-                        pubDate = time.Now()
-                    }
-                }
-            }
-        }
+    return flags, rest, nil
+}
 
-        var description sql.NullString
-        if rssitem.Description != "" {
-            description = sql.NullString{
-                String: rssitem.Description,
-                Valid: true,
-            }
-        } else {
-            description = sql.NullString{
-                Valid: false,
-            }
+// cutOption removes "--name value" or "--name=value" from args and returns
+// the value, or an empty string when the option is absent.
+func cutOption(args []string, name string) (string, []string, error) {
+    var value string
+    var rest []string
+
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        if v, ok := strings.CutPrefix(arg, "--" + name + "="); ok {
+            value = v
+            continue
         }
-
-        _, err = s.db.CreatePost(context.Background(), database.CreatePostParams{
-            ID: uuid.New(),
-            CreatedAt: time.Now(),
-            UpdatedAt: time.Now(),
-            Title: rssitem.Title,
-            Url: rssitem.Link,
-            Description: description,
-            PublishedAt: pubDate,
-            FeedID: feed.ID,
-        })
-        if err != nil {
-            if strings.Contains(err.Error(), "unique constraint") || 
-               strings.Contains(err.Error(), "duplicate key") {
-                continue
+        if arg == "--" + name {
+            if i + 1 >= len(args) {
+                return "", nil, fmt.Errorf("The --%s option expects a value", name)
             }
-            fmt.Printf("Failed to store %s: %v\n", rssitem.Title, err)
+            value = args[i + 1]
+            i++
+            continue
         }
+        rest = append(rest, arg)
     }
 
-    return nil
+    return value, rest, nil
 }
 
 func handlerLogin(s *state, cmd command) error {
@@ -132,14 +128,25 @@ func handlerLogin(s *state, cmd command) error {
 
     name := cmd.args[0]
 
-    _, err := s.db.GetUserByName(context.Background(), name)
+    user, err := s.db.GetUserByName(context.Background(), name)
     if err != nil {
         return fmt.Errorf("Failed to retrieve %v: %w\n", name, err)
     }
 
-    err = s.cfg.SetUser(name)
+    if user.PasswordHash.Valid {
+        password, err := promptPassword("Password: ")
+        if err != nil {
+            return err
+        }
+        err = checkPassword(user, password)
+        if err != nil {
+            return err
+        }
+    }
+
+    err = startSession(s, user)
     if err != nil {
-        return fmt.Errorf("User not found: %w\n", err)
+        return fmt.Errorf("User failed to login: %w\n", err)
     }
 
     fmt.Printf("State assigned to %s, Welcome!\n", s.cfg.CurrentUserName)
@@ -160,12 +167,44 @@ func handlerRegister(s *state, cmd command) error {
         return fmt.Errorf("Error checking if user exists: %v\n", err)
     }
 
-    _, err = s.db.CreateUser(context.Background(), database.CreateUserParams{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now(), Name: name})
+    password, err := promptNewPassword("Password (leave empty for none): ")
+    if err != nil {
+        return err
+    }
+
+    user, err := s.db.CreateUser(context.Background(), database.CreateUserParams{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now(), Name: name})
     if err != nil {
         return fmt.Errorf("Failed to create user: %w\n", err)
     }
+
+    if password != "" {
+        hash, err := hashPassword(password)
+        if err != nil {
+            return err
+        }
+        err = s.db.SetUserPassword(context.Background(), database.SetUserPasswordParams{
+            ID: user.ID,
+            PasswordHash: hash,
+            UpdatedAt: time.Now(),
+        })
+        if err != nil {
+            return fmt.Errorf("Failed to store password: %w\n", err)
+        }
+    }
     
-    err = s.cfg.SetUser(name)
+    // The first account on a fresh database administers it.
+    admin, err := grantFirstAdmin(s, user)
+    if err != nil {
+        return fmt.Errorf("Failed to grant admin role: %w", err)
+    }
+    if admin {
+        fmt.Printf("%s is the first user and has been made admin\n", name)
+        if password == "" {
+            fmt.Println("Admin commands stay locked until the account has a password, set one with passwd")
+        }
+    }
+
+    err = startSession(s, user)
     if err != nil {
         return errors.New(fmt.Sprintf(("User failed to login: %s"), name))
     }
@@ -175,12 +214,65 @@ func handlerRegister(s *state, cmd command) error {
     return nil
 }
 
-func handlerReset(s *state, cmd command) error {
-    if len(cmd.args) != 0 {
+// grantFirstAdmin makes user admin when nobody is yet. The check and the
+// grant happen under a lock, so two registrations at once cannot both find
+// no admin.
+func grantFirstAdmin(s *state, user database.User) (bool, error) {
+    tx, err := s.conn.BeginTx(context.Background(), nil)
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    qtx := s.db.WithTx(tx)
+    err = qtx.LockRoles(context.Background())
+    if err != nil {
+        return false, err
+    }
+    granted, err := qtx.GrantFirstAdmin(context.Background(), database.GrantFirstAdminParams{
+        ID: user.ID,
+        UpdatedAt: time.Now(),
+    })
+    if err != nil {
+        return false, err
+    }
+
+    return granted > 0, tx.Commit()
+}
+
+func handlerReset(s *state, cmd command, user database.User) error {
+    flags, args, err := splitFlags(cmd.args, "yes", "dry-run")
+    if err != nil {
+        return err
+    }
+    if len(args) != 0 {
         return errors.New("The reset command expects ZERO arguments")
     }
 
-    err := s.db.DeleteUsers(context.Background())
+    counts, err := s.db.GetDatabaseCounts(context.Background())
+    if err != nil {
+        return fmt.Errorf("Failed counting database rows: %w", err)
+    }
+
+    summary := fmt.Sprintf("Reset will delete %d users, %d feeds, %d follows and %d posts.",
+        counts.Users, counts.Feeds, counts.FeedFollows, counts.Posts)
+
+    if flags["dry-run"] {
+        fmt.Println(summary)
+        return nil
+    }
+
+    if !flags["yes"] {
+        confirmed, err := confirmAction(summary, "reset")
+        if err != nil {
+            return err
+        }
+        if !confirmed {
+            return errors.New("Reset aborted")
+        }
+    }
+
+    err = s.db.DeleteUsers(context.Background())
     if err != nil {
         return fmt.Errorf("Failed resetting database: %v\n", err)
     }
@@ -201,19 +293,63 @@ func handlerUsers(s *state, cmd command) error {
     }
 
     for _, user := range(users) {
+        name := user.Name
+        if user.Role == roleAdmin {
+            name += " [admin]"
+        }
+
         if s.cfg.CurrentUserName == user.Name {
-            fmt.Printf("* %s (current)\n", user.Name)
+            fmt.Printf("* %s (current)\n", name)
         } else {
-            fmt.Printf("* %s\n", user.Name) 
+            fmt.Printf("* %s\n", name) 
         }
     }
 
     return nil
 }
 
+func handlerRole(s *state, cmd command, admin database.User) error {
+    if len(cmd.args) != 2 {
+        return errors.New("The role command expects TWO arguments")
+    }
+
+    name := cmd.args[0]
+    role := cmd.args[1]
+    if role != roleAdmin && role != roleMember {
+        return fmt.Errorf("Unknown role %s, expected %s or %s", role, roleAdmin, roleMember)
+    }
+
+    user, err := s.db.GetUserByName(context.Background(), name)
+    if err != nil {
+        return fmt.Errorf("Failed to retrieve %v: %w", name, err)
+    }
+
+    if user.Role == roleAdmin && role != roleAdmin {
+        admins, err := s.db.CountAdmins(context.Background())
+        if err != nil {
+            return fmt.Errorf("Failed to count admins: %w", err)
+        }
+        if admins <= 1 {
+            return errors.New("Refusing to demote the last admin")
+        }
+    }
+
+    err = s.db.SetUserRole(context.Background(), database.SetUserRoleParams{
+        ID: user.ID,
+        Role: role,
+        UpdatedAt: time.Now(),
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to update role: %w", err)
+    }
+
+    fmt.Printf("%s is now %s\n", user.Name, role)
+    return nil
+}
+
 func handlerAgg(s *state, cmd command) error {
-    if len(cmd.args) != 1 {
-        return errors.New("The agg command expects ONE arguments")
+    if len(cmd.args) < 1 || len(cmd.args) > 2 {
+        return errors.New("The agg command expects ONE or TWO arguments")
     }
 
     time_between_reqs, err := time.ParseDuration(cmd.args[0])
@@ -221,13 +357,14 @@ func handlerAgg(s *state, cmd command) error {
         return fmt.Errorf("Failed to parse input args: %w", err)
     }
 
-    fmt.Printf("Collecting feeds every %v\n", time_between_reqs)
-
-    ticker := time.NewTicker(time_between_reqs)
-    for ; ; <-ticker.C {
-        scrapeFeeds(s)
+    if len(cmd.args) == 2 {
+        err = serveMetrics(cmd.args[1])
+        if err != nil {
+            return err
+        }
     }
 
+    runScraper(s, time_between_reqs)
     return nil
 }
 
@@ -239,7 +376,19 @@ func handlerAddFeed(s *state, cmd command, user database.User) error {
     url := cmd.args[0]
     name := cmd.args[1]
 
-    feed, err := s.db.CreateFeed(context.Background(), database.CreateFeedParams{
+    feed, err := addFeed(context.Background(), s, user, name, url)
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Name: %v\nURL: %v\n-- Current user automatically follow created Feed --\n", feed.Name, feed.Url)
+
+    return nil
+}
+
+// addFeed creates a feed owned by user and makes them follow it.
+func addFeed(ctx context.Context, s *state, user database.User, name, url string) (database.Feed, error) {
+    feed, err := s.db.CreateFeed(ctx, database.CreateFeedParams{
         ID: uuid.New(),
         CreatedAt: time.Now(),
         UpdatedAt: time.Now(),
@@ -249,10 +398,10 @@ func handlerAddFeed(s *state, cmd command, user database.User) error {
     })
 
     if err != nil {
-        return fmt.Errorf("Failed to store feed to db: %w", err)
+        return database.Feed{}, fmt.Errorf("Failed to store feed to db: %w", err)
     }
 
-    _, err = s.db.CreateFeedFollow(context.Background(), database.CreateFeedFollowParams{
+    _, err = s.db.CreateFeedFollow(ctx, database.CreateFeedFollowParams{
         ID: uuid.New(),
         CreatedAt: time.Now(),
         UpdatedAt: time.Now(),
@@ -261,12 +410,10 @@ func handlerAddFeed(s *state, cmd command, user database.User) error {
     })
 
     if err != nil {
-        return fmt.Errorf("Failed to auto-follow after feed creation: %w", err)
+        return database.Feed{}, fmt.Errorf("Failed to auto-follow after feed creation: %w", err)
     }
 
-    fmt.Printf("Name: %v\nURL: %v\n-- Current user automatically follow created Feed --\n", feed.Name, feed.Url)
-
-    return nil
+    return feed, nil
 }
 
 func handlerFeeds(s *state, cmd command) error {
@@ -286,6 +433,9 @@ func handlerFeeds(s *state, cmd command) error {
         }
 
         fmt.Printf("---\nName: %v\nURL: %v\nUser: %v\n", feed.Name, feed.Url, user.Name)
+        if feed.DeadAt.Valid {
+            fmt.Printf("Gone since: %v\n", feed.DeadAt.Time.Format(time.RFC1123))
+        }
     }
 
     return nil
@@ -325,14 +475,35 @@ func handlerFollowing(s *state, cmd command, user database.User) error {
         return errors.New("The following command expects ZERO arguments")
     }
 
-    feeds, err := s.db.GetFeedFollowsForUser(context.Background(), user.ID)
+    feeds, err := s.db.GetFeedFollowsWithFolders(context.Background(), user.ID)
     if err != nil {
         return fmt.Errorf("Failed to fetch feed data for current user: %w", err)
     }
 
+    // Rows come sorted by folder with unfiled feeds last, and a feed filed
+    // in several folders is listed under each of them. Headings are only
+    // shown once the user has filed something.
+    hasFolders := len(feeds) > 0 && feeds[0].Folder.Valid
+    heading := ""
+
     fmt.Printf("Feeds followed by %s:\n", user.Name)
-    for _, feed := range(feeds) {
-        fmt.Printf("- %s\n", feed.FeedName)
+    for i, feed := range(feeds) {
+        if hasFolders {
+            current := "Unfiled"
+            if feed.Folder.Valid {
+                current = feed.Folder.String
+            }
+            if i == 0 || current != heading {
+                fmt.Printf("%s:\n", current)
+                heading = current
+            }
+        }
+
+        if feed.Title.Valid {
+            fmt.Printf("- %s (%s)\n", feed.Title.String, feed.FeedName)
+        } else {
+            fmt.Printf("- %s\n", feed.FeedName)
+        }
     }
 
     return nil
@@ -350,43 +521,191 @@ func handlerUnfollow(s *state, cmd command, user database.User) error {
         return fmt.Errorf("Failed to fetch feed: %w", err)
     }
 
-    err = s.db.DeleteFeedFollow(context.Background(), database.DeleteFeedFollowParams{
+    deleted, err := s.db.DeleteFeedFollow(context.Background(), database.DeleteFeedFollowParams{
         UserID: user.ID,
         FeedID: feed.ID,
     })
+    if err != nil {
+        return fmt.Errorf("Failed to unfollow feed: %w", err)
+    }
+    if deleted == 0 {
+        return fmt.Errorf("You are not following %s", feed.Name)
+    }
 
-    fmt.Printf("Successfully unfollowed %s", feed.Name)
+    fmt.Printf("Successfully unfollowed %s\n", feed.Name)
 
     return nil
 }
 
 func handlerBrowse(s *state, cmd command, user database.User) error {
-    if len(cmd.args) > 1 {
+    folder, args, err := cutOption(cmd.args, "folder")
+    if err != nil {
+        return err
+    }
+    if len(args) > 1 {
         return errors.New("The browse command expects ZERO or ONE arguments")
     }
 
     var limit int32
-    if len(cmd.args) == 1 {
-        parsed, err := strconv.ParseInt(cmd.args[0], 10, 32)
+    if len(args) == 1 {
+        parsed, err := strconv.ParseInt(args[0], 10, 32)
         if err != nil {
             return fmt.Errorf("Failed to convert input into integer: %w", err)
         }
+        if parsed < 1 {
+            return errors.New("The browse limit must be at least 1")
+        }
         limit = int32(parsed)
     } else {
         limit = 2
     }
 
-    posts, err := s.db.GetPostsForUser(context.Background(), database.GetPostsForUserParams{
+    feeds, err := followedFeeds(s, user)
+    if err != nil {
+        return err
+    }
+
+    // Folding shrinks the page, so more posts are read until limit entries
+    // are left or there are no more posts.
+    var entries []*foldedPost
+    for fetch := limit; ; fetch *= 2 {
+        posts, err := visiblePosts(context.Background(), s, database.GetPostsForUserPageParams{
+            UserID: user.ID,
+            Folder: sql.NullString{String: folder, Valid: folder != ""},
+            PageLimit: fetch,
+        }, feeds)
+        if err != nil {
+            return err
+        }
+        entries, err = foldClusters(s, feeds, posts)
+        if err != nil {
+            return err
+        }
+        if len(entries) >= int(limit) || len(posts) < int(fetch) {
+            break
+        }
+    }
+
+    return printPosts(s, user, entries[:min(len(entries), int(limit))])
+}
+
+// printPosts lists folded posts with the user's read, star and tag state.
+func printPosts(s *state, user database.User, entries []*foldedPost) error {
+    ids := make([]uuid.UUID, len(entries))
+    for i, entry := range(entries) {
+        ids[i] = entry.post.ID
+    }
+
+    states, err := s.db.GetPostStates(context.Background(), database.GetPostStatesParams{
+        UserID: user.ID,
+        PostIds: ids,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to fetch post state: %w", err)
+    }
+    stateByPost := make(map[uuid.UUID]database.PostState, len(states))
+    for _, state := range(states) {
+        stateByPost[state.PostID] = state
+    }
+
+    tags, err := s.db.GetPostTags(context.Background(), database.GetPostTagsParams{
         UserID: user.ID,
+        PostIds: ids,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to fetch post tags: %w", err)
+    }
+    tagsByPost := make(map[uuid.UUID][]string)
+    for _, tag := range(tags) {
+        tagsByPost[tag.PostID] = append(tagsByPost[tag.PostID], "#" + tag.Tag)
+    }
+
+    for _, entry := range(entries) {
+        post := entry.post
+        state := stateByPost[post.ID]
+
+        line := "- "
+        if state.StarredAt.Valid {
+            line += "* "
+        }
+        line += post.Title
+        if state.ReadAt.Valid {
+            line += " (read)"
+        }
+        if len(tagsByPost[post.ID]) > 0 {
+            line += " " + strings.Join(tagsByPost[post.ID], " ")
+        }
+        if len(entry.alsoIn) > 0 {
+            line += " (also in: " + strings.Join(entry.alsoIn, ", ") + ")"
+        }
+        fmt.Println(line)
+    }
+
+    return nil
+}
+
+func handlerFetchLog(s *state, cmd command) error {
+    if len(cmd.args) > 1 {
+        return errors.New("The fetchlog command expects ZERO or ONE arguments")
+    }
+
+    const limit = 20
+
+    if len(cmd.args) == 0 {
+        fetches, err := s.db.GetFeedFetches(context.Background(), limit)
+        if err != nil {
+            return fmt.Errorf("Failed to fetch history: %w", err)
+        }
+
+        for _, fetch := range(fetches) {
+            printFeedFetch(fetch.FeedName, database.FeedFetch{
+                ID: fetch.ID,
+                FeedID: fetch.FeedID,
+                StartedAt: fetch.StartedAt,
+                FinishedAt: fetch.FinishedAt,
+                StatusCode: fetch.StatusCode,
+                Bytes: fetch.Bytes,
+                ItemCount: fetch.ItemCount,
+                NewPosts: fetch.NewPosts,
+                UpdatedPosts: fetch.UpdatedPosts,
+                Error: fetch.Error,
+            })
+        }
+
+        return nil
+    }
+
+    feed, err := s.db.GetFeedByURL(context.Background(), cmd.args[0])
+    if err != nil {
+        return fmt.Errorf("Failed to retrieve feed with provided URL: %w", err)
+    }
+
+    fetches, err := s.db.GetFeedFetchesForFeed(context.Background(), database.GetFeedFetchesForFeedParams{
+        FeedID: feed.ID,
         Limit: limit,
     })
     if err != nil {
-        return fmt.Errorf("Failed to fetch posts: %w", err)
+        return fmt.Errorf("Failed to fetch history: %w", err)
     }
 
-    for _, post := range(posts) {
-        fmt.Printf("- %s\n", post.Title)
+    for _, fetch := range(fetches) {
+        printFeedFetch(feed.Name, fetch)
     }
 
     return nil
 }
+
+func printFeedFetch(feedName string, fetch database.FeedFetch) {
+    fmt.Printf("---\nFeed: %s\nStarted: %v\nDuration: %v\n",
+        feedName,
+        fetch.StartedAt.Format(time.RFC1123),
+        fetch.FinishedAt.Sub(fetch.StartedAt).Round(time.Millisecond),
+    )
+    if fetch.StatusCode.Valid {
+        fmt.Printf("Status: %d\n", fetch.StatusCode.Int32)
+    }
+    fmt.Printf("Bytes: %d\nItems: %d (%d new, %d updated)\n", fetch.Bytes, fetch.ItemCount, fetch.NewPosts, fetch.UpdatedPosts)
+    if fetch.Error.Valid {
+        fmt.Printf("Error: %s\n", fetch.Error.String)
+    }
+}
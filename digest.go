@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+	"github.com/zulkou/blog-aggregator/notify"
+)
+
+const (
+    digestOff       = "off"
+    digestDaily     = "daily"
+    digestWeekly    = "weekly"
+)
+
+var digestFrequencies = []string{digestOff, digestDaily, digestWeekly}
+
+const (
+    // digestMaxPosts caps a single digest so a long absence does not
+    // produce an unreadable mail. The posts past it go out in the next one.
+    digestMaxPosts = 200
+
+    // digestCheckInterval is how often agg looks for digests that are due.
+    digestCheckInterval = 15 * time.Minute
+
+    digestSummaryLength = 280
+)
+
+type digestPost struct {
+    Title       string
+    URL         string
+    PublishedAt time.Time
+    Summary     string
+}
+
+type digestFeed struct {
+    Name    string
+    Posts   []digestPost
+}
+
+// digestData is what the digest templates are rendered with. More is set
+// when the digest was cut short at digestMaxPosts, and Until is then the
+// creation time of the last post it covers.
+type digestData struct {
+    User    string
+    Since   time.Time
+    Until   time.Time
+    Total   int
+    More    bool
+    Feeds   []digestFeed
+}
+
+func digestPeriod(frequency string) time.Duration {
+    if frequency == digestWeekly {
+        return 7 * 24 * time.Hour
+    }
+    return 24 * time.Hour
+}
+
+// digestSince is where the next digest starts: the end of the last one, or
+// one period back for a first digest.
+func digestSince(digest database.Digest, until time.Time) time.Time {
+    if digest.LastDigestAt.Valid {
+        return digest.LastDigestAt.Time
+    }
+    return until.Add(-digestPeriod(digest.Frequency))
+}
+
+// collectDigest gathers the unread posts a user's follows got between since
+// and until, grouped by feed and without hidden posts. Posts are read oldest
+// first, so when there are more than digestMaxPosts the digest covers the
+// start of the window and the rest is left for the next one.
+func collectDigest(ctx context.Context, s *state, userID uuid.UUID, userName string, since, until time.Time) (digestData, error) {
+    data := digestData{
+        User: userName,
+        Since: since,
+        Until: until,
+    }
+
+    posts, err := s.db.GetDigestPosts(ctx, database.GetDigestPostsParams{
+        UserID: userID,
+        Since: since,
+        Until: until,
+        MaxPosts: digestMaxPosts,
+    })
+    if err != nil {
+        return digestData{}, fmt.Errorf("Failed to fetch digest posts: %w", err)
+    }
+
+    hideRules, err := getHideRules(s, userID)
+    if err != nil {
+        return digestData{}, err
+    }
+
+    if len(posts) == digestMaxPosts {
+        // Posts stored at the same instant as the last one may not all have
+        // fit, so those wait for the next digest together.
+        last := posts[len(posts) - 1].CreatedAt
+        cut := len(posts)
+        for cut > 1 && posts[cut - 1].CreatedAt.Equal(last) {
+            cut--
+        }
+        if cut < len(posts) && !posts[cut - 1].CreatedAt.Equal(last) {
+            posts = posts[:cut]
+        }
+        data.More = true
+        data.Until = posts[len(posts) - 1].CreatedAt
+    }
+
+    feedIndex := make(map[string]int)
+    for _, row := range(posts) {
+        post := database.Post{
+            ID: row.ID,
+            Title: row.Title,
+            Url: row.Url,
+            Description: row.Description,
+            PublishedAt: row.PublishedAt,
+            FeedID: row.FeedID,
+            Author: row.Author,
+            Categories: row.Categories,
+        }
+        if hiddenByRules(hideRules, newRulePost(post, row.FeedName, row.FeedUrl)) {
+            continue
+        }
+
+        i, ok := feedIndex[row.FeedName]
+        if !ok {
+            i = len(data.Feeds)
+            feedIndex[row.FeedName] = i
+            data.Feeds = append(data.Feeds, digestFeed{Name: row.FeedName})
+        }
+        feed := &data.Feeds[i]
+        feed.Posts = append(feed.Posts, digestPost{
+            Title: row.Title,
+            URL: row.Url,
+            PublishedAt: row.PublishedAt,
+            Summary: truncate(plainText(row.Description.String), digestSummaryLength),
+        })
+        data.Total++
+    }
+
+    slices.SortFunc(data.Feeds, func(a, b digestFeed) int { return strings.Compare(a.Name, b.Name) })
+    for _, feed := range(data.Feeds) {
+        slices.SortFunc(feed.Posts, func(a, b digestPost) int { return b.PublishedAt.Compare(a.PublishedAt) })
+    }
+
+    return data, nil
+}
+
+// renderDigest renders both templates and wraps them in a multipart mail.
+func renderDigest(s *state, mailer notify.Mailer, to string, data digestData) ([]byte, error) {
+    textTmpl, err := parseTextTemplate(s.cfg.DigestTemplates, "digest.txt")
+    if err != nil {
+        return nil, fmt.Errorf("Failed to load text digest template: %w", err)
+    }
+    htmlTmpl, err := parseHTMLTemplate(s.cfg.DigestTemplates, "digest.html")
+    if err != nil {
+        return nil, fmt.Errorf("Failed to load HTML digest template: %w", err)
+    }
+
+    var msg bytes.Buffer
+    subject := fmt.Sprintf("Your gator digest: %d new post", data.Total)
+    if data.Total != 1 {
+        subject += "s"
+    }
+    mailer.Header(&msg, []string{to}, subject)
+
+    parts := multipart.NewWriter(&msg)
+    fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+
+    // Plain text first: clients show the last alternative they understand.
+    for _, part := range([]struct{
+        contentType string
+        execute     func(*quotedprintable.Writer) error
+    }{
+        {"text/plain; charset=utf-8", func(w *quotedprintable.Writer) error { return textTmpl.Execute(w, data) }},
+        {"text/html; charset=utf-8", func(w *quotedprintable.Writer) error { return htmlTmpl.Execute(w, data) }},
+    }) {
+        pw, err := parts.CreatePart(textproto.MIMEHeader{
+            "Content-Type": {part.contentType},
+            "Content-Transfer-Encoding": {"quoted-printable"},
+        })
+        if err != nil {
+            return nil, fmt.Errorf("Failed to build digest mail: %w", err)
+        }
+        qp := quotedprintable.NewWriter(pw)
+        err = part.execute(qp)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to render digest: %w", err)
+        }
+        err = qp.Close()
+        if err != nil {
+            return nil, fmt.Errorf("Failed to build digest mail: %w", err)
+        }
+    }
+
+    err = parts.Close()
+    if err != nil {
+        return nil, fmt.Errorf("Failed to build digest mail: %w", err)
+    }
+
+    return msg.Bytes(), nil
+}
+
+// sendDigest mails the digest for the window ending at until and moves the
+// user's last_digest_at to the end of what it covered, so no post is sent
+// twice or skipped. Nothing is mailed when there is nothing new.
+func sendDigest(ctx context.Context, s *state, digest database.Digest, userName string, until time.Time) (int, error) {
+    data, err := collectDigest(ctx, s, digest.UserID, userName, digestSince(digest, until), until)
+    if err != nil {
+        return 0, err
+    }
+
+    if data.Total > 0 {
+        mailer := newMailer(s)
+        msg, err := renderDigest(s, mailer, digest.Email, data)
+        if err != nil {
+            return 0, err
+        }
+
+        err = mailer.Send(ctx, []string{digest.Email}, msg)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    err = s.db.SetLastDigestAt(ctx, database.SetLastDigestAtParams{
+        UserID: digest.UserID,
+        LastDigestAt: sql.NullTime{Time: data.Until, Valid: true},
+    })
+    if err != nil {
+        dbErrors.WithLabelValues("SetLastDigestAt").Inc()
+        return data.Total, fmt.Errorf("Digest sent but failed to record it: %w", err)
+    }
+
+    return data.Total, nil
+}
+
+// sendDueDigests is the scheduled mode run from the scraper loop.
+func sendDueDigests(s *state) error {
+    now := time.Now()
+    due, err := s.db.GetDueDigests(context.Background(), database.GetDueDigestsParams{
+        DailyBefore: sql.NullTime{Time: now.Add(-digestPeriod(digestDaily)), Valid: true},
+        WeeklyBefore: sql.NullTime{Time: now.Add(-digestPeriod(digestWeekly)), Valid: true},
+    })
+    if err != nil {
+        dbErrors.WithLabelValues("GetDueDigests").Inc()
+        return fmt.Errorf("Failed to fetch due digests: %w", err)
+    }
+
+    for _, row := range(due) {
+        digest := database.Digest{
+            UserID: row.UserID,
+            Email: row.Email,
+            Frequency: row.Frequency,
+            LastDigestAt: row.LastDigestAt,
+        }
+
+        sent, err := sendDigest(context.Background(), s, digest, row.UserName, now)
+        if err != nil {
+            slog.Error("Failed to send digest", "user", row.UserName, "error", err)
+            continue
+        }
+        slog.Info("Digest processed", "user", row.UserName, "frequency", row.Frequency, "posts", sent)
+    }
+
+    return nil
+}
+
+func handlerDigest(s *state, cmd command, user database.User) error {
+    if len(cmd.args) == 0 {
+        return digestShow(s, user)
+    }
+
+    switch cmd.args[0] {
+    case "set":
+        return digestSet(s, cmd.args[1:], user)
+    case "send":
+        return digestSend(s, cmd.args[1:], user)
+    default:
+        return fmt.Errorf("Unknown digest subcommand: %s", cmd.args[0])
+    }
+}
+
+func digestShow(s *state, user database.User) error {
+    digest, err := s.db.GetDigest(context.Background(), user.ID)
+    if errors.Is(err, sql.ErrNoRows) {
+        fmt.Println("No digest configured, set one up with: digest set <email> <daily|weekly>")
+        return nil
+    } else if err != nil {
+        return fmt.Errorf("Failed to fetch digest settings: %w", err)
+    }
+
+    last := "never"
+    if digest.LastDigestAt.Valid {
+        last = digest.LastDigestAt.Time.Format(time.RFC1123)
+    }
+    fmt.Printf("Email: %s\nFrequency: %s\nLast digest: %s\n", digest.Email, digest.Frequency, last)
+    return nil
+}
+
+func digestSet(s *state, args []string, user database.User) error {
+    if len(args) != 2 {
+        return errors.New("The digest set command expects TWO arguments: <email> <daily|weekly|off>")
+    }
+
+    addr, err := mail.ParseAddress(args[0])
+    if err != nil {
+        return fmt.Errorf("Invalid email address: %w", err)
+    }
+    frequency := args[1]
+    if !slices.Contains(digestFrequencies, frequency) {
+        return fmt.Errorf("Unknown frequency %s, expected daily, weekly or off", frequency)
+    }
+
+    digest, err := s.db.UpsertDigest(context.Background(), database.UpsertDigestParams{
+        UserID: user.ID,
+        CreatedAt: time.Now(),
+        UpdatedAt: time.Now(),
+        Email: addr.Address,
+        Frequency: frequency,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to store digest settings: %w", err)
+    }
+
+    if digest.Frequency == digestOff {
+        fmt.Println("Digest turned off")
+    } else {
+        fmt.Printf("A %s digest will be sent to %s while agg is running\n", digest.Frequency, digest.Email)
+    }
+    return nil
+}
+
+// digestSend sends the current user's digest right away, or with --dry-run
+// prints its text version without sending or recording anything.
+func digestSend(s *state, args []string, user database.User) error {
+    flags, args, err := splitFlags(args, "dry-run")
+    if err != nil {
+        return err
+    }
+    if len(args) != 0 {
+        return errors.New("The digest send command expects no arguments besides --dry-run")
+    }
+
+    digest, err := s.db.GetDigest(context.Background(), user.ID)
+    if errors.Is(err, sql.ErrNoRows) {
+        return errors.New("No digest configured, set one up with: digest set <email> <daily|weekly>")
+    } else if err != nil {
+        return fmt.Errorf("Failed to fetch digest settings: %w", err)
+    }
+
+    now := time.Now()
+    if flags["dry-run"] {
+        data, err := collectDigest(context.Background(), s, user.ID, user.Name, digestSince(digest, now), now)
+        if err != nil {
+            return err
+        }
+        tmpl, err := parseTextTemplate(s.cfg.DigestTemplates, "digest.txt")
+        if err != nil {
+            return fmt.Errorf("Failed to load text digest template: %w", err)
+        }
+        return tmpl.Execute(os.Stdout, data)
+    }
+
+    sent, err := sendDigest(context.Background(), s, digest, user.Name, now)
+    if err != nil {
+        return err
+    }
+    if sent == 0 {
+        fmt.Println("Nothing new since the last digest")
+    } else {
+        fmt.Printf("Digest with %d posts sent to %s\n", sent, digest.Email)
+    }
+    return nil
+}
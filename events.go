@@ -0,0 +1,264 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+const (
+    // postEventsChannel is the Postgres channel NotifyNewPost announces
+    // stored posts on.
+    postEventsChannel = "gator_new_post"
+
+    eventsKeepAlive = 30 * time.Second
+    // eventsBuffer is how many posts a slow client may fall behind by before
+    // it misses some.
+    eventsBuffer = 64
+    // eventsReplayPage is how many posts a reconnecting client is sent at a
+    // time while it catches up.
+    eventsReplayPage = 200
+    // eventsReplayLookback is how long before the last event a client saw
+    // posts are sent again, in case they committed after it.
+    eventsReplayLookback = time.Minute
+)
+
+// postEvents fans the new posts announced on postEventsChannel out to every
+// open event stream. Posts are announced through Postgres rather than in
+// process, so streams also see what an agg running elsewhere stores.
+type postEvents struct {
+    listener    *pq.Listener
+    mu          sync.Mutex
+    streams     map[chan int64]struct{}
+}
+
+type eventPost struct {
+    apiPost
+    FeedTitle   string  `json:"feed_title"`
+}
+
+func listenPostEvents(dbURL string) (*postEvents, error) {
+    listener := pq.NewListener(dbURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+        if err != nil {
+            slog.Warn("Post events connection failed", "error", err)
+        }
+    })
+    err := listener.Listen(postEventsChannel)
+    if err != nil {
+        listener.Close()
+        return nil, fmt.Errorf("Failed to listen for new posts: %w", err)
+    }
+
+    events := &postEvents{
+        listener: listener,
+        streams: make(map[chan int64]struct{}),
+    }
+    go events.run()
+    return events, nil
+}
+
+func (e *postEvents) run() {
+    for {
+        select {
+        case notification := <-e.listener.Notify:
+            // nil follows a reconnect; anything announced while the
+            // connection was down is lost.
+            if notification == nil {
+                slog.Info("Post events connection re-established")
+                continue
+            }
+            itemID, err := strconv.ParseInt(notification.Extra, 10, 64)
+            if err != nil {
+                slog.Warn("Ignoring malformed post event", "payload", notification.Extra)
+                continue
+            }
+            e.publish(itemID)
+        case <-time.After(90 * time.Second):
+            // The listener only notices a dead connection when it is used.
+            go e.listener.Ping()
+        }
+    }
+}
+
+func (e *postEvents) publish(itemID int64) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    for stream := range(e.streams) {
+        select {
+        case stream <- itemID:
+        default:
+            slog.Warn("Event stream is falling behind, dropping post", "item_id", itemID)
+        }
+    }
+}
+
+func (e *postEvents) subscribe() chan int64 {
+    stream := make(chan int64, eventsBuffer)
+    e.mu.Lock()
+    e.streams[stream] = struct{}{}
+    e.mu.Unlock()
+    eventStreams.Inc()
+    return stream
+}
+
+func (e *postEvents) unsubscribe(stream chan int64) {
+    e.mu.Lock()
+    delete(e.streams, stream)
+    e.mu.Unlock()
+    eventStreams.Dec()
+}
+
+// registerEventRoutes serves new posts as Server-Sent Events. Scripts use
+// an API key; browsers, whose EventSource cannot send one, use the read-only
+// feed token instead.
+func registerEventRoutes(mux *http.ServeMux, s *state, events *postEvents) {
+    mux.HandleFunc("GET /api/events", apiLoggedIn(s, events.serve))
+    mux.HandleFunc("GET /feeds/{token}/events", feedTokenUser(s, events.serve))
+}
+
+// serve streams each new post the user would see in their river as a "post"
+// event holding the post as JSON, until the client goes away. A client
+// reconnecting with Last-Event-ID first gets the posts stored since that
+// event.
+func (e *postEvents) serve(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    // Subscribe before replaying, so nothing stored in between is missed.
+    stream := e.subscribe()
+    defer e.unsubscribe(stream)
+
+    rc := http.NewResponseController(w)
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    // Keeps nginx from buffering the stream.
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprint(w, ": connected\n\n")
+
+    var replayed map[int64]bool
+    if lastID, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+        replayed, err = replayPostEvents(s, w, rc, r, user, lastID)
+        if err != nil {
+            slog.Error("Failed to replay event stream", "last_event_id", lastID, "error", err)
+            return
+        }
+    }
+    if err := rc.Flush(); err != nil {
+        slog.Error("Event stream cannot be flushed", "error", err)
+        return
+    }
+
+    keepAlive := time.NewTicker(eventsKeepAlive)
+    defer keepAlive.Stop()
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-keepAlive.C:
+            fmt.Fprint(w, ": ping\n\n")
+        case itemID := <-stream:
+            // Already sent while replaying.
+            if replayed[itemID] {
+                continue
+            }
+            rows, err := riverEventPosts(s, r, user, []int64{itemID})
+            if err != nil {
+                slog.Error("Failed to load post for event stream", "item_id", itemID, "error", err)
+                continue
+            }
+            // Not followed by this user, or hidden.
+            if len(rows) == 0 {
+                continue
+            }
+            writePostEvent(w, rows[0])
+        }
+        if err := rc.Flush(); err != nil {
+            return
+        }
+    }
+}
+
+// replayPostEvents sends the posts stored after lastID, oldest first, a page
+// at a time, and returns the item ids it went through.
+func replayPostEvents(s *state, w http.ResponseWriter, rc *http.ResponseController, r *http.Request, user database.User, lastID int64) (map[int64]bool, error) {
+    replayed := make(map[int64]bool)
+    params := database.GetReplayItemIDsParams{
+        UserID: user.ID,
+        LastItemID: lastID,
+        LookbackSeconds: int32(eventsReplayLookback.Seconds()),
+        MaxItems: eventsReplayPage,
+    }
+    for {
+        itemIDs, err := s.db.GetReplayItemIDs(r.Context(), params)
+        if err != nil {
+            return nil, err
+        }
+        if len(itemIDs) == 0 {
+            return replayed, nil
+        }
+
+        rows, err := riverEventPosts(s, r, user, itemIDs)
+        if err != nil {
+            return nil, err
+        }
+        slices.SortFunc(rows, func(a, b database.GetRiverPostsRow) int {
+            return cmp.Compare(a.ItemID, b.ItemID)
+        })
+        for _, row := range(rows) {
+            writePostEvent(w, row)
+        }
+        if err := rc.Flush(); err != nil {
+            return nil, err
+        }
+        for _, itemID := range(itemIDs) {
+            replayed[itemID] = true
+        }
+
+        if len(itemIDs) < eventsReplayPage {
+            return replayed, nil
+        }
+        params.Cursor = itemIDs[len(itemIDs) - 1]
+    }
+}
+
+// riverEventPosts loads the posts with itemIDs the user would see in their
+// river.
+func riverEventPosts(s *state, r *http.Request, user database.User, itemIDs []int64) ([]database.GetRiverPostsRow, error) {
+    rows, err := s.db.GetRiverPosts(r.Context(), database.GetRiverPostsParams{
+        UserID: user.ID,
+        ItemIds: itemIDs,
+        PageLimit: int32(len(itemIDs)),
+    })
+    if err != nil {
+        return nil, err
+    }
+    return dropHiddenRiver(s, user, rows)
+}
+
+func writePostEvent(w http.ResponseWriter, row database.GetRiverPostsRow) {
+    data, err := json.Marshal(eventPost{
+        apiPost: toAPIPost(database.Post{
+            ID: row.ID,
+            Title: row.Title,
+            Url: row.Url,
+            Description: row.Description,
+            PublishedAt: row.PublishedAt,
+            FeedID: row.FeedID,
+            Author: row.Author,
+            Categories: row.Categories,
+        }),
+        FeedTitle: row.FeedTitle,
+    })
+    if err != nil {
+        slog.Error("Failed to marshal post event", "error", err)
+        return
+    }
+    fmt.Fprintf(w, "id: %d\nevent: post\ndata: %s\n\n", row.ItemID, data)
+}
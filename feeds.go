@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+// canManageFeed allows changes to a feed only by whoever added it or an admin.
+func canManageFeed(user database.User, feed database.Feed) error {
+    if feed.UserID != user.ID && !isAdmin(user) {
+        return fmt.Errorf("Only the owner of %s or an admin with a password can change it", feed.Url)
+    }
+    return nil
+}
+
+// feedImpact describes what else goes away with a feed, since follows, posts
+// and fetch history all cascade from it.
+func feedImpact(s *state, feed database.Feed) (string, error) {
+    impact, err := s.db.GetFeedImpact(context.Background(), feed.ID)
+    if err != nil {
+        return "", fmt.Errorf("Failed to count feed dependents: %w", err)
+    }
+
+    followers, err := s.db.GetFeedFollowerNames(context.Background(), feed.ID)
+    if err != nil {
+        return "", fmt.Errorf("Failed to fetch feed followers: %w", err)
+    }
+
+    summary := fmt.Sprintf("Removing %s (%s) will also delete %d follows and %d posts, along with its fetch history.",
+        feed.Name, feed.Url, impact.Followers, impact.Posts)
+    if len(followers) > 0 {
+        summary += fmt.Sprintf("\nFollowers losing this feed: %s", strings.Join(followers, ", "))
+    }
+
+    return summary, nil
+}
+
+func handlerRemoveFeed(s *state, cmd command, user database.User) error {
+    flags, args, err := splitFlags(cmd.args, "yes", "dry-run")
+    if err != nil {
+        return err
+    }
+    if len(args) != 1 {
+        return errors.New("The rmfeed command expects ONE argument")
+    }
+
+    feed, err := s.db.GetFeedByURL(context.Background(), args[0])
+    if err != nil {
+        return fmt.Errorf("Failed to retrieve feed with provided URL: %w", err)
+    }
+
+    err = canManageFeed(user, feed)
+    if err != nil {
+        return err
+    }
+
+    summary, err := feedImpact(s, feed)
+    if err != nil {
+        return err
+    }
+
+    if flags["dry-run"] {
+        fmt.Println(summary)
+        return nil
+    }
+
+    if !flags["yes"] {
+        confirmed, err := confirmAction(summary, feed.Name)
+        if err != nil {
+            return err
+        }
+        if !confirmed {
+            return errors.New("Feed removal aborted")
+        }
+    }
+
+    err = s.db.DeleteFeed(context.Background(), feed.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to delete feed: %w", err)
+    }
+
+    fmt.Printf("Feed %s removed\n", feed.Name)
+    return nil
+}
+
+func handlerRenameFeed(s *state, cmd command, user database.User) error {
+    if len(cmd.args) != 2 {
+        return errors.New("The renamefeed command expects TWO arguments")
+    }
+
+    feed, err := s.db.GetFeedByURL(context.Background(), cmd.args[0])
+    if err != nil {
+        return fmt.Errorf("Failed to retrieve feed with provided URL: %w", err)
+    }
+
+    err = canManageFeed(user, feed)
+    if err != nil {
+        return err
+    }
+
+    name := cmd.args[1]
+    err = s.db.RenameFeed(context.Background(), database.RenameFeedParams{
+        ID: feed.ID,
+        Name: name,
+        UpdatedAt: time.Now(),
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to rename feed: %w", err)
+    }
+
+    fmt.Printf("Feed %s renamed to %s\n", feed.Name, name)
+    return nil
+}
+
+// handlerSetFeedURL corrects a feed's URL in place. Unlike a permanent
+// redirect the old URL is not kept as an alias, and the feed is queued for an
+// immediate fetch even if it had been marked gone.
+func handlerSetFeedURL(s *state, cmd command, user database.User) error {
+    if len(cmd.args) != 2 {
+        return errors.New("The setfeedurl command expects TWO arguments")
+    }
+
+    feed, err := s.db.GetFeedByURL(context.Background(), cmd.args[0])
+    if err != nil {
+        return fmt.Errorf("Failed to retrieve feed with provided URL: %w", err)
+    }
+
+    err = canManageFeed(user, feed)
+    if err != nil {
+        return err
+    }
+
+    url := cmd.args[1]
+    err = s.db.SetFeedURL(context.Background(), database.SetFeedURLParams{
+        ID: feed.ID,
+        Url: url,
+        UpdatedAt: time.Now(),
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to update feed URL: %w", err)
+    }
+
+    fmt.Printf("Feed %s now points to %s\n", feed.Name, url)
+    return nil
+}
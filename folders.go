@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+// getFollow looks up the current user's follow of the feed at url.
+func getFollow(s *state, user database.User, url string) (database.Feed, database.FeedFollow, error) {
+    feed, err := s.db.GetFeedByURL(context.Background(), url)
+    if err != nil {
+        return database.Feed{}, database.FeedFollow{}, fmt.Errorf("Failed to retrieve feed with provided URL: %w", err)
+    }
+
+    follow, err := s.db.GetFeedFollow(context.Background(), database.GetFeedFollowParams{
+        UserID: user.ID,
+        FeedID: feed.ID,
+    })
+    if errors.Is(err, sql.ErrNoRows) {
+        return database.Feed{}, database.FeedFollow{}, fmt.Errorf("You are not following %s", feed.Url)
+    } else if err != nil {
+        return database.Feed{}, database.FeedFollow{}, fmt.Errorf("Failed to fetch follow data: %w", err)
+    }
+
+    return feed, follow, nil
+}
+
+// handlerSetTitle gives a followed feed a personal title, or clears it when
+// no title is given.
+func handlerSetTitle(s *state, cmd command, user database.User) error {
+    if len(cmd.args) < 1 || len(cmd.args) > 2 {
+        return errors.New("The settitle command expects ONE or TWO arguments")
+    }
+
+    feed, follow, err := getFollow(s, user, cmd.args[0])
+    if err != nil {
+        return err
+    }
+
+    var title sql.NullString
+    if len(cmd.args) == 2 && cmd.args[1] != "" {
+        title = sql.NullString{String: cmd.args[1], Valid: true}
+    }
+
+    err = s.db.SetFeedFollowTitle(context.Background(), database.SetFeedFollowTitleParams{
+        ID: follow.ID,
+        Title: title,
+        UpdatedAt: time.Now(),
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to update title: %w", err)
+    }
+
+    if title.Valid {
+        fmt.Printf("%s will be shown as %s\n", feed.Name, title.String)
+    } else {
+        fmt.Printf("Personal title of %s cleared\n", feed.Name)
+    }
+    return nil
+}
+
+func handlerFolder(s *state, cmd command, user database.User) error {
+    if len(cmd.args) != 3 {
+        return errors.New("The folder command expects THREE arguments: add|rm <url> <folder>")
+    }
+
+    feed, follow, err := getFollow(s, user, cmd.args[1])
+    if err != nil {
+        return err
+    }
+    folder := cmd.args[2]
+
+    switch cmd.args[0] {
+    case "add":
+        err = s.db.AddFollowFolder(context.Background(), database.AddFollowFolderParams{
+            FeedFollowID: follow.ID,
+            Folder: folder,
+            CreatedAt: time.Now(),
+        })
+        if err != nil {
+            return fmt.Errorf("Failed to add feed to folder: %w", err)
+        }
+        fmt.Printf("%s added to %s\n", feed.Name, folder)
+    case "rm":
+        removed, err := s.db.RemoveFollowFolder(context.Background(), database.RemoveFollowFolderParams{
+            FeedFollowID: follow.ID,
+            Folder: folder,
+        })
+        if err != nil {
+            return fmt.Errorf("Failed to remove feed from folder: %w", err)
+        }
+        if removed == 0 {
+            return fmt.Errorf("%s is not in %s", feed.Name, folder)
+        }
+        fmt.Printf("%s removed from %s\n", feed.Name, folder)
+    default:
+        return fmt.Errorf("Unknown folder subcommand: %s", cmd.args[0])
+    }
+
+    return nil
+}
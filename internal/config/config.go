@@ -7,12 +7,53 @@ import (
 )
 
 type Config struct {
-    DBURL               string  `json:"db_url"`
-    CurrentUserName     string  `json:"current_user_name"`
+    DBURL               string          `json:"db_url"`
+    CurrentUserName     string          `json:"current_user_name"`
+    SessionToken        string          `json:"session_token,omitempty"`
+    Fetch               FetchConfig     `json:"fetch,omitzero"`
+    FetchLogDays        int             `json:"fetch_log_days,omitempty"`
+    SMTP                SMTPConfig      `json:"smtp,omitzero"`
+    DigestTemplates     string          `json:"digest_templates,omitempty"`
+    PublicURL           string          `json:"public_url,omitempty"`
+    SiteTemplates       string          `json:"site_templates,omitempty"`
+}
+
+type FetchConfig struct {
+    Timeout         string                      `json:"timeout,omitempty"`
+    UserAgent       string                      `json:"user_agent,omitempty"`
+    ContactURL      string                      `json:"contact_url,omitempty"`
+    Proxy           string                      `json:"proxy,omitempty"`
+    CABundle        string                      `json:"ca_bundle,omitempty"`
+    MaxRedirects    int                         `json:"max_redirects,omitempty"`
+    MaxBodyBytes    int64                       `json:"max_body_bytes,omitempty"`
+    Feeds           map[string]FeedFetchConfig  `json:"feeds,omitempty"`
+    HostInterval    string                      `json:"host_interval,omitempty"`
+    MaxPerHost      int                         `json:"max_per_host,omitempty"`
+    RespectRobots   bool                        `json:"respect_robots,omitempty"`
+}
+
+// FeedFetchConfig is keyed by feed URL in FetchConfig.Feeds.
+type FeedFetchConfig struct {
+    Headers     map[string]string   `json:"headers,omitempty"`
+    Username    string              `json:"username,omitempty"`
+    Password    string              `json:"password,omitempty"`
+}
+
+// SMTPConfig is the mail server used for email alerts.
+type SMTPConfig struct {
+    Host        string  `json:"host,omitempty"`
+    Port        int     `json:"port,omitempty"`
+    Username    string  `json:"username,omitempty"`
+    Password    string  `json:"password,omitempty"`
+    From        string  `json:"from,omitempty"`
 }
 
 const configFileName = ".gatorconfig.json"
 
+// DefaultFetchLogDays is how long fetch history is kept when the config
+// does not say otherwise.
+const DefaultFetchLogDays = 30
+
 func getConfigFilePath() (string, error) {
     homeDir, err := os.UserHomeDir()
     if err != nil {
@@ -65,10 +106,19 @@ func write(cfg Config) error {
         return fmt.Errorf("Error marshalling data into json: %w", err)
     }
 
-    return os.WriteFile(path, jsonData, 0644)
+    // The config holds the session token, so only its owner may read it.
+    // WriteFile keeps the mode of an existing file, hence the Chmod.
+    err = os.WriteFile(path, jsonData, 0600)
+    if err != nil {
+        return fmt.Errorf("Error writing config: %w", err)
+    }
+    return os.Chmod(path, 0600)
 }
 
-func (c *Config) SetUser(user string) error {
+// SetSession records the logged in user together with the session token
+// that proves it.
+func (c *Config) SetSession(user, token string) error {
     c.CurrentUserName = user
+    c.SessionToken = token
     return write(*c)
 }
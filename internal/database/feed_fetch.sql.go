@@ -14,14 +14,29 @@ import (
 )
 
 const getNextFeedToFetch = `-- name: GetNextFeedToFetch :one
-SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at
+SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at, dead_at
 FROM feeds
+WHERE dead_at IS NULL
+  AND NOT EXISTS (
+      SELECT 1 FROM websub_subscriptions ws
+      WHERE ws.feed_id = feeds.id
+        AND ws.state = 'active'
+        AND ws.lease_expires_at > $1
+        AND feeds.last_fetched_at > $2
+  )
 ORDER BY last_fetched_at NULLS FIRST, id
 LIMIT 1
 `
 
-func (q *Queries) GetNextFeedToFetch(ctx context.Context) (Feed, error) {
-	row := q.db.QueryRowContext(ctx, getNextFeedToFetch)
+type GetNextFeedToFetchParams struct {
+	LeaseExpiresAt sql.NullTime
+	LastFetchedAt  sql.NullTime
+}
+
+// GetNextFeedToFetch skips feeds a WebSub hub pushes to, unless they were
+// last fetched before $2 so they are still polled now and then.
+func (q *Queries) GetNextFeedToFetch(ctx context.Context, arg GetNextFeedToFetchParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getNextFeedToFetch, arg.LeaseExpiresAt, arg.LastFetchedAt)
 	var i Feed
 	err := row.Scan(
 		&i.ID,
@@ -31,15 +46,32 @@ func (q *Queries) GetNextFeedToFetch(ctx context.Context) (Feed, error) {
 		&i.Url,
 		&i.UserID,
 		&i.LastFetchedAt,
+		&i.DeadAt,
 	)
 	return i, err
 }
 
+const markFeedDead = `-- name: MarkFeedDead :exec
+UPDATE feeds
+SET dead_at = $2, updated_at = $2
+WHERE id = $1
+`
+
+type MarkFeedDeadParams struct {
+	ID     uuid.UUID
+	DeadAt sql.NullTime
+}
+
+func (q *Queries) MarkFeedDead(ctx context.Context, arg MarkFeedDeadParams) error {
+	_, err := q.db.ExecContext(ctx, markFeedDead, arg.ID, arg.DeadAt)
+	return err
+}
+
 const markFeedFetched = `-- name: MarkFeedFetched :exec
 UPDATE feeds
 SET last_fetched_at = $2, updated_at = $3
 WHERE id = $1
-RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at
+RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at, dead_at
 `
 
 type MarkFeedFetchedParams struct {
@@ -52,3 +84,63 @@ func (q *Queries) MarkFeedFetched(ctx context.Context, arg MarkFeedFetchedParams
 	_, err := q.db.ExecContext(ctx, markFeedFetched, arg.ID, arg.LastFetchedAt, arg.UpdatedAt)
 	return err
 }
+
+const mergeFeeds = `-- name: MergeFeeds :exec
+WITH follows AS (
+    UPDATE feed_follows
+    SET feed_id = $1, updated_at = $2
+    WHERE feed_id = $3
+      AND user_id NOT IN (SELECT user_id FROM feed_follows WHERE feed_id = $1)
+), hooks AS (
+    UPDATE webhook_feeds
+    SET feed_id = $1
+    WHERE feed_id = $3
+      AND webhook_id NOT IN (SELECT webhook_id FROM webhook_feeds WHERE feed_id = $1)
+), aliases AS (
+    UPDATE feed_aliases
+    SET feed_id = $1
+    WHERE feed_id = $3
+), alias AS (
+    INSERT INTO feed_aliases (url, created_at, feed_id)
+    SELECT url, $2, $1 FROM feeds WHERE id = $3
+    ON CONFLICT (url) DO NOTHING
+)
+DELETE FROM feeds
+WHERE id = $3
+`
+
+type MergeFeedsParams struct {
+	IntoID    uuid.UUID
+	UpdatedAt time.Time
+	FromID    uuid.UUID
+}
+
+// MergeFeeds folds a feed into the one whose URL it redirected to.
+// Followers and webhooks move across unless already there, the old URLs
+// become aliases and the merged feed is deleted along with its posts.
+func (q *Queries) MergeFeeds(ctx context.Context, arg MergeFeedsParams) error {
+	_, err := q.db.ExecContext(ctx, mergeFeeds, arg.IntoID, arg.UpdatedAt, arg.FromID)
+	return err
+}
+
+const moveFeedURL = `-- name: MoveFeedURL :exec
+WITH alias AS (
+    INSERT INTO feed_aliases (url, created_at, feed_id)
+    SELECT url, $3, id FROM feeds WHERE id = $1
+    ON CONFLICT (url) DO NOTHING
+)
+UPDATE feeds
+SET url = $2, updated_at = $3
+WHERE id = $1
+`
+
+type MoveFeedURLParams struct {
+	ID        uuid.UUID
+	Url       string
+	UpdatedAt time.Time
+}
+
+func (q *Queries) MoveFeedURL(ctx context.Context, arg MoveFeedURLParams) error {
+	_, err := q.db.ExecContext(ctx, moveFeedURL, arg.ID, arg.Url, arg.UpdatedAt)
+	return err
+}
@@ -7,11 +7,33 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const addFollowFolder = `-- name: AddFollowFolder :exec
+INSERT INTO follow_folders (feed_follow_id, folder, created_at)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT DO NOTHING
+`
+
+type AddFollowFolderParams struct {
+	FeedFollowID uuid.UUID
+	Folder       string
+	CreatedAt    time.Time
+}
+
+func (q *Queries) AddFollowFolder(ctx context.Context, arg AddFollowFolderParams) error {
+	_, err := q.db.ExecContext(ctx, addFollowFolder, arg.FeedFollowID, arg.Folder, arg.CreatedAt)
+	return err
+}
+
 const createFeedFollow = `-- name: CreateFeedFollow :one
 WITH inserted_feed_follow AS (
     INSERT INTO feed_follows (id, created_at, updated_at, user_id, feed_id)
@@ -22,7 +44,7 @@ WITH inserted_feed_follow AS (
         $4,
         $5
     )
-    RETURNING id, created_at, updated_at, user_id, feed_id
+    RETURNING id, created_at, updated_at, user_id, feed_id, title
 )
 SELECT
     inserted_feed_follow.id,
@@ -76,7 +98,7 @@ func (q *Queries) CreateFeedFollow(ctx context.Context, arg CreateFeedFollowPara
 	return i, err
 }
 
-const deleteFeedFollow = `-- name: DeleteFeedFollow :exec
+const deleteFeedFollow = `-- name: DeleteFeedFollow :execrows
 DELETE FROM feed_follows
 WHERE user_id = $1 AND feed_id = $2
 `
@@ -86,9 +108,36 @@ type DeleteFeedFollowParams struct {
 	FeedID uuid.UUID
 }
 
-func (q *Queries) DeleteFeedFollow(ctx context.Context, arg DeleteFeedFollowParams) error {
-	_, err := q.db.ExecContext(ctx, deleteFeedFollow, arg.UserID, arg.FeedID)
-	return err
+func (q *Queries) DeleteFeedFollow(ctx context.Context, arg DeleteFeedFollowParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteFeedFollow, arg.UserID, arg.FeedID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getFeedFollow = `-- name: GetFeedFollow :one
+SELECT id, created_at, updated_at, user_id, feed_id, title FROM feed_follows
+WHERE user_id = $1 AND feed_id = $2
+`
+
+type GetFeedFollowParams struct {
+	UserID uuid.UUID
+	FeedID uuid.UUID
+}
+
+func (q *Queries) GetFeedFollow(ctx context.Context, arg GetFeedFollowParams) (FeedFollow, error) {
+	row := q.db.QueryRowContext(ctx, getFeedFollow, arg.UserID, arg.FeedID)
+	var i FeedFollow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.FeedID,
+		&i.Title,
+	)
+	return i, err
 }
 
 const getFeedFollowsForUser = `-- name: GetFeedFollowsForUser :many
@@ -146,3 +195,89 @@ func (q *Queries) GetFeedFollowsForUser(ctx context.Context, userID uuid.UUID) (
 	}
 	return items, nil
 }
+
+const getFeedFollowsWithFolders = `-- name: GetFeedFollowsWithFolders :many
+SELECT
+    feed_follows.feed_id,
+    feeds.name AS feed_name,
+    feeds.url AS feed_url,
+    feed_follows.title,
+    follow_folders.folder
+FROM feed_follows
+JOIN feeds ON feed_follows.feed_id = feeds.id
+LEFT JOIN follow_folders ON follow_folders.feed_follow_id = feed_follows.id
+WHERE feed_follows.user_id = $1
+ORDER BY follow_folders.folder NULLS LAST, COALESCE(feed_follows.title, feeds.name)
+`
+
+type GetFeedFollowsWithFoldersRow struct {
+	FeedID   uuid.UUID
+	FeedName string
+	FeedUrl  string
+	Title    sql.NullString
+	Folder   sql.NullString
+}
+
+func (q *Queries) GetFeedFollowsWithFolders(ctx context.Context, userID uuid.UUID) ([]GetFeedFollowsWithFoldersRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFeedFollowsWithFolders, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFeedFollowsWithFoldersRow
+	for rows.Next() {
+		var i GetFeedFollowsWithFoldersRow
+		if err := rows.Scan(
+			&i.FeedID,
+			&i.FeedName,
+			&i.FeedUrl,
+			&i.Title,
+			&i.Folder,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const removeFollowFolder = `-- name: RemoveFollowFolder :execrows
+DELETE FROM follow_folders
+WHERE feed_follow_id = $1 AND folder = $2
+`
+
+type RemoveFollowFolderParams struct {
+	FeedFollowID uuid.UUID
+	Folder       string
+}
+
+func (q *Queries) RemoveFollowFolder(ctx context.Context, arg RemoveFollowFolderParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removeFollowFolder, arg.FeedFollowID, arg.Folder)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setFeedFollowTitle = `-- name: SetFeedFollowTitle :exec
+UPDATE feed_follows
+SET title = $2, updated_at = $3
+WHERE id = $1
+`
+
+type SetFeedFollowTitleParams struct {
+	ID        uuid.UUID
+	Title     sql.NullString
+	UpdatedAt time.Time
+}
+
+func (q *Queries) SetFeedFollowTitle(ctx context.Context, arg SetFeedFollowTitleParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedFollowTitle, arg.ID, arg.Title, arg.UpdatedAt)
+	return err
+}
@@ -11,6 +11,26 @@ import (
 	"github.com/google/uuid"
 )
 
+type ApiKey struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	Prefix     string
+	KeyHash    string
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type Digest struct {
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Email        string
+	Frequency    string
+	LastDigestAt sql.NullTime
+}
+
 type Feed struct {
 	ID            uuid.UUID
 	CreatedAt     time.Time
@@ -19,6 +39,32 @@ type Feed struct {
 	Url           string
 	UserID        uuid.UUID
 	LastFetchedAt sql.NullTime
+	DeadAt        sql.NullTime
+}
+
+type FeedAlias struct {
+	Url       string
+	CreatedAt time.Time
+	FeedID    uuid.UUID
+}
+
+type FeedToken struct {
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	TokenHash string
+}
+
+type FeedFetch struct {
+	ID           uuid.UUID
+	FeedID       uuid.UUID
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	StatusCode   sql.NullInt32
+	Bytes        int64
+	ItemCount    int32
+	NewPosts     int32
+	UpdatedPosts int32
+	Error        sql.NullString
 }
 
 type FeedFollow struct {
@@ -27,6 +73,13 @@ type FeedFollow struct {
 	UpdatedAt time.Time
 	UserID    uuid.UUID
 	FeedID    uuid.UUID
+	Title     sql.NullString
+}
+
+type FollowFolder struct {
+	FeedFollowID uuid.UUID
+	Folder       string
+	CreatedAt    time.Time
 }
 
 type Post struct {
@@ -38,11 +91,127 @@ type Post struct {
 	Description sql.NullString
 	PublishedAt time.Time
 	FeedID      uuid.UUID
+	Author      sql.NullString
+	Categories  []string
+	ItemID      int64
+}
+
+type PostFingerprint struct {
+	PostID      uuid.UUID
+	PublishedAt time.Time
+	Fingerprint int64
+	ClusterID   uuid.UUID
+	FeedID      uuid.UUID
+	Bands       []int32
+}
+
+type PostState struct {
+	UserID    uuid.UUID
+	PostID    uuid.UUID
+	ReadAt    sql.NullTime
+	StarredAt sql.NullTime
+	HiddenAt  sql.NullTime
+}
+
+type PostTag struct {
+	UserID    uuid.UUID
+	PostID    uuid.UUID
+	Tag       string
+	CreatedAt time.Time
+}
+
+type RiverChange struct {
+	UserID    uuid.UUID
+	ChangedAt time.Time
+}
+
+type Rule struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Field     string
+	MatchType string
+	Pattern   string
+	Action    string
+	Tag       sql.NullString
+}
+
+type Session struct {
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UserID    uuid.UUID
 }
 
 type User struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Name         string
+	PasswordHash sql.NullString
+	Role         string
+}
+
+type Watch struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Name      string
+	UserID    uuid.UUID
+	Query     string
+	Notifier  string
+	Target    string
+}
+
+type WatchMatch struct {
+	WatchID    uuid.UUID
+	PostID     uuid.UUID
+	MatchedAt  time.Time
+	NotifiedAt sql.NullTime
+	Error      sql.NullString
+}
+
+type Webhook struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Url       string
+	Secret    string
+	AllFeeds  bool
+}
+
+type WebhookAttempt struct {
+	ID          uuid.UUID
+	DeliveryID  uuid.UUID
+	AttemptedAt time.Time
+	DurationMs  int32
+	StatusCode  sql.NullInt32
+	Error       sql.NullString
+}
+
+type WebhookDelivery struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	WebhookID     uuid.UUID
+	PostID        uuid.UUID
+	Attempts      int32
+	NextAttemptAt time.Time
+	DeliveredAt   sql.NullTime
+	FailedAt      sql.NullTime
+}
+
+type WebhookFeed struct {
+	WebhookID uuid.UUID
+	FeedID    uuid.UUID
+}
+
+type WebsubSubscription struct {
+	FeedID         uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Hub            string
+	Topic          string
+	Secret         string
+	State          string
+	RequestedAt    sql.NullTime
+	LeaseExpiresAt sql.NullTime
+	Error          sql.NullString
 }
@@ -11,10 +11,11 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createPost = `-- name: CreatePost :one
-INSERT INTO posts (id, created_at, updated_at, title, url, description, published_at, feed_id)
+INSERT INTO posts (id, created_at, updated_at, title, url, description, published_at, feed_id, author, categories)
 VALUES (
     $1,
     $2,
@@ -23,9 +24,16 @@ VALUES (
     $5,
     $6,
     $7,
-    $8
+    $8,
+    $9,
+    $10
 )
-RETURNING id, created_at, updated_at, title, url, description, published_at, feed_id
+ON CONFLICT (url) DO UPDATE
+SET title = EXCLUDED.title, description = EXCLUDED.description, updated_at = EXCLUDED.updated_at,
+    author = EXCLUDED.author, categories = EXCLUDED.categories
+WHERE posts.feed_id = EXCLUDED.feed_id
+  AND (posts.title IS DISTINCT FROM EXCLUDED.title OR posts.description IS DISTINCT FROM EXCLUDED.description)
+RETURNING id, created_at, updated_at, title, url, description, published_at, feed_id, author, categories, item_id, (xmax = 0) AS inserted
 `
 
 type CreatePostParams struct {
@@ -37,9 +45,26 @@ type CreatePostParams struct {
 	Description sql.NullString
 	PublishedAt time.Time
 	FeedID      uuid.UUID
+	Author      sql.NullString
+	Categories  []string
 }
 
-func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, error) {
+type CreatePostRow struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Title       string
+	Url         string
+	Description sql.NullString
+	PublishedAt time.Time
+	FeedID      uuid.UUID
+	Author      sql.NullString
+	Categories  []string
+	ItemID      int64
+	Inserted    bool
+}
+
+func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (CreatePostRow, error) {
 	row := q.db.QueryRowContext(ctx, createPost,
 		arg.ID,
 		arg.CreatedAt,
@@ -49,8 +74,10 @@ func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, e
 		arg.Description,
 		arg.PublishedAt,
 		arg.FeedID,
+		arg.Author,
+		pq.Array(arg.Categories),
 	)
-	var i Post
+	var i CreatePostRow
 	err := row.Scan(
 		&i.ID,
 		&i.CreatedAt,
@@ -60,12 +87,16 @@ func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, e
 		&i.Description,
 		&i.PublishedAt,
 		&i.FeedID,
+		&i.Author,
+		pq.Array(&i.Categories),
+		&i.ItemID,
+		&i.Inserted,
 	)
 	return i, err
 }
 
 const getPostsForUser = `-- name: GetPostsForUser :many
-SELECT p.id, p.created_at, p.updated_at, title, url, description, published_at, p.feed_id, ff.id, ff.created_at, ff.updated_at, user_id, ff.feed_id FROM posts p
+SELECT p.id, p.created_at, p.updated_at, p.title, p.url, p.description, p.published_at, p.feed_id, p.author, p.categories, p.item_id FROM posts p
 JOIN feed_follows ff ON p.feed_id = ff.feed_id
 WHERE ff.user_id = $1
 ORDER BY published_at DESC
@@ -77,7 +108,200 @@ type GetPostsForUserParams struct {
 	Limit  int32
 }
 
-type GetPostsForUserRow struct {
+func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams) ([]Post, error) {
+	rows, err := q.db.QueryContext(ctx, getPostsForUser, arg.UserID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Title,
+			&i.Url,
+			&i.Description,
+			&i.PublishedAt,
+			&i.FeedID,
+			&i.Author,
+			pq.Array(&i.Categories),
+			&i.ItemID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPostsForUserPage = `-- name: GetPostsForUserPage :many
+SELECT p.id, p.created_at, p.updated_at, p.title, p.url, p.description, p.published_at, p.feed_id, p.author, p.categories, p.item_id FROM posts p
+JOIN feed_follows ff ON p.feed_id = ff.feed_id
+LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = ff.user_id
+WHERE ff.user_id = $1
+  AND ps.hidden_at IS NULL
+  AND ($2::uuid IS NULL OR p.feed_id = $2::uuid)
+  AND ($3::text IS NULL OR EXISTS (
+      SELECT 1 FROM follow_folders fo
+      WHERE fo.feed_follow_id = ff.id AND fo.folder = $3::text
+  ))
+  AND ($4::timestamp IS NULL OR p.published_at >= $4::timestamp)
+  AND ($5::text IS NULL OR strpos(lower(p.title), lower($5::text)) > 0)
+ORDER BY p.published_at DESC, p.id
+LIMIT $6 OFFSET $7
+`
+
+type GetPostsForUserPageParams struct {
+	UserID     uuid.UUID
+	FeedID     uuid.NullUUID
+	Folder     sql.NullString
+	Since      sql.NullTime
+	Query      sql.NullString
+	PageLimit  int32
+	PageOffset int32
+}
+
+// GetPostsForUserPage leaves out the posts the user hid, so a page is only
+// short once it runs out of posts.
+func (q *Queries) GetPostsForUserPage(ctx context.Context, arg GetPostsForUserPageParams) ([]Post, error) {
+	rows, err := q.db.QueryContext(ctx, getPostsForUserPage,
+		arg.UserID,
+		arg.FeedID,
+		arg.Folder,
+		arg.Since,
+		arg.Query,
+		arg.PageLimit,
+		arg.PageOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Title,
+			&i.Url,
+			&i.Description,
+			&i.PublishedAt,
+			&i.FeedID,
+			&i.Author,
+			pq.Array(&i.Categories),
+			&i.ItemID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestPosts = `-- name: GetLatestPosts :many
+SELECT id, created_at, updated_at, title, url, description, published_at, feed_id, author, categories, item_id FROM posts
+WHERE $1::uuid IS NULL OR feed_id = $1::uuid
+ORDER BY published_at DESC, id
+LIMIT $2
+`
+
+type GetLatestPostsParams struct {
+	FeedID   uuid.NullUUID
+	MaxPosts int32
+}
+
+func (q *Queries) GetLatestPosts(ctx context.Context, arg GetLatestPostsParams) ([]Post, error) {
+	rows, err := q.db.QueryContext(ctx, getLatestPosts, arg.FeedID, arg.MaxPosts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Title,
+			&i.Url,
+			&i.Description,
+			&i.PublishedAt,
+			&i.FeedID,
+			&i.Author,
+			pq.Array(&i.Categories),
+			&i.ItemID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRiverPosts = `-- name: GetRiverPosts :many
+SELECT p.id, p.created_at, p.updated_at, p.title, p.url, p.description, p.published_at, p.feed_id, p.author, p.categories, p.item_id, COALESCE(ff.title, f.name)::text AS feed_title, ps.read_at, ps.starred_at
+FROM posts p
+JOIN feed_follows ff ON ff.feed_id = p.feed_id AND ff.user_id = $1
+JOIN feeds f ON f.id = p.feed_id
+LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = $1
+WHERE ps.hidden_at IS NULL
+  AND ($2::uuid IS NULL OR p.feed_id = $2::uuid)
+  AND ($3::text IS NULL OR EXISTS (
+      SELECT 1 FROM follow_folders fo
+      WHERE fo.feed_follow_id = ff.id AND fo.folder = $3::text
+  ))
+  AND (NOT $4::boolean OR ps.read_at IS NULL)
+  AND (NOT $5::boolean OR ps.starred_at IS NOT NULL)
+  AND (NOT $6::boolean OR ps.read_at IS NOT NULL)
+  AND ($7::timestamp IS NULL OR p.published_at >= $7::timestamp)
+  AND ($8::timestamp IS NULL OR p.published_at < $8::timestamp)
+  AND ($9::bigint[] IS NULL OR p.item_id = ANY($9::bigint[]))
+ORDER BY
+    CASE WHEN $10::boolean THEN p.published_at END ASC,
+    p.published_at DESC, p.id
+LIMIT $11 OFFSET $12
+`
+
+type GetRiverPostsParams struct {
+	UserID      uuid.UUID
+	FeedID      uuid.NullUUID
+	Folder      sql.NullString
+	UnreadOnly  bool
+	StarredOnly bool
+	ReadOnly    bool
+	Since       sql.NullTime
+	Until       sql.NullTime
+	ItemIds     []int64
+	OldestFirst bool
+	PageLimit   int32
+	PageOffset  int32
+}
+
+type GetRiverPostsRow struct {
 	ID          uuid.UUID
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
@@ -86,22 +310,39 @@ type GetPostsForUserRow struct {
 	Description sql.NullString
 	PublishedAt time.Time
 	FeedID      uuid.UUID
-	ID_2        uuid.UUID
-	CreatedAt_2 time.Time
-	UpdatedAt_2 time.Time
-	UserID      uuid.UUID
-	FeedID_2    uuid.UUID
+	Author      sql.NullString
+	Categories  []string
+	ItemID      int64
+	FeedTitle   string
+	ReadAt      sql.NullTime
+	StarredAt   sql.NullTime
 }
 
-func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams) ([]GetPostsForUserRow, error) {
-	rows, err := q.db.QueryContext(ctx, getPostsForUser, arg.UserID, arg.Limit)
+// GetRiverPosts pages through the posts a user follows with their read and
+// star state, leaving out hidden ones. The other arguments narrow it down to
+// one feed or folder, a state, a time range or a set of item ids.
+func (q *Queries) GetRiverPosts(ctx context.Context, arg GetRiverPostsParams) ([]GetRiverPostsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRiverPosts,
+		arg.UserID,
+		arg.FeedID,
+		arg.Folder,
+		arg.UnreadOnly,
+		arg.StarredOnly,
+		arg.ReadOnly,
+		arg.Since,
+		arg.Until,
+		pq.Array(arg.ItemIds),
+		arg.OldestFirst,
+		arg.PageLimit,
+		arg.PageOffset,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetPostsForUserRow
+	var items []GetRiverPostsRow
 	for rows.Next() {
-		var i GetPostsForUserRow
+		var i GetRiverPostsRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
@@ -111,11 +352,12 @@ func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams
 			&i.Description,
 			&i.PublishedAt,
 			&i.FeedID,
-			&i.ID_2,
-			&i.CreatedAt_2,
-			&i.UpdatedAt_2,
-			&i.UserID,
-			&i.FeedID_2,
+			&i.Author,
+			pq.Array(&i.Categories),
+			&i.ItemID,
+			&i.FeedTitle,
+			&i.ReadAt,
+			&i.StarredAt,
 		); err != nil {
 			return nil, err
 		}
@@ -129,3 +371,137 @@ func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams
 	}
 	return items, nil
 }
+
+const getUnreadCounts = `-- name: GetUnreadCounts :many
+SELECT p.feed_id, COUNT(*) AS unread
+FROM posts p
+JOIN feed_follows ff ON ff.feed_id = p.feed_id AND ff.user_id = $1
+LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = $1
+WHERE ps.read_at IS NULL AND ps.hidden_at IS NULL
+GROUP BY p.feed_id
+`
+
+type GetUnreadCountsRow struct {
+	FeedID uuid.UUID
+	Unread int64
+}
+
+func (q *Queries) GetUnreadCounts(ctx context.Context, userID uuid.UUID) ([]GetUnreadCountsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUnreadCounts, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUnreadCountsRow
+	for rows.Next() {
+		var i GetUnreadCountsRow
+		if err := rows.Scan(&i.FeedID, &i.Unread); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPostIDsByItemID = `-- name: GetPostIDsByItemID :many
+SELECT id, item_id FROM posts
+WHERE item_id = ANY($1::bigint[])
+`
+
+type GetPostIDsByItemIDRow struct {
+	ID     uuid.UUID
+	ItemID int64
+}
+
+func (q *Queries) GetPostIDsByItemID(ctx context.Context, itemIds []int64) ([]GetPostIDsByItemIDRow, error) {
+	rows, err := q.db.QueryContext(ctx, getPostIDsByItemID, pq.Array(itemIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetPostIDsByItemIDRow
+	for rows.Next() {
+		var i GetPostIDsByItemIDRow
+		if err := rows.Scan(&i.ID, &i.ItemID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReplayItemIDs = `-- name: GetReplayItemIDs :many
+SELECT p.item_id FROM posts p
+JOIN feed_follows ff ON ff.feed_id = p.feed_id AND ff.user_id = $1
+WHERE p.item_id > $2
+  AND (p.item_id > $3
+       OR p.created_at >= (SELECT created_at FROM posts WHERE item_id = $3)
+                          - make_interval(secs => $4::int))
+ORDER BY p.item_id
+LIMIT $5
+`
+
+type GetReplayItemIDsParams struct {
+	UserID          uuid.UUID
+	Cursor          int64
+	LastItemID      int64
+	LookbackSeconds int32
+	MaxItems        int32
+}
+
+// GetReplayItemIDs pages through the item ids of posts a user follows that
+// came after last_item_id, in id order from cursor. Ids are taken when a post
+// is inserted rather than committed, so a lower id can show up after a
+// higher one; posts stored up to lookback_seconds before last_item_id's are
+// included again for that.
+func (q *Queries) GetReplayItemIDs(ctx context.Context, arg GetReplayItemIDsParams) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, getReplayItemIDs,
+		arg.UserID,
+		arg.Cursor,
+		arg.LastItemID,
+		arg.LookbackSeconds,
+		arg.MaxItems,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var item_id int64
+		if err := rows.Scan(&item_id); err != nil {
+			return nil, err
+		}
+		items = append(items, item_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const notifyNewPost = `-- name: NotifyNewPost :exec
+SELECT pg_notify('gator_new_post', $1::bigint::text)
+`
+
+// NotifyNewPost tells every serve process listening on gator_new_post about
+// a stored post, by its item id.
+func (q *Queries) NotifyNewPost(ctx context.Context, itemID int64) error {
+	_, err := q.db.ExecContext(ctx, notifyNewPost, itemID)
+	return err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type logOptions struct {
+    level   string
+    format  string
+    file    string
+}
+
+// newLogger builds the process-wide slog.Logger. Logs go to stderr unless a
+// file is given, so they never mix with command output on stdout.
+func newLogger(opts logOptions) (*slog.Logger, func(), error) {
+    var level slog.Level
+    err := level.UnmarshalText([]byte(opts.level))
+    if err != nil {
+        return nil, nil, fmt.Errorf("Invalid log level %q: %w", opts.level, err)
+    }
+
+    var out io.Writer = os.Stderr
+    cleanup := func() {}
+    if opts.file != "" {
+        file, err := os.OpenFile(opts.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+        if err != nil {
+            return nil, nil, fmt.Errorf("Failed to open log file: %w", err)
+        }
+        out = file
+        cleanup = func() { file.Close() }
+    }
+
+    handlerOpts := &slog.HandlerOptions{Level: level}
+
+    var handler slog.Handler
+    switch strings.ToLower(opts.format) {
+    case "text":
+        handler = slog.NewTextHandler(out, handlerOpts)
+    case "json":
+        handler = slog.NewJSONHandler(out, handlerOpts)
+    default:
+        cleanup()
+        return nil, nil, fmt.Errorf("Invalid log format %q, expected text or json", opts.format)
+    }
+
+    return slog.New(handler), cleanup, nil
+}
@@ -1,21 +1,98 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/zulkou/blog-aggregator/internal/config"
 	"github.com/zulkou/blog-aggregator/internal/database"
+	"github.com/zulkou/blog-aggregator/rss"
 )
 
 type state struct {
-    cfg     *config.Config
-    db      *database.Queries
+    cfg         *config.Config
+    db          *database.Queries
+    // conn is the pool behind db, for the few queries that need a
+    // transaction.
+    conn        *sql.DB
+    fetcher     *rss.Fetcher
+}
+
+func newFetcher(cfg config.FetchConfig) (*rss.Fetcher, error) {
+    opts := rss.Options{
+        UserAgent: cfg.UserAgent,
+        ContactURL: cfg.ContactURL,
+        Proxy: cfg.Proxy,
+        CABundle: cfg.CABundle,
+        MaxRedirects: cfg.MaxRedirects,
+        MaxBodySize: cfg.MaxBodyBytes,
+        Feeds: make(map[string]rss.FeedOptions, len(cfg.Feeds)),
+        MaxPerHost: cfg.MaxPerHost,
+        RespectRobots: cfg.RespectRobots,
+    }
+
+    if cfg.Timeout != "" {
+        timeout, err := time.ParseDuration(cfg.Timeout)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse fetch timeout: %w", err)
+        }
+        opts.Timeout = timeout
+    }
+
+    if cfg.HostInterval != "" {
+        interval, err := time.ParseDuration(cfg.HostInterval)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse host interval: %w", err)
+        }
+        opts.HostInterval = interval
+    }
+
+    for feedURL, feedCfg := range cfg.Feeds {
+        opts.Feeds[feedURL] = rss.FeedOptions{
+            Headers: feedCfg.Headers,
+            Username: feedCfg.Username,
+            Password: feedCfg.Password,
+        }
+    }
+
+    return rss.NewFetcher(opts)
+}
+
+// followFeedMoves carries fetch options configured under a feed's old URL
+// over to the URL it has since permanently moved to.
+func followFeedMoves(s *state) {
+    for feedURL := range(s.cfg.Fetch.Feeds) {
+        feed, err := s.db.GetFeedByURL(context.Background(), feedURL)
+        if err == nil && feed.Url != feedURL {
+            s.fetcher.MoveFeed(feedURL, feed.Url)
+        }
+    }
 }
 
 func run() int {
+    flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+    var logOpts logOptions
+    flags.StringVar(&logOpts.level, "log-level", "info", "minimum log level: debug, info, warn or error")
+    flags.StringVar(&logOpts.format, "log-format", "text", "log format: text or json")
+    flags.StringVar(&logOpts.file, "log-file", "", "append logs to this file instead of stderr")
+    if err := flags.Parse(os.Args[1:]); err != nil {
+        return 1
+    }
+
+    logger, closeLog, err := newLogger(logOpts)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error configuring logging: %v\n", err)
+        return 1
+    }
+    defer closeLog()
+    slog.SetDefault(logger)
+
     cfg, err := config.Read()
     if err != nil {
         fmt.Fprintf(os.Stderr, "Error in reading config file: %v\n", err)
@@ -29,11 +106,20 @@ func run() int {
     }
     defer db.Close()
     dbQueries := database.New(db)
+
+    fetcher, err := newFetcher(cfg.Fetch)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error configuring feed fetcher: %v\n", err)
+        return 1
+    }
     
     s := state {
         cfg: &cfg,
         db: dbQueries,
+        conn: db,
+        fetcher: fetcher,
     }
+    followFeedMoves(&s)
  
     cmds := commands{
         handlers: make(map[string]func(*state, command) error),
@@ -41,26 +127,45 @@ func run() int {
 
     cmds.register("login", handlerLogin)
     cmds.register("register", handlerRegister)
-    cmds.register("reset", handlerReset)
+    cmds.register("reset", middlewareAdmin(handlerReset))
+    cmds.register("role", middlewareAdmin(handlerRole))
     cmds.register("users", handlerUsers)
     cmds.register("agg", handlerAgg)
     cmds.register("addfeed", middlewareLoggedIn(handlerAddFeed))
     cmds.register("feeds", handlerFeeds)
+    cmds.register("rmfeed", middlewareLoggedIn(handlerRemoveFeed))
+    cmds.register("renamefeed", middlewareLoggedIn(handlerRenameFeed))
+    cmds.register("setfeedurl", middlewareLoggedIn(handlerSetFeedURL))
     cmds.register("follow", middlewareLoggedIn(handlerFollow))
     cmds.register("following", middlewareLoggedIn(handlerFollowing))
     cmds.register("unfollow", middlewareLoggedIn(handlerUnfollow))
     cmds.register("browse", middlewareLoggedIn(handlerBrowse))
+    cmds.register("fingerprint", middlewareAdmin(handlerFingerprint))
+    cmds.register("tui", middlewareLoggedIn(handlerTUI))
+    cmds.register("settitle", middlewareLoggedIn(handlerSetTitle))
+    cmds.register("folder", middlewareLoggedIn(handlerFolder))
+    cmds.register("rule", middlewareLoggedIn(handlerRule))
+    cmds.register("watch", middlewareLoggedIn(handlerWatch))
+    cmds.register("digest", middlewareLoggedIn(handlerDigest))
+    cmds.register("webhook", middlewareLoggedIn(handlerWebhook))
+    cmds.register("feedtoken", middlewareLoggedIn(handlerFeedToken))
+    cmds.register("render", handlerRender)
+    cmds.register("fetchlog", handlerFetchLog)
+    cmds.register("websub", handlerWebSub)
+    cmds.register("serve", handlerServe)
+    cmds.register("apikey", middlewareLoggedIn(handlerAPIKey))
+    cmds.register("passwd", middlewareLoggedIn(handlerPasswd))
 
-    args := os.Args
+    args := flags.Args()
 
-    if len(args) < 2 {
+    if len(args) < 1 {
         fmt.Fprintf(os.Stderr, "Commands not specified\n")
         return 1
     }
     
     cmd := command{
-        name: args[1],
-        args: args[2:],
+        name: args[0],
+        args: args[1:],
     }
 
     err = cmds.run(&s, cmd)
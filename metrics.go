@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+    fetchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "gator_fetches_total",
+        Help: "Feed fetch attempts by HTTP status, or \"error\" when no response was received.",
+    }, []string{"status"})
+
+    fetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+        Name: "gator_fetch_duration_seconds",
+        Help: "Time spent fetching and storing a single feed.",
+        Buckets: prometheus.DefBuckets,
+    })
+
+    fetchBytes = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "gator_fetch_bytes_total",
+        Help: "Decoded feed bytes downloaded.",
+    })
+
+    postsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "gator_posts_total",
+        Help: "Feed items processed, by whether they were inserted, updated or already stored.",
+    }, []string{"result"})
+
+    feedsDue = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "gator_feeds_due",
+        Help: "Active feeds not fetched within one full round of the scraper.",
+    })
+
+    feedsOverdue = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "gator_feeds_overdue",
+        Help: "Active feeds not fetched within two full rounds of the scraper.",
+    })
+
+    queueLag = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "gator_queue_lag_seconds",
+        Help: "Time since the least recently fetched active feed was fetched.",
+    })
+
+    dbErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "gator_db_errors_total",
+        Help: "Failed database queries made by the scraper, by query name.",
+    }, []string{"query"})
+
+    webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "gator_webhook_deliveries_total",
+        Help: "Webhook delivery attempts, by whether they were delivered, will be retried or were given up on.",
+    }, []string{"result"})
+
+    eventStreams = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "gator_event_streams",
+        Help: "Clients connected to a live stream of new posts.",
+    })
+)
+
+// metricsHandler serves the metrics above, both on agg's metrics listener
+// and to admins on serve.
+var metricsHandler = promhttp.Handler()
+
+// serveMetrics starts the /metrics endpoint on addr. The listener is opened
+// synchronously so a bad address fails the command straight away.
+func serveMetrics(addr string) error {
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        return fmt.Errorf("Failed to listen for metrics: %w", err)
+    }
+
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", metricsHandler)
+
+    go func() {
+        err := http.Serve(ln, mux)
+        if err != nil {
+            slog.Error("Metrics server stopped", "error", err)
+        }
+    }()
+
+    slog.Info("Serving metrics", "addr", ln.Addr().String())
+    return nil
+}
+
+// updateQueueMetrics refreshes the scheduling gauges. The scraper fetches one
+// feed per interval, so a full round over n active feeds takes n intervals.
+func updateQueueMetrics(s *state, interval time.Duration) error {
+    feeds, err := s.db.GetFeeds(context.Background())
+    if err != nil {
+        dbErrors.WithLabelValues("GetFeeds").Inc()
+        return fmt.Errorf("Failed to fetch feeds: %w", err)
+    }
+
+    now := time.Now()
+    active := 0
+    for _, feed := range(feeds) {
+        if !feed.DeadAt.Valid {
+            active++
+        }
+    }
+    round := interval * time.Duration(active)
+
+    due, overdue := 0, 0
+    var lag time.Duration
+    for _, feed := range(feeds) {
+        if feed.DeadAt.Valid {
+            continue
+        }
+
+        since := now.Sub(feed.CreatedAt)
+        if feed.LastFetchedAt.Valid {
+            since = now.Sub(feed.LastFetchedAt.Time)
+        }
+
+        if since > round {
+            due++
+        }
+        if since > 2 * round {
+            overdue++
+        }
+        if since > lag {
+            lag = since
+        }
+    }
+
+    feedsDue.Set(float64(due))
+    feedsOverdue.Set(float64(overdue))
+    queueLag.Set(lag.Seconds())
+
+    return nil
+}
@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DefaultDesktopCommand is the freedesktop.org notification client.
+const DefaultDesktopCommand = "notify-send"
+
+// Desktop shows a notification on the machine running the process.
+type Desktop struct {
+    // Command replaces notify-send when set.
+    Command string
+}
+
+func (d Desktop) Notify(ctx context.Context, msg Message) error {
+    command := d.Command
+    if command == "" {
+        command = DefaultDesktopCommand
+    }
+
+    body := msg.Body
+    if msg.URL != "" {
+        body = strings.TrimSpace(body + "\n" + msg.URL)
+    }
+
+    out, err := exec.CommandContext(ctx, command, "--app-name=gator", msg.Subject, body).CombinedOutput()
+    if err != nil {
+        return fmt.Errorf("%s failed: %w: %s", command, err, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
@@ -0,0 +1,162 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+    // DefaultSMTPPort is the mail submission port.
+    DefaultSMTPPort = 587
+    // DefaultSMTPTimeout bounds a whole delivery when the context passed to
+    // Send has no deadline of its own.
+    DefaultSMTPTimeout = time.Minute
+)
+
+// Mailer sends raw messages through an SMTP server. STARTTLS is used when
+// the server offers it, and credentials are only sent over TLS or to
+// localhost.
+type Mailer struct {
+    Host     string
+    Port     int
+    Username string
+    Password string
+    From     string
+}
+
+func (m Mailer) addr() string {
+    port := m.Port
+    if port == 0 {
+        port = DefaultSMTPPort
+    }
+    return net.JoinHostPort(m.Host, strconv.Itoa(port))
+}
+
+// Send delivers msg, a complete message with headers, to the recipients.
+// The connection is closed when ctx is done or its deadline passes.
+func (m Mailer) Send(ctx context.Context, to []string, msg []byte) error {
+    if m.Host == "" {
+        return errors.New("No SMTP host configured")
+    }
+    if m.From == "" {
+        return errors.New("No sender address configured")
+    }
+
+    err := m.send(ctx, to, msg)
+    if err != nil {
+        return fmt.Errorf("Failed to send mail via %s: %w", m.addr(), err)
+    }
+    return nil
+}
+
+// send is smtp.SendMail on a connection bound to ctx.
+func (m Mailer) send(ctx context.Context, to []string, msg []byte) error {
+    if _, ok := ctx.Deadline(); !ok {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, DefaultSMTPTimeout)
+        defer cancel()
+    }
+
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "tcp", m.addr())
+    if err != nil {
+        return err
+    }
+    deadline, _ := ctx.Deadline()
+    conn.SetDeadline(deadline)
+    // Unblocks reads and writes when ctx is cancelled before its deadline.
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    defer stop()
+
+    c, err := smtp.NewClient(conn, m.Host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer c.Close()
+
+    if ok, _ := c.Extension("STARTTLS"); ok {
+        err = c.StartTLS(&tls.Config{ServerName: m.Host})
+        if err != nil {
+            return err
+        }
+    }
+    if m.Username != "" {
+        if ok, _ := c.Extension("AUTH"); !ok {
+            return errors.New("Server does not support AUTH")
+        }
+        err = c.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host))
+        if err != nil {
+            return err
+        }
+    }
+
+    err = c.Mail(m.From)
+    if err != nil {
+        return err
+    }
+    for _, addr := range(to) {
+        err = c.Rcpt(addr)
+        if err != nil {
+            return err
+        }
+    }
+    w, err := c.Data()
+    if err != nil {
+        return err
+    }
+    _, err = w.Write(msg)
+    if err != nil {
+        return err
+    }
+    err = w.Close()
+    if err != nil {
+        return err
+    }
+    return c.Quit()
+}
+
+// Header writes the common headers of a message from the mailer's sender.
+func (m Mailer) Header(buf *bytes.Buffer, to []string, subject string) {
+    fmt.Fprintf(buf, "From: %s\r\n", m.From)
+    fmt.Fprintf(buf, "To: %s\r\n", strings.Join(to, ", "))
+    fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", oneLine(subject)))
+    fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    buf.WriteString("MIME-Version: 1.0\r\n")
+}
+
+// oneLine keeps user supplied text from adding headers of its own.
+func oneLine(s string) string {
+    return strings.Join(strings.Fields(s), " ")
+}
+
+// Email sends the message as a plain text mail to To.
+type Email struct {
+    Mailer Mailer
+    To     string
+}
+
+func (e Email) Notify(ctx context.Context, msg Message) error {
+    body := msg.Body
+    if msg.URL != "" {
+        body = strings.TrimSpace(body + "\n\n" + msg.URL)
+    }
+
+    var buf bytes.Buffer
+    e.Mailer.Header(&buf, []string{e.To}, msg.Subject)
+    buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+    buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+    buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+    buf.WriteString("\r\n")
+
+    return e.Mailer.Send(ctx, []string{e.To}, buf.Bytes())
+}
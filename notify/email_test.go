@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is a minimal SMTP server that accepts a single message and
+// reports the envelope and data it received.
+type smtpStandIn struct {
+    ln      net.Listener
+    done    chan smtpReceived
+}
+
+type smtpReceived struct {
+    from    string
+    to      []string
+    data    string
+    err     error
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+    t.Helper()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Failed to listen: %v", err)
+    }
+    t.Cleanup(func() { ln.Close() })
+
+    srv := &smtpStandIn{ln: ln, done: make(chan smtpReceived, 1)}
+    go srv.serve()
+    return srv
+}
+
+func (srv *smtpStandIn) port() int {
+    return srv.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (srv *smtpStandIn) serve() {
+    conn, err := srv.ln.Accept()
+    if err != nil {
+        srv.done <- smtpReceived{err: err}
+        return
+    }
+    defer conn.Close()
+
+    tp := textproto.NewConn(conn)
+    var got smtpReceived
+    tp.PrintfLine("220 localhost stand-in")
+    for {
+        line, err := tp.ReadLine()
+        if err != nil {
+            got.err = err
+            srv.done <- got
+            return
+        }
+        verb, arg, _ := strings.Cut(line, " ")
+        switch strings.ToUpper(verb) {
+        case "EHLO", "HELO":
+            tp.PrintfLine("250 localhost")
+        case "MAIL":
+            got.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+            tp.PrintfLine("250 OK")
+        case "RCPT":
+            got.to = append(got.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+            tp.PrintfLine("250 OK")
+        case "DATA":
+            tp.PrintfLine("354 Go ahead")
+            data, err := tp.ReadDotLines()
+            if err != nil {
+                got.err = err
+                srv.done <- got
+                return
+            }
+            got.data = strings.Join(data, "\n")
+            tp.PrintfLine("250 Queued")
+        case "QUIT":
+            tp.PrintfLine("221 Bye")
+            srv.done <- got
+            return
+        default:
+            tp.PrintfLine("502 Not implemented")
+        }
+    }
+}
+
+func TestEmailNotify(t *testing.T) {
+    srv := newSMTPStandIn(t)
+
+    email := Email{
+        Mailer: Mailer{Host: "127.0.0.1", Port: srv.port(), From: "gator@example.com"},
+        To: "alice@example.com",
+    }
+    err := email.Notify(context.Background(), Message{
+        Subject: "gator: \"postgres\"\r\nBcc: mallory@example.com",
+        Body: "Postgres 18 released",
+        URL: "https://example.com/pg18",
+    })
+    if err != nil {
+        t.Fatalf("Notify failed: %v", err)
+    }
+
+    got := <-srv.done
+    if got.err != nil {
+        t.Fatalf("Stand-in failed: %v", got.err)
+    }
+    if got.from != "gator@example.com" {
+        t.Errorf("MAIL FROM = %q, want gator@example.com", got.from)
+    }
+    if len(got.to) != 1 || got.to[0] != "alice@example.com" {
+        t.Errorf("RCPT TO = %q, want [alice@example.com]", got.to)
+    }
+
+    header, body, ok := strings.Cut(got.data, "\n\n")
+    if !ok {
+        t.Fatalf("Message has no body:\n%s", got.data)
+    }
+    for _, want := range([]string{"From: gator@example.com", "To: alice@example.com", "Content-Type: text/plain; charset=utf-8"}) {
+        if !strings.Contains(header, want) {
+            t.Errorf("Header lacks %q:\n%s", want, header)
+        }
+    }
+    if strings.Contains(header, "\nBcc:") {
+        t.Errorf("Subject injected a header:\n%s", header)
+    }
+    if body != "Postgres 18 released\n\nhttps://example.com/pg18" {
+        t.Errorf("Body = %q", body)
+    }
+}
+
+func TestMailerSendRejected(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Failed to listen: %v", err)
+    }
+    defer ln.Close()
+    go func() {
+        conn, err := ln.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        w := bufio.NewWriter(conn)
+        w.WriteString("554 No service\r\n")
+        w.Flush()
+    }()
+
+    mailer := Mailer{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, From: "gator@example.com"}
+    err = mailer.Send(context.Background(), []string{"alice@example.com"}, []byte("Subject: hi\r\n\r\nhi\r\n"))
+    if err == nil {
+        t.Fatal("Send succeeded against a server refusing service")
+    }
+    if !strings.Contains(err.Error(), "127.0.0.1:" + strconv.Itoa(mailer.Port)) {
+        t.Errorf("Error %q does not name the server", err)
+    }
+}
+
+func TestMailerSendHungServer(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Failed to listen: %v", err)
+    }
+    defer ln.Close()
+    // Accepts and then never greets.
+    go func() {
+        conn, err := ln.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        buf := make([]byte, 1)
+        conn.Read(buf)
+    }()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+    defer cancel()
+    mailer := Mailer{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, From: "gator@example.com"}
+    start := time.Now()
+    err = mailer.Send(ctx, []string{"alice@example.com"}, []byte("Subject: hi\r\n\r\nhi\r\n"))
+    if err == nil {
+        t.Fatal("Send succeeded against a server that never answers")
+    }
+    if elapsed := time.Since(start); elapsed > 5 * time.Second {
+        t.Errorf("Send took %v, want it to give up with ctx", elapsed)
+    }
+}
+
+func TestMailerSendUnconfigured(t *testing.T) {
+    err := Mailer{From: "gator@example.com"}.Send(context.Background(), []string{"alice@example.com"}, nil)
+    if err == nil {
+        t.Error("Send succeeded without a host")
+    }
+    err = Mailer{Host: "127.0.0.1"}.Send(context.Background(), []string{"alice@example.com"}, nil)
+    if err == nil {
+        t.Error("Send succeeded without a sender")
+    }
+}
@@ -0,0 +1,18 @@
+// Package notify delivers short alerts through desktop notifications,
+// webhooks or email.
+package notify
+
+import "context"
+
+// Message is a single alert. URL points at whatever the alert is about and
+// may be empty.
+type Message struct {
+    Subject string
+    Body    string
+    URL     string
+}
+
+// Notifier delivers a message to one destination.
+type Notifier interface {
+    Notify(ctx context.Context, msg Message) error
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultWebhookTimeout bounds a webhook call when no client is given.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// Webhook POSTs the message as JSON to URL.
+type Webhook struct {
+    URL    string
+    Client *http.Client
+}
+
+type webhookPayload struct {
+    Subject string `json:"subject"`
+    Body    string `json:"body"`
+    URL     string `json:"url,omitempty"`
+}
+
+func (w Webhook) Notify(ctx context.Context, msg Message) error {
+    client := w.Client
+    if client == nil {
+        client = &http.Client{Timeout: DefaultWebhookTimeout}
+    }
+
+    data, err := json.Marshal(webhookPayload{
+        Subject: msg.Subject,
+        Body: msg.Body,
+        URL: msg.URL,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to encode webhook payload: %w", err)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(data))
+    if err != nil {
+        return fmt.Errorf("Failed to create webhook request: %w", err)
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return fmt.Errorf("Webhook request failed: %w", err)
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 1 << 16))
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("Webhook returned %s", resp.Status)
+    }
+    return nil
+}
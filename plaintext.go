@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// plainText strips markup from a feed description, dropping scripts and
+// styles and collapsing whitespace.
+func plainText(markup string) string {
+    return strings.Join(plainParagraphs(markup), " ")
+}
+
+// plainParagraphs is plainText split where the markup breaks into blocks,
+// for callers that lay the text out themselves.
+func plainParagraphs(markup string) []string {
+    var paragraphs []string
+    var b strings.Builder
+    skip := 0
+
+    flush := func() {
+        if text := strings.Join(strings.Fields(b.String()), " "); text != "" {
+            paragraphs = append(paragraphs, text)
+        }
+        b.Reset()
+    }
+
+    tokenizer := html.NewTokenizer(strings.NewReader(markup))
+    for {
+        switch tokenizer.Next() {
+        case html.ErrorToken:
+            flush()
+            return paragraphs
+        case html.StartTagToken, html.SelfClosingTagToken:
+            name, _ := tokenizer.TagName()
+            switch string(name) {
+            case "script", "style":
+                skip++
+            case "br", "p", "div", "li", "blockquote", "pre", "tr", "h1", "h2", "h3", "h4", "h5", "h6":
+                flush()
+            }
+        case html.EndTagToken:
+            name, _ := tokenizer.TagName()
+            switch string(name) {
+            case "script", "style":
+                if skip > 0 {
+                    skip--
+                }
+            case "p", "div", "li", "blockquote", "pre", "tr", "h1", "h2", "h3", "h4", "h5", "h6":
+                flush()
+            }
+        case html.TextToken:
+            if skip == 0 {
+                b.Write(tokenizer.Text())
+            }
+        }
+    }
+}
+
+// truncate shortens s to at most max runes, cutting at a word boundary.
+func truncate(s string, max int) string {
+    if utf8.RuneCountInString(s) <= max {
+        return s
+    }
+
+    runes := []rune(s)[:max]
+    cut := string(runes)
+    if i := strings.LastIndexByte(cut, ' '); i > max / 2 {
+        cut = cut[:i]
+    }
+    return strings.TrimRight(cut, " .,;:") + "…"
+}
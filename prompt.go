@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// promptPassword reads a password without echo when stdin is a terminal, and
+// a plain line otherwise so scripts can pipe one in.
+func promptPassword(prompt string) (string, error) {
+    fmt.Fprint(os.Stderr, prompt)
+
+    fd := int(os.Stdin.Fd())
+    if term.IsTerminal(fd) {
+        password, err := term.ReadPassword(fd)
+        fmt.Fprintln(os.Stderr)
+        if err != nil {
+            return "", fmt.Errorf("Failed to read password: %w", err)
+        }
+        return string(password), nil
+    }
+
+    line, err := stdinReader.ReadString('\n')
+    if err != nil && !errors.Is(err, io.EOF) {
+        return "", fmt.Errorf("Failed to read password: %w", err)
+    }
+    return strings.TrimRight(line, "\r\n"), nil
+}
+
+// promptNewPassword asks for a password twice. An empty answer is returned
+// as is so callers can decide whether a password is optional.
+func promptNewPassword(prompt string) (string, error) {
+    password, err := promptPassword(prompt)
+    if err != nil || password == "" {
+        return password, err
+    }
+
+    confirm, err := promptPassword("Confirm password: ")
+    if err != nil {
+        return "", err
+    }
+    if confirm != password {
+        return "", errors.New("Passwords do not match")
+    }
+
+    return password, nil
+}
+
+// confirmAction asks the user to type answer before a destructive action.
+// Without a terminal there is nobody to ask, so callers must pass --yes.
+func confirmAction(prompt, answer string) (bool, error) {
+    if !term.IsTerminal(int(os.Stdin.Fd())) {
+        return false, errors.New("Refusing to continue without a terminal, pass --yes to confirm")
+    }
+
+    fmt.Fprintf(os.Stderr, "%s\nType %q to continue: ", prompt, answer)
+    line, err := stdinReader.ReadString('\n')
+    if err != nil && !errors.Is(err, io.EOF) {
+        return false, fmt.Errorf("Failed to read confirmation: %w", err)
+    }
+
+    return strings.TrimSpace(line) == answer, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+const (
+    feedTokenPrefix = "gfeed_"
+
+    // publishedFeedSize is how many posts a published feed carries.
+    publishedFeedSize = 50
+)
+
+// feedFormat is one of the files a published feed is served as.
+type feedFormat struct {
+    contentType string
+    write       func(outFeed, io.Writer) error
+}
+
+var feedFormats = map[string]feedFormat{
+    "rss.xml": {"application/rss+xml; charset=utf-8", outFeed.writeRSS},
+    "atom.xml": {"application/atom+xml; charset=utf-8", outFeed.writeAtom},
+    "feed.json": {"application/feed+json; charset=utf-8", outFeed.writeJSONFeed},
+}
+
+// registerFeedRoutes publishes a user's river, folders and followed feeds.
+// Feed readers cannot send API keys, so these are authenticated by a
+// read-only token in the path instead.
+func registerFeedRoutes(mux *http.ServeMux, s *state) {
+    mux.HandleFunc("GET /feeds/{token}/{file}", feedTokenUser(s, feedFile(serveRiverFeed)))
+    mux.HandleFunc("GET /feeds/{token}/folders/{folder}/{file}", feedTokenUser(s, feedFile(serveFolderFeed)))
+    mux.HandleFunc("GET /feeds/{token}/feeds/{feedID}/{file}", feedTokenUser(s, feedFile(serveFollowedFeed)))
+}
+
+func feedTokenUser(s *state, handler func(s *state, w http.ResponseWriter, r *http.Request, user database.User)) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, err := s.db.GetUserByFeedToken(r.Context(), hashToken(r.PathValue("token")))
+        if err != nil {
+            if errors.Is(err, sql.ErrNoRows) {
+                http.NotFound(w, r)
+                return
+            }
+            respondWithDBError(w, err)
+            return
+        }
+
+        handler(s, w, r, user)
+    }
+}
+
+// feedFile answers file names that are not one of feedFormats with a 404.
+func feedFile(handler func(s *state, w http.ResponseWriter, r *http.Request, user database.User)) func(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    return func(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+        if _, ok := feedFormats[r.PathValue("file")]; !ok {
+            http.NotFound(w, r)
+            return
+        }
+        handler(s, w, r, user)
+    }
+}
+
+func serveRiverFeed(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    servePublishedFeed(s, w, r, user, outFeed{
+        Title: fmt.Sprintf("%s's river", user.Name),
+        Description: fmt.Sprintf("Latest posts from the feeds %s follows, collected by gator", user.Name),
+    }, database.GetPostsForUserPageParams{})
+}
+
+func serveFolderFeed(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    folder := r.PathValue("folder")
+    servePublishedFeed(s, w, r, user, outFeed{
+        Title: fmt.Sprintf("%s: %s", user.Name, folder),
+        Description: fmt.Sprintf("Latest posts from %s's %s folder, collected by gator", user.Name, folder),
+    }, database.GetPostsForUserPageParams{
+        Folder: sql.NullString{String: folder, Valid: true},
+    })
+}
+
+func serveFollowedFeed(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    feedID, err := uuid.Parse(r.PathValue("feedID"))
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+
+    feeds, err := followedFeeds(s, user)
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+    feed, ok := feeds[feedID]
+    if !ok {
+        http.NotFound(w, r)
+        return
+    }
+
+    title := feed.FeedName
+    if feed.Title.Valid {
+        title = feed.Title.String
+    }
+    servePublishedFeed(s, w, r, user, outFeed{
+        Title: title,
+        Description: fmt.Sprintf("%s as followed by %s, collected by gator", feed.FeedName, user.Name),
+        HomeURL: feed.FeedUrl,
+    }, database.GetPostsForUserPageParams{
+        FeedID: uuid.NullUUID{UUID: feedID, Valid: true},
+    })
+}
+
+// servePublishedFeed fills in the rest of feed from the latest posts params
+// selects, leaving out the ones the user hid, and writes it in the requested
+// format. Readers polling with If-Modified-Since get a 304 until a newer post
+// arrives or the user changes what the feed selects. Links in the feed are built from public_url rather than the Host
+// header, which the client controls, so without it there is nothing to serve.
+func servePublishedFeed(s *state, w http.ResponseWriter, r *http.Request, user database.User, feed outFeed, params database.GetPostsForUserPageParams) {
+    if s.cfg.PublicURL == "" {
+        respondWithError(w, http.StatusServiceUnavailable, "Published feeds need public_url in the config")
+        return
+    }
+
+    feeds, err := followedFeeds(s, user)
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+    params.UserID = user.ID
+    params.PageLimit = publishedFeedSize
+    posts, err := visiblePosts(r.Context(), s, params, feeds)
+    if err != nil {
+        respondWithDBError(w, err)
+        return
+    }
+
+    for _, post := range(posts) {
+        source := feeds[post.FeedID]
+        feed.Items = append(feed.Items, newOutItem(post, source.FeedName, source.FeedUrl))
+    }
+
+    base := strings.TrimSuffix(s.cfg.PublicURL, "/")
+    feed.SelfURL = base + r.URL.EscapedPath()
+    feed.ID = feed.SelfURL
+    if feed.HomeURL == "" {
+        feed.HomeURL = base
+    }
+    // Posts also come and go when follows, folders, rules or hidden posts
+    // change, so that counts as an update too.
+    feed.Updated = newestUpdate(feed.Items)
+    changedAt, err := s.db.GetRiverChangedAt(r.Context(), user.ID)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        respondWithDBError(w, err)
+        return
+    }
+    if changedAt.After(feed.Updated) {
+        feed.Updated = changedAt
+    }
+
+    file := r.PathValue("file")
+    format := feedFormats[file]
+
+    var buf bytes.Buffer
+    err = format.write(feed, &buf)
+    if err != nil {
+        respondWithError(w, http.StatusInternalServerError, "Failed to render feed")
+        return
+    }
+
+    w.Header().Set("Content-Type", format.contentType)
+    http.ServeContent(w, r, file, feed.Updated, bytes.NewReader(buf.Bytes()))
+}
+
+// handlerFeedToken manages the token in the URLs of a user's published
+// feeds. Only its hash is stored, so create prints the URLs once.
+func handlerFeedToken(s *state, cmd command, user database.User) error {
+    if len(cmd.args) != 1 {
+        return errors.New("The feedtoken command expects a subcommand: create or revoke")
+    }
+
+    switch cmd.args[0] {
+    case "create":
+        return feedTokenCreate(s, user)
+    case "revoke":
+        removed, err := s.db.DeleteFeedToken(context.Background(), user.ID)
+        if err != nil {
+            return fmt.Errorf("Failed to revoke feed token: %w", err)
+        }
+        if removed == 0 {
+            return errors.New("No feed token to revoke")
+        }
+        fmt.Println("Feed token revoked, your published feeds are no longer reachable")
+        return nil
+    default:
+        return fmt.Errorf("Unknown feedtoken subcommand: %s", cmd.args[0])
+    }
+}
+
+func feedTokenCreate(s *state, user database.User) error {
+    token, err := randomToken(feedTokenPrefix)
+    if err != nil {
+        return err
+    }
+
+    err = s.db.SetFeedToken(context.Background(), database.SetFeedTokenParams{
+        UserID: user.ID,
+        CreatedAt: time.Now(),
+        TokenHash: hashToken(token),
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to store feed token: %w", err)
+    }
+
+    base := strings.TrimSuffix(s.cfg.PublicURL, "/")
+    if base == "" {
+        fmt.Println("public_url is not set in the config, so serve answers these feeds with an error until it is.")
+        base = "http://<serve address>"
+    }
+    root := base + "/feeds/" + token
+
+    fmt.Println("New feed token created, any previous one stops working. Keep these URLs private:")
+    fmt.Printf("River:   %s/rss.xml\n", root)
+    fmt.Printf("Folder:  %s/folders/<folder>/rss.xml\n", root)
+    fmt.Printf("Feed:    %s/feeds/<feed id>/rss.xml\n", root)
+    fmt.Println("Use atom.xml or feed.json instead of rss.xml for Atom or JSON Feed.")
+
+    follows, err := s.db.GetFeedFollowsWithFolders(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to fetch followed feeds: %w", err)
+    }
+    seen := make(map[string]bool)
+    for _, follow := range(follows) {
+        if follow.Folder.Valid && !seen["folder:" + follow.Folder.String] {
+            seen["folder:" + follow.Folder.String] = true
+            fmt.Printf("- folder %s: %s/folders/%s/rss.xml\n", follow.Folder.String, root, url.PathEscape(follow.Folder.String))
+        }
+    }
+    for _, follow := range(follows) {
+        if !seen["feed:" + follow.FeedID.String()] {
+            seen["feed:" + follow.FeedID.String()] = true
+            fmt.Printf("- %s: %s/feeds/%s/rss.xml\n", follow.FeedName, root, follow.FeedID)
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,823 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+const (
+    readerPrefix = "/reader/api/0"
+
+    readerReadingList = "user/-/state/com.google/reading-list"
+    readerRead = "user/-/state/com.google/read"
+    readerStarred = "user/-/state/com.google/starred"
+    readerLabel = "user/-/label/"
+    readerFeed = "feed/"
+
+    // readerItemTag is the long form of an item id; clients send either it
+    // or the plain decimal id.
+    readerItemTag = "tag:google.com,2005:reader/item/"
+
+    readerDefaultItems = 20
+    readerMaxIDs = 10000
+    readerMaxItems = 1000
+)
+
+var errReaderStream = errors.New("Unknown stream")
+
+type readerLink struct {
+    Href    string  `json:"href"`
+    Type    string  `json:"type,omitempty"`
+}
+
+type readerContent struct {
+    Direction   string  `json:"direction"`
+    Content     string  `json:"content"`
+}
+
+type readerOrigin struct {
+    StreamID    string  `json:"streamId"`
+    Title       string  `json:"title"`
+    HTMLURL     string  `json:"htmlUrl"`
+}
+
+type readerItem struct {
+    ID              string          `json:"id"`
+    CrawlTimeMsec   string          `json:"crawlTimeMsec"`
+    TimestampUsec   string          `json:"timestampUsec"`
+    Published       int64           `json:"published"`
+    Updated         int64           `json:"updated"`
+    Title           string          `json:"title"`
+    Canonical       []readerLink    `json:"canonical"`
+    Alternate       []readerLink    `json:"alternate"`
+    Summary         readerContent   `json:"summary"`
+    Author          string          `json:"author,omitempty"`
+    Categories      []string        `json:"categories"`
+    Origin          readerOrigin    `json:"origin"`
+}
+
+type readerItemRef struct {
+    ID              string      `json:"id"`
+    DirectStreamIDs []string    `json:"directStreamIds"`
+    TimestampUsec   string      `json:"timestampUsec"`
+}
+
+type readerCategory struct {
+    ID      string  `json:"id"`
+    Label   string  `json:"label"`
+}
+
+type readerSubscription struct {
+    ID          string              `json:"id"`
+    Title       string              `json:"title"`
+    Categories  []readerCategory    `json:"categories"`
+    URL         string              `json:"url"`
+    HTMLURL     string              `json:"htmlUrl"`
+    IconURL     string              `json:"iconUrl"`
+}
+
+// registerReaderRoutes serves enough of the Google Reader API for clients
+// such as Reeder, NetNewsWire and FeedMe. Subscriptions are feed follows,
+// labels are folders and items are posts with the user's read and star
+// state. Clients log in with a gator name and password, like the web UI.
+func registerReaderRoutes(mux *http.ServeMux, s *state) {
+    mux.HandleFunc("/accounts/ClientLogin", func(w http.ResponseWriter, r *http.Request) { readerClientLogin(s, w, r) })
+
+    mux.HandleFunc("GET " + readerPrefix + "/token", readerLoggedIn(s, readerToken))
+    mux.HandleFunc("GET " + readerPrefix + "/user-info", readerLoggedIn(s, readerUserInfo))
+    mux.HandleFunc("GET " + readerPrefix + "/subscription/list", readerLoggedIn(s, readerSubscriptions))
+    mux.HandleFunc("GET " + readerPrefix + "/tag/list", readerLoggedIn(s, readerTags))
+    mux.HandleFunc("GET " + readerPrefix + "/unread-count", readerLoggedIn(s, readerUnreadCount))
+    mux.HandleFunc("GET " + readerPrefix + "/stream/items/ids", readerLoggedIn(s, readerItemIDs))
+    mux.HandleFunc(readerPrefix + "/stream/items/contents", readerLoggedIn(s, readerItemContents))
+    mux.HandleFunc("GET " + readerPrefix + "/stream/contents", readerLoggedIn(s, readerStreamContents))
+    mux.HandleFunc("GET " + readerPrefix + "/stream/contents/{stream...}", readerLoggedIn(s, readerStreamContents))
+
+    mux.HandleFunc("POST " + readerPrefix + "/edit-tag", readerLoggedIn(s, readerEditTag))
+    mux.HandleFunc("POST " + readerPrefix + "/mark-all-as-read", readerLoggedIn(s, readerMarkAllAsRead))
+    mux.HandleFunc("POST " + readerPrefix + "/subscription/edit", readerLoggedIn(s, readerEditSubscription))
+    mux.HandleFunc("POST " + readerPrefix + "/subscription/quickadd", readerLoggedIn(s, readerQuickAdd))
+}
+
+// readerClientLogin trades a name and password for a session token, which
+// clients then send as "Authorization: GoogleLogin auth=<token>".
+func readerClientLogin(s *state, w http.ResponseWriter, r *http.Request) {
+    user, err := s.db.GetUserByName(r.Context(), r.FormValue("Email"))
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        webError(w, err)
+        return
+    }
+    if err != nil || !user.PasswordHash.Valid || checkPassword(user, r.FormValue("Passwd")) != nil {
+        http.Error(w, "Error=BadAuthentication", http.StatusForbidden)
+        return
+    }
+
+    token, err := createSession(r.Context(), s, user)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    fmt.Fprintf(w, "SID=%s\nLSID=null\nAuth=%s\n", token, token)
+}
+
+// readerLoggedIn resolves the ClientLogin token a client sends. Requests
+// that change anything must also carry the token from /token as T, the
+// API's equivalent of the web UI's CSRF token.
+func readerLoggedIn(s *state, handler func(s *state, w http.ResponseWriter, r *http.Request, sess webSession)) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "GoogleLogin auth=")
+        if !ok || token == "" {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        user, err := s.db.GetUserBySession(r.Context(), database.GetUserBySessionParams{
+            TokenHash: hashToken(token),
+            ExpiresAt: time.Now(),
+        })
+        if errors.Is(err, sql.ErrNoRows) {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        } else if err != nil {
+            webError(w, err)
+            return
+        }
+        sess := webSession{user: user, token: token}
+
+        if r.Method == http.MethodPost && !hmac.Equal([]byte(r.FormValue("T")), []byte(sess.csrfToken())) {
+            w.Header().Set("X-Reader-Google-Bad-Token", "true")
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        handler(s, w, r, sess)
+    }
+}
+
+func readerOK(w http.ResponseWriter) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Write([]byte("OK"))
+}
+
+func readerToken(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Write([]byte(sess.csrfToken()))
+}
+
+func readerUserInfo(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    respondWithJSON(w, http.StatusOK, map[string]string{
+        "userId": sess.user.ID.String(),
+        "userName": sess.user.Name,
+        "userProfileId": sess.user.ID.String(),
+        "userEmail": "",
+    })
+}
+
+func readerFeedStream(feedID uuid.UUID) string {
+    return readerFeed + feedID.String()
+}
+
+// readerStreamID drops the user id from user/<id>/... stream ids, which
+// always mean the caller.
+func readerStreamID(id string) string {
+    if rest, ok := strings.CutPrefix(id, "user/"); ok {
+        if _, after, found := strings.Cut(rest, "/"); found {
+            return "user/-/" + after
+        }
+    }
+    return id
+}
+
+func readerItemID(itemID int64) string {
+    return fmt.Sprintf("%s%016x", readerItemTag, uint64(itemID))
+}
+
+// parseReaderItemID accepts both the long and the decimal form of an id.
+func parseReaderItemID(id string) (int64, error) {
+    if hex, ok := strings.CutPrefix(id, readerItemTag); ok {
+        parsed, err := strconv.ParseUint(hex, 16, 64)
+        return int64(parsed), err
+    }
+    return strconv.ParseInt(id, 10, 64)
+}
+
+// readerFeedID finds the feed a feed/ stream names, by id or by URL.
+func readerFeedID(ctx context.Context, s *state, stream string) (uuid.UUID, error) {
+    value := strings.TrimPrefix(stream, readerFeed)
+    if feedID, err := uuid.Parse(value); err == nil {
+        return feedID, nil
+    }
+    feed, err := s.db.GetFeedByURL(ctx, value)
+    if errors.Is(err, sql.ErrNoRows) {
+        return uuid.Nil, errReaderStream
+    }
+    return feed.ID, err
+}
+
+// readerStreamParams narrows the river down to what a stream id names.
+func readerStreamParams(ctx context.Context, s *state, stream string) (database.GetRiverPostsParams, error) {
+    var params database.GetRiverPostsParams
+    stream = readerStreamID(stream)
+
+    switch {
+    case stream == "" || stream == readerReadingList:
+    case stream == readerStarred:
+        params.StarredOnly = true
+    case stream == readerRead:
+        params.ReadOnly = true
+    case strings.HasPrefix(stream, readerLabel):
+        params.Folder = sql.NullString{String: strings.TrimPrefix(stream, readerLabel), Valid: true}
+    case strings.HasPrefix(stream, readerFeed):
+        feedID, err := readerFeedID(ctx, s, stream)
+        if err != nil {
+            return params, err
+        }
+        params.FeedID = uuid.NullUUID{UUID: feedID, Valid: true}
+    default:
+        return params, errReaderStream
+    }
+    return params, nil
+}
+
+// readerFolders maps each followed feed to the folders it is filed in.
+func readerFolders(ctx context.Context, s *state, userID uuid.UUID) (map[uuid.UUID][]string, error) {
+    follows, err := s.db.GetFeedFollowsWithFolders(ctx, userID)
+    if err != nil {
+        return nil, err
+    }
+    folders := make(map[uuid.UUID][]string, len(follows))
+    for _, follow := range(follows) {
+        if follow.Folder.Valid {
+            folders[follow.FeedID] = append(folders[follow.FeedID], follow.Folder.String)
+        } else if _, ok := folders[follow.FeedID]; !ok {
+            folders[follow.FeedID] = nil
+        }
+    }
+    return folders, nil
+}
+
+func readerSubscriptions(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    follows, err := s.db.GetFeedFollowsWithFolders(r.Context(), sess.user.ID)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    subs := []readerSubscription{}
+    index := make(map[uuid.UUID]int)
+    for _, follow := range(follows) {
+        i, ok := index[follow.FeedID]
+        if !ok {
+            title := follow.FeedName
+            if follow.Title.Valid {
+                title = follow.Title.String
+            }
+            i = len(subs)
+            index[follow.FeedID] = i
+            subs = append(subs, readerSubscription{
+                ID: readerFeedStream(follow.FeedID),
+                Title: title,
+                Categories: []readerCategory{},
+                URL: follow.FeedUrl,
+                HTMLURL: follow.FeedUrl,
+            })
+        }
+        if follow.Folder.Valid {
+            subs[i].Categories = append(subs[i].Categories, readerCategory{
+                ID: readerLabel + follow.Folder.String,
+                Label: follow.Folder.String,
+            })
+        }
+    }
+
+    respondWithJSON(w, http.StatusOK, map[string]any{"subscriptions": subs})
+}
+
+func readerTags(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    nav, err := loadNav(r.Context(), s, sess.user.ID)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    type tag struct {
+        ID      string  `json:"id"`
+        Type    string  `json:"type,omitempty"`
+    }
+    tags := []tag{{ID: readerStarred}}
+    for _, folder := range(nav.Folders) {
+        tags = append(tags, tag{ID: readerLabel + folder.Name, Type: "folder"})
+    }
+
+    respondWithJSON(w, http.StatusOK, map[string]any{"tags": tags})
+}
+
+func readerUnreadCount(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    nav, err := loadNav(r.Context(), s, sess.user.ID)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    type count struct {
+        ID      string  `json:"id"`
+        Count   int64   `json:"count"`
+    }
+    counts := []count{{ID: readerReadingList, Count: nav.Unread}}
+    seen := make(map[uuid.UUID]bool)
+    addFeeds := func(feeds []webNavFeed) {
+        for _, feed := range(feeds) {
+            if !seen[feed.ID] {
+                seen[feed.ID] = true
+                counts = append(counts, count{ID: readerFeedStream(feed.ID), Count: feed.Unread})
+            }
+        }
+    }
+    for _, folder := range(nav.Folders) {
+        counts = append(counts, count{ID: readerLabel + folder.Name, Count: folder.Unread})
+        addFeeds(folder.Feeds)
+    }
+    addFeeds(nav.Unfiled)
+
+    respondWithJSON(w, http.StatusOK, map[string]any{
+        "max": readerMaxItems,
+        "unreadcounts": counts,
+    })
+}
+
+// readerQueryStream reads the stream query parameters shared by
+// stream/items/ids and stream/contents: n, r, c, ot, nt and xt.
+func readerQueryStream(ctx context.Context, s *state, r *http.Request, stream string, maxItems int) (database.GetRiverPostsParams, error) {
+    params, err := readerStreamParams(ctx, s, stream)
+    if err != nil {
+        return params, err
+    }
+
+    query := r.URL.Query()
+    limit := readerDefaultItems
+    if value := query.Get("n"); value != "" {
+        limit, err = strconv.Atoi(value)
+        if err != nil || limit < 1 {
+            return params, fmt.Errorf("Invalid n %q", value)
+        }
+    }
+    params.PageLimit = int32(min(limit, maxItems))
+
+    // The continuation is the offset of the next page.
+    if value := query.Get("c"); value != "" {
+        offset, err := strconv.ParseInt(value, 10, 32)
+        if err != nil || offset < 0 {
+            return params, fmt.Errorf("Invalid continuation %q", value)
+        }
+        params.PageOffset = int32(offset)
+    }
+
+    params.OldestFirst = query.Get("r") == "o"
+    for _, exclude := range(query["xt"]) {
+        if readerStreamID(exclude) == readerRead {
+            params.UnreadOnly = true
+        }
+    }
+    if value := query.Get("ot"); value != "" {
+        since, err := strconv.ParseInt(value, 10, 64)
+        if err != nil {
+            return params, fmt.Errorf("Invalid ot %q", value)
+        }
+        params.Since = sql.NullTime{Time: time.Unix(since, 0), Valid: true}
+    }
+    if value := query.Get("nt"); value != "" {
+        until, err := strconv.ParseInt(value, 10, 64)
+        if err != nil {
+            return params, fmt.Errorf("Invalid nt %q", value)
+        }
+        params.Until = sql.NullTime{Time: time.Unix(until, 0), Valid: true}
+    }
+
+    return params, nil
+}
+
+// readerPosts runs a river query for the caller. The continuation is empty
+// when there are no more posts.
+func readerPosts(s *state, r *http.Request, sess webSession, params database.GetRiverPostsParams) ([]database.GetRiverPostsRow, string, error) {
+    params.UserID = sess.user.ID
+    rows, err := s.db.GetRiverPosts(r.Context(), params)
+    if err != nil {
+        return nil, "", err
+    }
+
+    var continuation string
+    if len(rows) == int(params.PageLimit) {
+        continuation = strconv.Itoa(int(params.PageOffset) + len(rows))
+    }
+    rows, err = dropHiddenRiver(s, sess.user, rows)
+    return rows, continuation, err
+}
+
+func readerBadStream(w http.ResponseWriter, err error) {
+    if errors.Is(err, errReaderStream) {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func readerItemIDs(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    params, err := readerQueryStream(r.Context(), s, r, r.URL.Query().Get("s"), readerMaxIDs)
+    if err != nil {
+        readerBadStream(w, err)
+        return
+    }
+    rows, continuation, err := readerPosts(s, r, sess, params)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    refs := make([]readerItemRef, 0, len(rows))
+    for _, row := range(rows) {
+        refs = append(refs, readerItemRef{
+            ID: strconv.FormatInt(row.ItemID, 10),
+            DirectStreamIDs: []string{},
+            TimestampUsec: strconv.FormatInt(row.PublishedAt.UnixMicro(), 10),
+        })
+    }
+
+    response := map[string]any{"itemRefs": refs}
+    if continuation != "" {
+        response["continuation"] = continuation
+    }
+    respondWithJSON(w, http.StatusOK, response)
+}
+
+func readerStreamContents(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    stream := r.PathValue("stream")
+    if stream == "" {
+        stream = r.URL.Query().Get("s")
+    }
+    params, err := readerQueryStream(r.Context(), s, r, stream, readerMaxItems)
+    if err != nil {
+        readerBadStream(w, err)
+        return
+    }
+    rows, continuation, err := readerPosts(s, r, sess, params)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    if stream == "" {
+        stream = readerReadingList
+    }
+    writeReaderItems(s, w, r, sess, readerStreamID(stream), rows, continuation)
+}
+
+// readerItemContents returns the posts with the ids given as i, which
+// clients usually POST in batches after asking for ids.
+func readerItemContents(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    r.ParseForm()
+    var ids []int64
+    for _, value := range(r.Form["i"]) {
+        id, err := parseReaderItemID(value)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Invalid item id %q", value), http.StatusBadRequest)
+            return
+        }
+        ids = append(ids, id)
+    }
+    if len(ids) == 0 {
+        writeReaderItems(s, w, r, sess, readerReadingList, nil, "")
+        return
+    }
+    if len(ids) > readerMaxItems {
+        http.Error(w, fmt.Sprintf("At most %d items can be fetched at once", readerMaxItems), http.StatusBadRequest)
+        return
+    }
+
+    rows, _, err := readerPosts(s, r, sess, database.GetRiverPostsParams{
+        ItemIds: ids,
+        PageLimit: int32(len(ids)),
+    })
+    if err != nil {
+        webError(w, err)
+        return
+    }
+    writeReaderItems(s, w, r, sess, readerReadingList, rows, "")
+}
+
+func writeReaderItems(s *state, w http.ResponseWriter, r *http.Request, sess webSession, stream string, rows []database.GetRiverPostsRow, continuation string) {
+    folders, err := readerFolders(r.Context(), s, sess.user.ID)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+    feeds, err := followedFeeds(s, sess.user)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    items := make([]readerItem, 0, len(rows))
+    for _, row := range(rows) {
+        categories := []string{readerReadingList}
+        if row.ReadAt.Valid {
+            categories = append(categories, readerRead)
+        }
+        if row.StarredAt.Valid {
+            categories = append(categories, readerStarred)
+        }
+        for _, folder := range(folders[row.FeedID]) {
+            categories = append(categories, readerLabel + folder)
+        }
+        categories = append(categories, row.Categories...)
+
+        links := []readerLink{{Href: row.Url, Type: "text/html"}}
+        items = append(items, readerItem{
+            ID: readerItemID(row.ItemID),
+            CrawlTimeMsec: strconv.FormatInt(row.CreatedAt.UnixMilli(), 10),
+            TimestampUsec: strconv.FormatInt(row.PublishedAt.UnixMicro(), 10),
+            Published: row.PublishedAt.Unix(),
+            Updated: row.UpdatedAt.Unix(),
+            Title: row.Title,
+            Canonical: links,
+            Alternate: links,
+            Summary: readerContent{Direction: "ltr", Content: row.Description.String},
+            Author: row.Author.String,
+            Categories: categories,
+            Origin: readerOrigin{
+                StreamID: readerFeedStream(row.FeedID),
+                Title: row.FeedTitle,
+                HTMLURL: feeds[row.FeedID].FeedUrl,
+            },
+        })
+    }
+
+    response := map[string]any{
+        "id": stream,
+        "updated": time.Now().Unix(),
+        "items": items,
+    }
+    if continuation != "" {
+        response["continuation"] = continuation
+    }
+    respondWithJSON(w, http.StatusOK, response)
+}
+
+// readerEditTag adds (a) or removes (r) the read and starred tags of the
+// items given as i. Other tags are accepted and ignored.
+func readerEditTag(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    var itemIDs []int64
+    for _, value := range(r.PostForm["i"]) {
+        id, err := parseReaderItemID(value)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Invalid item id %q", value), http.StatusBadRequest)
+            return
+        }
+        itemIDs = append(itemIDs, id)
+    }
+    posts, err := s.db.GetPostIDsByItemID(r.Context(), itemIDs)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    now := sql.NullTime{Time: time.Now(), Valid: true}
+    edit := func(tag string, value sql.NullTime) error {
+        for _, post := range(posts) {
+            var err error
+            switch readerStreamID(tag) {
+            case readerRead:
+                err = s.db.SetPostRead(r.Context(), database.SetPostReadParams{
+                    UserID: sess.user.ID,
+                    PostID: post.ID,
+                    ReadAt: value,
+                })
+            case readerStarred:
+                err = s.db.SetPostStarred(r.Context(), database.SetPostStarredParams{
+                    UserID: sess.user.ID,
+                    PostID: post.ID,
+                    StarredAt: value,
+                })
+            }
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    for _, tag := range(r.PostForm["a"]) {
+        if err := edit(tag, now); err != nil {
+            webError(w, err)
+            return
+        }
+    }
+    for _, tag := range(r.PostForm["r"]) {
+        if err := edit(tag, sql.NullTime{}); err != nil {
+            webError(w, err)
+            return
+        }
+    }
+
+    readerOK(w)
+}
+
+// readerMarkAllAsRead marks the posts of a stream published before ts, in
+// microseconds, read. Without ts it marks everything up to now.
+func readerMarkAllAsRead(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    params, err := readerStreamParams(r.Context(), s, r.PostFormValue("s"))
+    if err == nil && (params.StarredOnly || params.ReadOnly) {
+        err = errReaderStream
+    }
+    if err != nil {
+        readerBadStream(w, err)
+        return
+    }
+
+    before := time.Now()
+    if value := r.PostFormValue("ts"); value != "" {
+        usec, err := strconv.ParseInt(value, 10, 64)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Invalid ts %q", value), http.StatusBadRequest)
+            return
+        }
+        before = time.UnixMicro(usec)
+    }
+
+    _, err = s.db.MarkReadBefore(r.Context(), database.MarkReadBeforeParams{
+        UserID: sess.user.ID,
+        ReadAt: sql.NullTime{Time: time.Now(), Valid: true},
+        Before: before,
+        FeedID: params.FeedID,
+        Folder: params.Folder,
+    })
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    readerOK(w)
+}
+
+// readerSubscribe follows the feed at url, adding it first when nobody has
+// yet. title names a newly added feed.
+func readerSubscribe(ctx context.Context, s *state, user database.User, url, title string) (database.Feed, error) {
+    feed, err := s.db.GetFeedByURL(ctx, url)
+    if errors.Is(err, sql.ErrNoRows) {
+        if title == "" {
+            title = url
+        }
+        return addFeed(ctx, s, user, title, url)
+    } else if err != nil {
+        return database.Feed{}, err
+    }
+
+    _, err = s.db.CreateFeedFollow(ctx, database.CreateFeedFollowParams{
+        ID: uuid.New(),
+        CreatedAt: time.Now(),
+        UpdatedAt: time.Now(),
+        UserID: user.ID,
+        FeedID: feed.ID,
+    })
+    var pqErr *pq.Error
+    if err != nil && !(errors.As(err, &pqErr) && pqErr.Code == "23505") {
+        return database.Feed{}, err
+    }
+    return feed, nil
+}
+
+// readerEditSubscription subscribes to, unsubscribes from or edits the feeds
+// given as s, depending on ac. Edits rename the follow (t) and file it in (a)
+// or take it out of (r) folders.
+func readerEditSubscription(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    ctx := r.Context()
+    action := r.PostFormValue("ac")
+    title := r.PostFormValue("t")
+
+    for _, stream := range(r.PostForm["s"]) {
+        if !strings.HasPrefix(stream, readerFeed) {
+            readerBadStream(w, errReaderStream)
+            return
+        }
+
+        var feedID uuid.UUID
+        var err error
+        switch action {
+        case "subscribe":
+            var feed database.Feed
+            feed, err = readerSubscribe(ctx, s, sess.user, strings.TrimPrefix(stream, readerFeed), title)
+            feedID = feed.ID
+        case "unsubscribe", "edit":
+            feedID, err = readerFeedID(ctx, s, stream)
+        default:
+            http.Error(w, fmt.Sprintf("Unknown action %q", action), http.StatusBadRequest)
+            return
+        }
+        if errors.Is(err, errReaderStream) {
+            readerBadStream(w, err)
+            return
+        } else if err != nil {
+            webError(w, err)
+            return
+        }
+
+        if action == "unsubscribe" {
+            _, err = s.db.DeleteFeedFollow(ctx, database.DeleteFeedFollowParams{
+                UserID: sess.user.ID,
+                FeedID: feedID,
+            })
+            if err != nil {
+                webError(w, err)
+                return
+            }
+            continue
+        }
+
+        follow, err := s.db.GetFeedFollow(ctx, database.GetFeedFollowParams{
+            UserID: sess.user.ID,
+            FeedID: feedID,
+        })
+        if errors.Is(err, sql.ErrNoRows) {
+            http.Error(w, "Not subscribed to " + stream, http.StatusNotFound)
+            return
+        } else if err != nil {
+            webError(w, err)
+            return
+        }
+
+        if action == "edit" && title != "" {
+            err = s.db.SetFeedFollowTitle(ctx, database.SetFeedFollowTitleParams{
+                ID: follow.ID,
+                Title: sql.NullString{String: title, Valid: true},
+                UpdatedAt: time.Now(),
+            })
+            if err != nil {
+                webError(w, err)
+                return
+            }
+        }
+        for _, label := range(r.PostForm["a"]) {
+            folder, ok := strings.CutPrefix(readerStreamID(label), readerLabel)
+            if !ok {
+                continue
+            }
+            err = s.db.AddFollowFolder(ctx, database.AddFollowFolderParams{
+                FeedFollowID: follow.ID,
+                Folder: folder,
+                CreatedAt: time.Now(),
+            })
+            if err != nil {
+                webError(w, err)
+                return
+            }
+        }
+        for _, label := range(r.PostForm["r"]) {
+            folder, ok := strings.CutPrefix(readerStreamID(label), readerLabel)
+            if !ok {
+                continue
+            }
+            _, err = s.db.RemoveFollowFolder(ctx, database.RemoveFollowFolderParams{
+                FeedFollowID: follow.ID,
+                Folder: folder,
+            })
+            if err != nil {
+                webError(w, err)
+                return
+            }
+        }
+    }
+
+    readerOK(w)
+}
+
+func readerQuickAdd(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    url := strings.TrimPrefix(strings.TrimSpace(r.PostFormValue("quickadd")), readerFeed)
+    if url == "" {
+        http.Error(w, "Missing quickadd", http.StatusBadRequest)
+        return
+    }
+
+    feed, err := readerSubscribe(r.Context(), s, sess.user, url, "")
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    respondWithJSON(w, http.StatusOK, map[string]any{
+        "numResults": 1,
+        "query": url,
+        "streamId": readerFeedStream(feed.ID),
+        "streamName": feed.Name,
+    })
+}
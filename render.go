@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+const (
+    // siteDefaultPosts is how many posts the index and each feed page show
+    // unless render is given --limit.
+    siteDefaultPosts = 50
+
+    siteDefaultTitle = "gator planet"
+    siteSummaryLength = 400
+)
+
+type sitePost struct {
+    Title       string
+    URL         string
+    PublishedAt time.Time
+    Author      string
+    Summary     string
+    Feed        *siteFeed
+}
+
+type siteFeed struct {
+    Name        string
+    URL         string
+    // Page is the feed's page, relative to the site root.
+    Page        string
+    LastPost    time.Time
+    posts       []database.Post
+}
+
+// siteData is what every page template is rendered with.
+type siteData struct {
+    Title       string
+    // Root leads from the page being rendered back to the site root, so
+    // links keep working wherever the site is hosted.
+    Root        string
+    Generated   time.Time
+    Feeds       []*siteFeed
+    // Feed is set on a feed's own page.
+    Feed        *siteFeed
+    Posts       []sitePost
+}
+
+// siteSource is where a site's feeds and posts come from: every feed, or the
+// follows of one user with their personal titles and hidden posts left out.
+type siteSource struct {
+    s       *state
+    user    *database.User
+    follows map[uuid.UUID]database.GetFeedFollowsWithFoldersRow
+}
+
+func (src siteSource) feeds(ctx context.Context) ([]*siteFeed, map[uuid.UUID]*siteFeed, error) {
+    var feeds []*siteFeed
+    byID := make(map[uuid.UUID]*siteFeed)
+    add := func(id uuid.UUID, name, url string) {
+        feed := &siteFeed{Name: name, URL: url}
+        feeds = append(feeds, feed)
+        byID[id] = feed
+    }
+
+    if src.user == nil {
+        all, err := src.s.db.GetFeeds(ctx)
+        if err != nil {
+            return nil, nil, fmt.Errorf("Failed to fetch feeds: %w", err)
+        }
+        for _, feed := range(all) {
+            add(feed.ID, feed.Name, feed.Url)
+        }
+    } else {
+        for id, follow := range(src.follows) {
+            name := follow.FeedName
+            if follow.Title.Valid {
+                name = follow.Title.String
+            }
+            add(id, name, follow.FeedUrl)
+        }
+    }
+
+    slices.SortFunc(feeds, func(a, b *siteFeed) int {
+        return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+    })
+    used := make(map[string]bool)
+    for _, feed := range(feeds) {
+        slug := slugify(feed.Name)
+        for i := 2; used[slug]; i++ {
+            slug = slugify(feed.Name) + "-" + strconv.Itoa(i)
+        }
+        used[slug] = true
+        feed.Page = "feeds/" + slug + ".html"
+    }
+
+    return feeds, byID, nil
+}
+
+// posts returns the latest posts of one feed, or of all of them when feedID
+// is not valid.
+func (src siteSource) posts(ctx context.Context, feedID uuid.NullUUID, limit int32) ([]database.Post, error) {
+    if src.user == nil {
+        posts, err := src.s.db.GetLatestPosts(ctx, database.GetLatestPostsParams{
+            FeedID: feedID,
+            MaxPosts: limit,
+        })
+        if err != nil {
+            return nil, fmt.Errorf("Failed to fetch posts: %w", err)
+        }
+        return posts, nil
+    }
+
+    return visiblePosts(ctx, src.s, database.GetPostsForUserPageParams{
+        UserID: src.user.ID,
+        FeedID: feedID,
+        PageLimit: limit,
+    }, src.follows)
+}
+
+// slugify turns a feed name into a file name.
+func slugify(name string) string {
+    var b strings.Builder
+    dash := false
+    for _, r := range(strings.ToLower(name)) {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) {
+            if dash && b.Len() > 0 {
+                b.WriteByte('-')
+            }
+            b.WriteRune(r)
+            dash = false
+        } else {
+            dash = true
+        }
+    }
+    if b.Len() == 0 {
+        return "feed"
+    }
+    return b.String()
+}
+
+func newSitePosts(posts []database.Post, feeds map[uuid.UUID]*siteFeed) []sitePost {
+    out := make([]sitePost, 0, len(posts))
+    for _, post := range(posts) {
+        out = append(out, sitePost{
+            Title: post.Title,
+            URL: post.Url,
+            PublishedAt: post.PublishedAt,
+            Author: post.Author.String,
+            Summary: truncate(plainText(post.Description.String), siteSummaryLength),
+            Feed: feeds[post.FeedID],
+        })
+    }
+    return out
+}
+
+// handlerRender writes a static site of the latest posts to a directory:
+// index.html, a page per feed under feeds/, blogroll.html, feeds.opml and
+// atom.xml. Pages use the layout.html, index.html, feed.html and
+// blogroll.html templates, which site_templates can override.
+func handlerRender(s *state, cmd command) error {
+    title, args, err := cutOption(cmd.args, "title")
+    if err != nil {
+        return err
+    }
+    userName, args, err := cutOption(args, "user")
+    if err != nil {
+        return err
+    }
+    limitArg, args, err := cutOption(args, "limit")
+    if err != nil {
+        return err
+    }
+    base, args, err := cutOption(args, "base-url")
+    if err != nil {
+        return err
+    }
+    if len(args) != 1 {
+        return errors.New("The render command expects ONE argument: <outdir> [--user name] [--title title] [--limit n] [--base-url url]")
+    }
+    outDir := args[0]
+
+    if title == "" {
+        title = siteDefaultTitle
+    }
+    limit := siteDefaultPosts
+    if limitArg != "" {
+        limit, err = strconv.Atoi(limitArg)
+        if err != nil || limit < 1 {
+            return fmt.Errorf("Invalid limit %q, expected a positive number", limitArg)
+        }
+    }
+    base = strings.TrimSuffix(base, "/")
+
+    ctx := context.Background()
+    src := siteSource{s: s}
+    if userName != "" {
+        user, err := s.db.GetUserByName(ctx, userName)
+        if err != nil {
+            return fmt.Errorf("Failed to find user %s: %w", userName, err)
+        }
+        src.user = &user
+        src.follows, err = followedFeeds(s, user)
+        if err != nil {
+            return err
+        }
+    }
+
+    pages := make(map[string]func() ([]byte, error))
+    now := time.Now()
+
+    feeds, byID, err := src.feeds(ctx)
+    if err != nil {
+        return err
+    }
+    for id, feed := range(byID) {
+        feed.posts, err = src.posts(ctx, uuid.NullUUID{UUID: id, Valid: true}, int32(limit))
+        if err != nil {
+            return err
+        }
+        if len(feed.posts) > 0 {
+            feed.LastPost = feed.posts[0].PublishedAt
+        }
+    }
+    latest, err := src.posts(ctx, uuid.NullUUID{}, int32(limit))
+    if err != nil {
+        return err
+    }
+
+    data := siteData{
+        Title: title,
+        Generated: now,
+        Feeds: feeds,
+    }
+
+    pages["index.html"] = func() ([]byte, error) {
+        index := data
+        index.Posts = newSitePosts(latest, byID)
+        return renderSitePage(s, "index.html", index)
+    }
+    pages["blogroll.html"] = func() ([]byte, error) {
+        return renderSitePage(s, "blogroll.html", data)
+    }
+    for _, feed := range(feeds) {
+        pages[feed.Page] = func() ([]byte, error) {
+            page := data
+            page.Root = "../"
+            page.Feed = feed
+            page.Posts = newSitePosts(feed.posts, byID)
+            return renderSitePage(s, "feed.html", page)
+        }
+    }
+    pages["feeds.opml"] = func() ([]byte, error) {
+        var buf bytes.Buffer
+        err := writeSiteOPML(&buf, title, base, feeds, now)
+        return buf.Bytes(), err
+    }
+    pages["atom.xml"] = func() ([]byte, error) {
+        feed := outFeed{
+            ID: base + "/atom.xml",
+            Title: title,
+            SelfURL: base + "/atom.xml",
+            HomeURL: base + "/index.html",
+        }
+        if base == "" {
+            // Atom wants an absolute ID, so without a base URL use one that
+            // stays the same for the same title.
+            feed.ID = "urn:uuid:" + uuid.NewSHA1(uuid.NameSpaceURL, []byte("gator:site:" + title)).String()
+            feed.SelfURL = "atom.xml"
+            feed.HomeURL = "index.html"
+        }
+        for _, post := range(latest) {
+            source := byID[post.FeedID]
+            var name, url string
+            if source != nil {
+                name, url = source.Name, source.URL
+            }
+            feed.Items = append(feed.Items, newOutItem(post, name, url))
+        }
+        feed.Updated = newestUpdate(feed.Items)
+        if feed.Updated.IsZero() {
+            feed.Updated = now
+        }
+
+        var buf bytes.Buffer
+        err := feed.writeAtom(&buf)
+        return buf.Bytes(), err
+    }
+
+    err = os.MkdirAll(filepath.Join(outDir, "feeds"), 0o755)
+    if err != nil {
+        return fmt.Errorf("Failed to create output directory: %w", err)
+    }
+    for name, render := range(pages) {
+        content, err := render()
+        if err != nil {
+            return fmt.Errorf("Failed to render %s: %w", name, err)
+        }
+        err = writeFileAtomic(filepath.Join(outDir, filepath.FromSlash(name)), content)
+        if err != nil {
+            return fmt.Errorf("Failed to write %s: %w", name, err)
+        }
+    }
+
+    fmt.Printf("Site with %d feeds and %d posts written to %s\n", len(feeds), len(latest), outDir)
+    return nil
+}
+
+func renderSitePage(s *state, name string, data siteData) ([]byte, error) {
+    tmpl, err := parseHTMLTemplate(s.cfg.SiteTemplates, name, "layout.html")
+    if err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    err = tmpl.Execute(&buf, data)
+    if err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// writeFileAtomic replaces path with content in one step, so a web server
+// serving the directory never sees a half written page.
+func writeFileAtomic(path string, content []byte) error {
+    tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    _, err = tmp.Write(content)
+    if err != nil {
+        tmp.Close()
+        return err
+    }
+    err = tmp.Chmod(0o644)
+    if err != nil {
+        tmp.Close()
+        return err
+    }
+    err = tmp.Close()
+    if err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+type opmlOut struct {
+    XMLName xml.Name        `xml:"opml"`
+    Version string          `xml:"version,attr"`
+    Title   string          `xml:"head>title"`
+    Created string          `xml:"head>dateCreated"`
+    Outlines []opmlOutline  `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+    Type    string  `xml:"type,attr"`
+    Text    string  `xml:"text,attr"`
+    Title   string  `xml:"title,attr"`
+    XMLURL  string  `xml:"xmlUrl,attr"`
+    HTMLURL string  `xml:"htmlUrl,attr,omitempty"`
+}
+
+// writeSiteOPML lists the site's feeds so readers can subscribe to all of
+// them at once. htmlUrl points at the feed's page when base is known.
+func writeSiteOPML(w io.Writer, title, base string, feeds []*siteFeed, created time.Time) error {
+    out := opmlOut{
+        Version: "2.0",
+        Title: title,
+        Created: created.Format(time.RFC1123Z),
+    }
+    for _, feed := range(feeds) {
+        outline := opmlOutline{
+            Type: "rss",
+            Text: feed.Name,
+            Title: feed.Name,
+            XMLURL: feed.URL,
+        }
+        if base != "" {
+            outline.HTMLURL = base + "/" + feed.Page
+        }
+        out.Outlines = append(out.Outlines, outline)
+    }
+    return writeXML(w, out)
+}
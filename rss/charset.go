@@ -0,0 +1,58 @@
+package rss
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"mime"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/unicode"
+)
+
+var (
+    bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+    bomUTF16LE = []byte{0xFF, 0xFE}
+    bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// newFeedDecoder returns an xml.Decoder that reads body as UTF-8. The
+// encoding is taken from a byte order mark first, then from the charset of
+// the HTTP Content-Type, and finally from the XML declaration itself.
+func newFeedDecoder(body []byte, contentType string) *xml.Decoder {
+    r, transcoded := toUTF8(body, contentType)
+
+    decoder := xml.NewDecoder(r)
+    if transcoded {
+        // The body is already UTF-8, so whatever the declaration claims must
+        // not be applied a second time.
+        decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+            return input, nil
+        }
+    } else {
+        decoder.CharsetReader = charset.NewReaderLabel
+    }
+
+    return decoder
+}
+
+func toUTF8(body []byte, contentType string) (io.Reader, bool) {
+    switch {
+    case bytes.HasPrefix(body, bomUTF8):
+        return bytes.NewReader(body[len(bomUTF8):]), true
+    case bytes.HasPrefix(body, bomUTF16LE), bytes.HasPrefix(body, bomUTF16BE):
+        dec := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder()
+        return dec.Reader(bytes.NewReader(body)), true
+    }
+
+    if contentType != "" {
+        _, params, err := mime.ParseMediaType(contentType)
+        if err == nil && params["charset"] != "" {
+            if enc, _ := charset.Lookup(params["charset"]); enc != nil {
+                return enc.NewDecoder().Reader(bytes.NewReader(body)), true
+            }
+        }
+    }
+
+    return bytes.NewReader(body), false
+}
@@ -1,60 +1,352 @@
 package rss
 
 import (
+	"compress/gzip"
 	"context"
-	"encoding/xml"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"html"
 	"io"
+	"maps"
 	"net/http"
+	"net/url"
+	"os"
+	"sync"
 	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	DefaultTimeout      = 5 * time.Second
+	DefaultUserAgent    = "gator"
+	DefaultMaxRedirects = 10
+	DefaultMaxBodySize  = 10 << 20
+	DefaultHostInterval = time.Second
+	DefaultMaxPerHost   = 1
 )
 
 type RSSFeed struct {
 	Channel struct {
-		Title       string    `xml:"title"`
-		Link        string    `xml:"link"`
+		Title string `xml:"title"`
+		// AtomLinks comes before Link so atom:link elements, which carry
+		// the WebSub hub and self URLs, do not land in Link.
+		AtomLinks   []AtomLink `xml:"http://www.w3.org/2005/Atom link"`
+		Link        string     `xml:"link"`
 		Description string    `xml:"description"`
 		Item        []RSSItem `xml:"item"`
 	} `xml:"channel"`
 }
 
+type AtomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// Hub returns the WebSub hub the feed advertises, if any, and the topic URL
+// to subscribe to there. The topic is the feed's self link, or feedURL when
+// it has none.
+func (f *RSSFeed) Hub(feedURL string) (hub, topic string) {
+	topic = feedURL
+	for _, link := range f.Channel.AtomLinks {
+		switch link.Rel {
+		case "hub":
+			if hub == "" {
+				hub = link.Href
+			}
+		case "self":
+			topic = link.Href
+		}
+	}
+	return hub, topic
+}
+
 type RSSItem struct {
-	Title       string `xml:"title"`
-	Link        string `xml:"link"`
-	Description string `xml:"description"`
-	PubDate     string `xml:"pubDate"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	PubDate     string   `xml:"pubDate"`
+	Author      string   `xml:"author"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories  []string `xml:"category"`
+}
+
+// AuthorName returns the item's author, preferring dc:creator which most
+// blogs fill in over the email-style RSS author element.
+func (item RSSItem) AuthorName() string {
+	if item.Creator != "" {
+		return item.Creator
+	}
+	return item.Author
+}
+
+// FetchResult is a parsed feed together with what the server told us about
+// its location. MovedTo is only set when every redirect on the way was
+// permanent (301 or 308), so callers can safely update the stored URL.
+type FetchResult struct {
+	Feed       *RSSFeed
+	StatusCode int
+	Bytes      int64
+	MovedTo    string
+}
+
+// StatusError is returned for any non-2xx response.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+    return fmt.Sprintf("Unexpected response status: %s", e.Status)
+}
+
+// IsGone reports whether err means the feed was permanently removed.
+func IsGone(err error) bool {
+    var statusErr *StatusError
+    return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusGone
+}
+
+type redirectTrace struct {
+	permanent bool
+	location  string
+}
+
+type redirectTraceKey struct{}
+
+// Options configures a Fetcher. Zero values fall back to the defaults above,
+// and an empty Proxy defers to HTTP_PROXY/HTTPS_PROXY/NO_PROXY.
+type Options struct {
+	Timeout      time.Duration
+	UserAgent    string
+	ContactURL   string
+	Proxy        string
+	CABundle     string
+	MaxRedirects int
+	MaxBodySize  int64
+	Feeds        map[string]FeedOptions
+
+	// HostInterval is the minimum gap between two requests to the same
+	// host and MaxPerHost caps how many may run concurrently.
+	HostInterval  time.Duration
+	MaxPerHost    int
+	RespectRobots bool
+}
+
+// FeedOptions holds request settings for a single feed URL, typically used
+// for private feeds that need extra headers or basic-auth credentials.
+type FeedOptions struct {
+	Headers  map[string]string
+	Username string
+	Password string
 }
 
-func FetchFeed(ctx context.Context, feedURL string) (*RSSFeed, error) {
+// Fetcher downloads and parses feeds with a shared http.Client.
+type Fetcher struct {
+	client      *http.Client
+	userAgent   string
+	maxBodySize int64
+	feedsMu     sync.RWMutex
+	feeds       map[string]FeedOptions
+	hosts       *hostLimiter
+	robots      *robotsCache
+}
+
+func NewFetcher(opts Options) (*Fetcher, error) {
+    if opts.Timeout <= 0 {
+        opts.Timeout = DefaultTimeout
+    }
+    if opts.UserAgent == "" {
+        opts.UserAgent = DefaultUserAgent
+    }
+    if opts.ContactURL != "" {
+        opts.UserAgent = fmt.Sprintf("%s (+%s)", opts.UserAgent, opts.ContactURL)
+    }
+    if opts.MaxRedirects <= 0 {
+        opts.MaxRedirects = DefaultMaxRedirects
+    }
+    if opts.MaxBodySize <= 0 {
+        opts.MaxBodySize = DefaultMaxBodySize
+    }
+    if opts.HostInterval <= 0 {
+        opts.HostInterval = DefaultHostInterval
+    }
+    if opts.MaxPerHost <= 0 {
+        opts.MaxPerHost = DefaultMaxPerHost
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+
+    if opts.Proxy != "" {
+        proxyURL, err := url.Parse(opts.Proxy)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse proxy URL: %w", err)
+        }
+        transport.Proxy = http.ProxyURL(proxyURL)
+    }
+
+    if opts.CABundle != "" {
+        pool, err := loadCABundle(opts.CABundle)
+        if err != nil {
+            return nil, err
+        }
+        transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+    }
+
+    maxRedirects := opts.MaxRedirects
     client := &http.Client{
-        Timeout: 5 *  time.Second,
+        Timeout: opts.Timeout,
+        Transport: transport,
+        CheckRedirect: func(req *http.Request, via []*http.Request) error {
+            if len(via) >= maxRedirects {
+                return fmt.Errorf("Stopped after %d redirects", maxRedirects)
+            }
+            if trace, ok := req.Context().Value(redirectTraceKey{}).(*redirectTrace); ok {
+                code := req.Response.StatusCode
+                if code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+                    trace.permanent = false
+                }
+                trace.location = req.URL.String()
+            }
+            return nil
+        },
+    }
+
+    // Copied, since MoveFeed changes it.
+    feeds := make(map[string]FeedOptions, len(opts.Feeds))
+    maps.Copy(feeds, opts.Feeds)
+
+    fetcher := &Fetcher{
+        client: client,
+        userAgent: opts.UserAgent,
+        maxBodySize: opts.MaxBodySize,
+        feeds: feeds,
+        hosts: newHostLimiter(opts.HostInterval, opts.MaxPerHost),
+    }
+    if opts.RespectRobots {
+        fetcher.robots = newRobotsCache(opts.UserAgent)
     }
 
-    req, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
+    return fetcher, nil
+}
+
+// MoveFeed carries the options set for oldURL over to newURL, once a feed
+// has permanently moved there. Options newURL already has are kept.
+func (f *Fetcher) MoveFeed(oldURL, newURL string) {
+    f.feedsMu.Lock()
+    defer f.feedsMu.Unlock()
+    feedOpts, ok := f.feeds[oldURL]
+    if _, taken := f.feeds[newURL]; !ok || taken {
+        return
+    }
+    f.feeds[newURL] = feedOpts
+    delete(f.feeds, oldURL)
+}
+
+func loadCABundle(path string) (*x509.CertPool, error) {
+    pem, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to read CA bundle: %w", err)
+    }
+
+    pool, err := x509.SystemCertPool()
+    if err != nil {
+        pool = x509.NewCertPool()
+    }
+    if !pool.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("No certificates found in CA bundle %s", path)
+    }
+
+    return pool, nil
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, feedURL string) (*FetchResult, error) {
+    // Only the feed request is traced; robots.txt below uses ctx as is, so
+    // its redirects do not count as the feed's.
+    trace := &redirectTrace{permanent: true}
+    req, err := http.NewRequestWithContext(context.WithValue(ctx, redirectTraceKey{}, trace), "GET", feedURL, nil)
     if err != nil {
         return nil, fmt.Errorf("Failed to create request: %w", err)
     }
 
-    req.Header.Set("User-Agent", "gator")
+    req.Header.Set("User-Agent", f.userAgent)
+    req.Header.Set("Accept-Encoding", "gzip, br")
 
-    resp, err := client.Do(req)
+    f.feedsMu.RLock()
+    feedOpts, ok := f.feeds[feedURL]
+    f.feedsMu.RUnlock()
+    if ok {
+        for key, value := range feedOpts.Headers {
+            req.Header.Set(key, value)
+        }
+        if feedOpts.Username != "" || feedOpts.Password != "" {
+            req.SetBasicAuth(feedOpts.Username, feedOpts.Password)
+        }
+    }
+
+    if f.robots != nil {
+        allowed, err := f.robots.allowed(ctx, f.client, f.hosts, req.URL)
+        if err != nil {
+            return nil, err
+        }
+        if !allowed {
+            return nil, fmt.Errorf("Fetching %s is disallowed by robots.txt", feedURL)
+        }
+    }
+
+    host := req.URL.Host
+    release, err := f.hosts.acquire(ctx, host)
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+
+    resp, err := f.client.Do(req)
     if err != nil {
         return nil, fmt.Errorf("Failed to execute request: %w", err)
     }
     defer resp.Body.Close()
 
-    body, err := io.ReadAll(resp.Body)
+    if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+        f.hosts.backoff(host, retryAfter(resp))
+    }
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+    }
+
+    body, err := f.readBody(resp)
     if err != nil {
         return nil, fmt.Errorf("Failed to read response: %w", err)
     }
 
-    var result RSSFeed
-    err = xml.Unmarshal(body, &result)
+    result, err := Parse(body, resp.Header.Get("Content-Type"))
     if err != nil {
         return nil, fmt.Errorf("Failed to parse response: %w", err)
     }
 
+    fetched := &FetchResult{
+        Feed: result,
+        StatusCode: resp.StatusCode,
+        Bytes: int64(len(body)),
+    }
+    if trace.location != "" && trace.permanent {
+        fetched.MovedTo = trace.location
+    }
+
+    return fetched, nil
+}
+
+// Parse decodes a feed document, such as the content a WebSub hub pushes,
+// the same way Fetch decodes a response.
+func Parse(body []byte, contentType string) (*RSSFeed, error) {
+    var result RSSFeed
+    err := newFeedDecoder(body, contentType).Decode(&result)
+    if err != nil {
+        return nil, err
+    }
+
     result.Channel.Title = html.UnescapeString(result.Channel.Title)
     result.Channel.Description = html.UnescapeString(result.Channel.Description)
 
@@ -65,3 +357,31 @@ func FetchFeed(ctx context.Context, feedURL string) (*RSSFeed, error) {
 
     return &result, nil
 }
+
+// readBody decompresses the response according to Content-Encoding and
+// refuses bodies larger than the configured limit once decoded.
+func (f *Fetcher) readBody(resp *http.Response) ([]byte, error) {
+    var r io.Reader = resp.Body
+
+    switch resp.Header.Get("Content-Encoding") {
+    case "gzip":
+        gz, err := gzip.NewReader(resp.Body)
+        if err != nil {
+            return nil, err
+        }
+        defer gz.Close()
+        r = gz
+    case "br":
+        r = brotli.NewReader(resp.Body)
+    }
+
+    body, err := io.ReadAll(io.LimitReader(r, f.maxBodySize+1))
+    if err != nil {
+        return nil, err
+    }
+    if int64(len(body)) > f.maxBodySize {
+        return nil, errors.New("Response body exceeds maximum size")
+    }
+
+    return body, nil
+}
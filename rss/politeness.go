@@ -0,0 +1,133 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultBackoff = time.Minute
+
+// BackoffError is returned without contacting the host while it is still
+// inside a Retry-After window from an earlier 429 or 503 response.
+type BackoffError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *BackoffError) Error() string {
+    return fmt.Sprintf("Host %s asked us to back off until %s", e.Host, e.Until.Format(time.RFC1123))
+}
+
+// hostLimiter spaces out requests to the same host and caps how many of them
+// may be in flight at once.
+type hostLimiter struct {
+	interval      time.Duration
+	maxConcurrent int
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	slots        chan struct{}
+	next         time.Time
+	backoffUntil time.Time
+}
+
+func newHostLimiter(interval time.Duration, maxConcurrent int) *hostLimiter {
+    return &hostLimiter{
+        interval: interval,
+        maxConcurrent: maxConcurrent,
+        hosts: make(map[string]*hostState),
+    }
+}
+
+func (l *hostLimiter) state(host string) *hostState {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    st, ok := l.hosts[host]
+    if !ok {
+        st = &hostState{slots: make(chan struct{}, l.maxConcurrent)}
+        l.hosts[host] = st
+    }
+    return st
+}
+
+// acquire blocks until a request to host may start. The returned release
+// function must be called once the request is done.
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+    st := l.state(host)
+
+    select {
+    case st.slots <- struct{}{}:
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+    release := func() { <-st.slots }
+
+    l.mu.Lock()
+    now := time.Now()
+    if now.Before(st.backoffUntil) {
+        until := st.backoffUntil
+        l.mu.Unlock()
+        release()
+        return nil, &BackoffError{Host: host, Until: until}
+    }
+    start := now
+    if st.next.After(start) {
+        start = st.next
+    }
+    st.next = start.Add(l.interval)
+    l.mu.Unlock()
+
+    if wait := time.Until(start); wait > 0 {
+        timer := time.NewTimer(wait)
+        defer timer.Stop()
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+            release()
+            return nil, ctx.Err()
+        }
+    }
+
+    return release, nil
+}
+
+func (l *hostLimiter) backoff(host string, d time.Duration) {
+    st := l.state(host)
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if until := time.Now().Add(d); until.After(st.backoffUntil) {
+        st.backoffUntil = until
+    }
+}
+
+// retryAfter reads the Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func retryAfter(resp *http.Response) time.Duration {
+    value := resp.Header.Get("Retry-After")
+    if value == "" {
+        return defaultBackoff
+    }
+
+    if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+        return time.Duration(seconds) * time.Second
+    }
+
+    if when, err := http.ParseTime(value); err == nil {
+        if d := time.Until(when); d > 0 {
+            return d
+        }
+        return 0
+    }
+
+    return defaultBackoff
+}
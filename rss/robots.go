@@ -0,0 +1,187 @@
+package rss
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	robotsTTL     = 24 * time.Hour
+	robotsMaxSize = 512 << 10
+)
+
+// robotsCache keeps the parsed robots.txt of every host we fetched from.
+type robotsCache struct {
+	agent string
+
+	mu    sync.Mutex
+	rules map[string]*robotsRules
+}
+
+type robotsRules struct {
+	fetchedAt time.Time
+	rules     []robotsRule
+}
+
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+func newRobotsCache(userAgent string) *robotsCache {
+    agent := userAgent
+    if i := strings.IndexAny(agent, "/ "); i >= 0 {
+        agent = agent[:i]
+    }
+
+    return &robotsCache{
+        agent: strings.ToLower(agent),
+        rules: make(map[string]*robotsRules),
+    }
+}
+
+// allowed reports whether robots.txt lets us fetch target, downloading it
+// first when the cached copy is missing or stale. The download waits its
+// turn with hosts like any other request to the host.
+func (c *robotsCache) allowed(ctx context.Context, client *http.Client, hosts *hostLimiter, target *url.URL) (bool, error) {
+    key := target.Scheme + "://" + target.Host
+
+    c.mu.Lock()
+    rules, ok := c.rules[key]
+    c.mu.Unlock()
+
+    if !ok || time.Since(rules.fetchedAt) > robotsTTL {
+        release, err := hosts.acquire(ctx, target.Host)
+        if err != nil {
+            return false, err
+        }
+        rules = c.fetch(ctx, client, key)
+        release()
+
+        c.mu.Lock()
+        c.rules[key] = rules
+        c.mu.Unlock()
+    }
+
+    path := target.EscapedPath()
+    if target.RawQuery != "" {
+        path += "?" + target.RawQuery
+    }
+    return rules.allowed(path), nil
+}
+
+// fetch downloads robots.txt for origin. Anything other than a readable 2xx
+// response is treated as "no restrictions".
+func (c *robotsCache) fetch(ctx context.Context, client *http.Client, origin string) *robotsRules {
+    rules := &robotsRules{fetchedAt: time.Now()}
+
+    req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+    if err != nil {
+        return rules
+    }
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return rules
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return rules
+    }
+
+    rules.rules = parseRobots(io.LimitReader(resp.Body, robotsMaxSize), c.agent)
+    return rules
+}
+
+func (r *robotsRules) allowed(path string) bool {
+    var best *robotsRule
+    for i := range r.rules {
+        rule := &r.rules[i]
+        if !rule.pattern.MatchString(path) {
+            continue
+        }
+        if best == nil || rule.length > best.length || (rule.length == best.length && rule.allow) {
+            best = rule
+        }
+    }
+
+    return best == nil || best.allow
+}
+
+// parseRobots returns the rules of the groups addressed to agent, or of the
+// "*" group when no group names it.
+func parseRobots(r io.Reader, agent string) []robotsRule {
+    var specific, wildcard []robotsRule
+    var groupAgents []string
+    inRules := false
+    named := false
+
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if i := strings.Index(line, "#"); i >= 0 {
+            line = line[:i]
+        }
+        key, value, ok := strings.Cut(line, ":")
+        if !ok {
+            continue
+        }
+        key = strings.ToLower(strings.TrimSpace(key))
+        value = strings.TrimSpace(value)
+
+        switch key {
+        case "user-agent":
+            if inRules {
+                groupAgents = nil
+                inRules = false
+            }
+            groupAgent := strings.ToLower(value)
+            if groupAgent != "*" && strings.Contains(agent, groupAgent) {
+                named = true
+            }
+            groupAgents = append(groupAgents, groupAgent)
+        case "allow", "disallow":
+            inRules = true
+            if value == "" {
+                continue
+            }
+            rule := robotsRule{
+                allow: key == "allow",
+                length: len(value),
+                pattern: compileRobotsPattern(value),
+            }
+            for _, groupAgent := range groupAgents {
+                if groupAgent == "*" {
+                    wildcard = append(wildcard, rule)
+                } else if strings.Contains(agent, groupAgent) {
+                    specific = append(specific, rule)
+                }
+            }
+        }
+    }
+
+    if named {
+        return specific
+    }
+    return wildcard
+}
+
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+    anchored := strings.HasSuffix(pattern, "$")
+    pattern = strings.TrimSuffix(pattern, "$")
+
+    expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+    if anchored {
+        expr += "$"
+    }
+    return regexp.MustCompile(expr)
+}
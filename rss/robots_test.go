@@ -0,0 +1,90 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testFeed = `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title></channel></rss>`
+
+func serveTestFeed(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/rss+xml")
+    w.Write([]byte(testFeed))
+}
+
+func newRobotsFetcher(t *testing.T) *Fetcher {
+    t.Helper()
+    fetcher, err := NewFetcher(Options{RespectRobots: true, HostInterval: time.Millisecond})
+    if err != nil {
+        t.Fatalf("NewFetcher failed: %v", err)
+    }
+    return fetcher
+}
+
+// A permanent redirect on robots.txt alone must not read as the feed moving.
+func TestFetchRobotsRedirectIsNotAMove(t *testing.T) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+        http.Redirect(w, r, "/new-robots.txt", http.StatusMovedPermanently)
+    })
+    mux.HandleFunc("/new-robots.txt", func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("User-agent: *\nDisallow: /private/\n"))
+    })
+    mux.HandleFunc("/feed.xml", serveTestFeed)
+    srv := httptest.NewServer(mux)
+    defer srv.Close()
+
+    result, err := newRobotsFetcher(t).Fetch(context.Background(), srv.URL + "/feed.xml")
+    if err != nil {
+        t.Fatalf("Fetch failed: %v", err)
+    }
+    if result.MovedTo != "" {
+        t.Errorf("MovedTo = %q, want the feed to stay put", result.MovedTo)
+    }
+}
+
+// A temporary redirect on robots.txt must not hide the feed's own move.
+func TestFetchRobotsRedirectKeepsFeedMove(t *testing.T) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+        http.Redirect(w, r, "/new-robots.txt", http.StatusFound)
+    })
+    mux.HandleFunc("/new-robots.txt", func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("User-agent: *\nDisallow:\n"))
+    })
+    mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+        http.Redirect(w, r, "/moved.xml", http.StatusMovedPermanently)
+    })
+    mux.HandleFunc("/moved.xml", serveTestFeed)
+    srv := httptest.NewServer(mux)
+    defer srv.Close()
+
+    result, err := newRobotsFetcher(t).Fetch(context.Background(), srv.URL + "/feed.xml")
+    if err != nil {
+        t.Fatalf("Fetch failed: %v", err)
+    }
+    if want := srv.URL + "/moved.xml"; result.MovedTo != want {
+        t.Errorf("MovedTo = %q, want %q", result.MovedTo, want)
+    }
+}
+
+func TestFetchDisallowedByRedirectedRobots(t *testing.T) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+        http.Redirect(w, r, "/new-robots.txt", http.StatusMovedPermanently)
+    })
+    mux.HandleFunc("/new-robots.txt", func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("User-agent: *\nDisallow: /private/\n"))
+    })
+    mux.HandleFunc("/private/feed.xml", serveTestFeed)
+    srv := httptest.NewServer(mux)
+    defer srv.Close()
+
+    _, err := newRobotsFetcher(t).Fetch(context.Background(), srv.URL + "/private/feed.xml")
+    if err == nil {
+        t.Fatal("Fetch succeeded for a path robots.txt disallows")
+    }
+}
@@ -0,0 +1,85 @@
+package rss
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subscription is a WebSub subscription request. The hub pushes the topic's
+// new content to Callback, signed with Secret, until Lease runs out.
+type Subscription struct {
+    Topic    string
+    Callback string
+    Secret   string
+    Lease    time.Duration
+}
+
+// Subscribe asks hub to start, or renew, sub. A hub accepting the request
+// only means it will verify it by calling the callback.
+func (f *Fetcher) Subscribe(ctx context.Context, hub string, sub Subscription) error {
+    form := url.Values{
+        "hub.mode": {"subscribe"},
+        "hub.topic": {sub.Topic},
+        "hub.callback": {sub.Callback},
+        "hub.secret": {sub.Secret},
+    }
+    if sub.Lease > 0 {
+        form.Set("hub.lease_seconds", strconv.Itoa(int(sub.Lease.Seconds())))
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "POST", hub, strings.NewReader(form.Encode()))
+    if err != nil {
+        return fmt.Errorf("Failed to create request: %w", err)
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("User-Agent", f.userAgent)
+
+    resp, err := f.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("Failed to execute request: %w", err)
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 1 << 16))
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+    }
+    return nil
+}
+
+var signatureHashes = map[string]func() hash.Hash{
+    "sha1": sha1.New,
+    "sha256": sha256.New,
+    "sha384": sha512.New384,
+    "sha512": sha512.New,
+}
+
+// CheckSignature reports whether header, an X-Hub-Signature value such as
+// "sha256=<hex>", is the HMAC of body keyed with secret.
+func CheckSignature(secret string, body []byte, header string) bool {
+    method, signature, ok := strings.Cut(header, "=")
+    newHash, known := signatureHashes[method]
+    if !ok || !known {
+        return false
+    }
+    want, err := hex.DecodeString(signature)
+    if err != nil {
+        return false
+    }
+
+    mac := hmac.New(newHash, []byte(secret))
+    mac.Write(body)
+    return hmac.Equal(mac.Sum(nil), want)
+}
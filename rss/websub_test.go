@@ -0,0 +1,197 @@
+package rss
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFetcher(t *testing.T) *Fetcher {
+    t.Helper()
+    fetcher, err := NewFetcher(Options{UserAgent: "gator-test"})
+    if err != nil {
+        t.Fatalf("NewFetcher failed: %v", err)
+    }
+    return fetcher
+}
+
+func sign(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestSubscribe runs a subscription against a stand-in hub: the request, the
+// hub verifying intent with a challenge, and a signed push.
+func TestSubscribe(t *testing.T) {
+    const challenge = "c4a11e9e"
+    body := []byte("<rss><channel><title>Pushed</title></channel></rss>")
+
+    // The subscriber's callback echoes challenges and checks pushed content
+    // the way serve's does.
+    pushed := make(chan bool, 1)
+    callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodGet {
+            io.WriteString(w, r.URL.Query().Get("hub.challenge"))
+            return
+        }
+        got, _ := io.ReadAll(r.Body)
+        pushed <- CheckSignature("s3cret", got, r.Header.Get("X-Hub-Signature"))
+        w.WriteHeader(http.StatusAccepted)
+    }))
+    defer callback.Close()
+
+    hubDone := make(chan error, 1)
+    hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if ct := r.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+            t.Errorf("Content-Type = %q", ct)
+        }
+        if ua := r.Header.Get("User-Agent"); ua != "gator-test" {
+            t.Errorf("User-Agent = %q", ua)
+        }
+        if err := r.ParseForm(); err != nil {
+            t.Errorf("Failed to parse form: %v", err)
+        }
+        want := map[string]string{
+            "hub.mode": "subscribe",
+            "hub.topic": "https://example.com/feed.xml",
+            "hub.callback": callback.URL + "/websub/1",
+            "hub.secret": "s3cret",
+            "hub.lease_seconds": "3600",
+        }
+        for key, value := range(want) {
+            if got := r.PostForm.Get(key); got != value {
+                t.Errorf("%s = %q, want %q", key, got, value)
+            }
+        }
+        w.WriteHeader(http.StatusAccepted)
+
+        // Verification of intent and the first push happen after the hub
+        // answered, as with real hubs.
+        go func() {
+            hubDone <- verifyAndPush(r.PostForm, challenge, body)
+        }()
+    }))
+    defer hub.Close()
+
+    err := newTestFetcher(t).Subscribe(context.Background(), hub.URL, Subscription{
+        Topic: "https://example.com/feed.xml",
+        Callback: callback.URL + "/websub/1",
+        Secret: "s3cret",
+        Lease: time.Hour,
+    })
+    if err != nil {
+        t.Fatalf("Subscribe failed: %v", err)
+    }
+
+    if err := <-hubDone; err != nil {
+        t.Fatal(err)
+    }
+    if !<-pushed {
+        t.Error("Signature of pushed content did not check out")
+    }
+}
+
+// verifyAndPush does what a hub does after accepting form: it checks the
+// callback echoes its challenge, then pushes body signed with the secret.
+func verifyAndPush(form url.Values, challenge string, body []byte) error {
+    verify, err := url.Parse(form.Get("hub.callback"))
+    if err != nil {
+        return err
+    }
+    verify.RawQuery = url.Values{
+        "hub.mode": {"subscribe"},
+        "hub.topic": {form.Get("hub.topic")},
+        "hub.challenge": {challenge},
+        "hub.lease_seconds": {form.Get("hub.lease_seconds")},
+    }.Encode()
+
+    resp, err := http.Get(verify.String())
+    if err != nil {
+        return err
+    }
+    echoed, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || string(echoed) != challenge {
+        return errors.New("Callback did not echo the challenge: " + resp.Status + " " + string(echoed))
+    }
+
+    req, err := http.NewRequest("POST", form.Get("hub.callback"), strings.NewReader(string(body)))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/rss+xml")
+    req.Header.Set("X-Hub-Signature", sign(form.Get("hub.secret"), body))
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+func TestSubscribeRefused(t *testing.T) {
+    hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "Topic not allowed", http.StatusForbidden)
+    }))
+    defer hub.Close()
+
+    err := newTestFetcher(t).Subscribe(context.Background(), hub.URL, Subscription{
+        Topic: "https://example.com/feed.xml",
+        Callback: "https://gator.example.com/websub/1",
+        Secret: "s3cret",
+    })
+    var statusErr *StatusError
+    if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusForbidden {
+        t.Fatalf("Subscribe error = %v, want a 403 StatusError", err)
+    }
+}
+
+func TestCheckSignature(t *testing.T) {
+    body := []byte("<feed/>")
+    valid := sign("s3cret", body)
+
+    tests := []struct {
+        name    string
+        secret  string
+        body    []byte
+        header  string
+        want    bool
+    }{
+        {"valid", "s3cret", body, valid, true},
+        {"valid sha1", "s3cret", body, "sha1=" + hmacHex(t, "sha1", "s3cret", body), true},
+        {"wrong secret", "other", body, valid, false},
+        {"tampered body", "s3cret", []byte("<feed>spam</feed>"), valid, false},
+        {"unknown method", "s3cret", body, "md5=" + strings.TrimPrefix(valid, "sha256="), false},
+        {"not hex", "s3cret", body, "sha256=zz", false},
+        {"no method", "s3cret", body, strings.TrimPrefix(valid, "sha256="), false},
+        {"missing", "s3cret", body, "", false},
+    }
+    for _, tt := range(tests) {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := CheckSignature(tt.secret, tt.body, tt.header); got != tt.want {
+                t.Errorf("CheckSignature = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func hmacHex(t *testing.T, method, secret string, body []byte) string {
+    t.Helper()
+    newHash, ok := signatureHashes[method]
+    if !ok {
+        t.Fatalf("No hash for %s", method)
+    }
+    mac := hmac.New(newHash, []byte(secret))
+    mac.Write(body)
+    return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+const (
+    ruleActionHide  = "hide"
+    ruleActionRead  = "read"
+    ruleActionStar  = "star"
+    ruleActionTag   = "tag"
+)
+
+var (
+    ruleFields      = []string{"any", "title", "description", "author", "category", "feed"}
+    ruleMatchTypes  = []string{"substring", "regex", "keywords"}
+    ruleActions     = []string{ruleActionHide, ruleActionRead, ruleActionStar, ruleActionTag}
+)
+
+// ruleTestLimit is how many of the latest posts rule test looks at.
+const ruleTestLimit = 500
+
+// rulePost holds the parts of a post a rule can match on.
+type rulePost struct {
+    title       string
+    description string
+    author      string
+    categories  []string
+    feedName    string
+    feedURL     string
+}
+
+func newRulePost(post database.Post, feedName, feedURL string) rulePost {
+    return rulePost{
+        title: post.Title,
+        description: post.Description.String,
+        author: post.Author.String,
+        categories: post.Categories,
+        feedName: feedName,
+        feedURL: feedURL,
+    }
+}
+
+// compiledRule is a stored rule with its pattern turned into a matcher.
+type compiledRule struct {
+    database.Rule
+    matcher func(string) bool
+}
+
+func compileRule(rule database.Rule) (compiledRule, error) {
+    compiled := compiledRule{Rule: rule}
+
+    switch rule.MatchType {
+    case "substring":
+        needle := strings.ToLower(rule.Pattern)
+        compiled.matcher = func(s string) bool {
+            return strings.Contains(strings.ToLower(s), needle)
+        }
+    case "regex":
+        re, err := regexp.Compile(rule.Pattern)
+        if err != nil {
+            return compiledRule{}, fmt.Errorf("Invalid regex %q: %w", rule.Pattern, err)
+        }
+        compiled.matcher = re.MatchString
+    case "keywords":
+        // A comma separated list, matched case-insensitively as whole words.
+        var words []string
+        for _, word := range(strings.Split(rule.Pattern, ",")) {
+            word = strings.TrimSpace(word)
+            if word != "" {
+                words = append(words, regexp.QuoteMeta(word))
+            }
+        }
+        if len(words) == 0 {
+            return compiledRule{}, errors.New("A keywords rule needs at least one keyword")
+        }
+        re := regexp.MustCompile(`(?i)\b(?:` + strings.Join(words, "|") + `)\b`)
+        compiled.matcher = re.MatchString
+    default:
+        return compiledRule{}, fmt.Errorf("Unknown match type: %s", rule.MatchType)
+    }
+
+    return compiled, nil
+}
+
+func (r compiledRule) matches(post rulePost) bool {
+    switch r.Field {
+    case "title":
+        return r.matcher(post.title)
+    case "description":
+        return r.matcher(post.description)
+    case "author":
+        return r.matcher(post.author)
+    case "category":
+        return slices.ContainsFunc(post.categories, r.matcher)
+    case "feed":
+        return r.matcher(post.feedName) || r.matcher(post.feedURL)
+    default:
+        return r.matcher(post.title) || r.matcher(post.description) || r.matcher(post.author) ||
+            slices.ContainsFunc(post.categories, r.matcher) || r.matcher(post.feedName) || r.matcher(post.feedURL)
+    }
+}
+
+func (r compiledRule) String() string {
+    action := r.Action
+    if r.Action == ruleActionTag {
+        action += ":" + r.Tag.String
+    }
+    return fmt.Sprintf("%s when %s %s %q", action, r.Field, r.MatchType, r.Pattern)
+}
+
+// compileRules compiles the rules that still compile, logging the others so
+// one bad pattern never stops ingestion.
+func compileRules(rules []database.Rule) []compiledRule {
+    var compiled []compiledRule
+    for _, rule := range(rules) {
+        c, err := compileRule(rule)
+        if err != nil {
+            slog.Warn("Skipping invalid rule", "rule_id", rule.ID, "error", err)
+            continue
+        }
+        compiled = append(compiled, c)
+    }
+    return compiled
+}
+
+// getHideRules returns a user's hide rules, which unlike the other actions
+// also apply to posts stored before the rule was added.
+func getHideRules(s *state, userID uuid.UUID) ([]compiledRule, error) {
+    rules, err := s.db.GetRulesForUser(context.Background(), userID)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to fetch rules: %w", err)
+    }
+
+    var hideRules []compiledRule
+    for _, rule := range(compileRules(rules)) {
+        if rule.Action == ruleActionHide {
+            hideRules = append(hideRules, rule)
+        }
+    }
+    return hideRules, nil
+}
+
+func hiddenByRules(hideRules []compiledRule, post rulePost) bool {
+    return slices.ContainsFunc(hideRules, func(r compiledRule) bool { return r.matches(post) })
+}
+
+// visiblePosts pages through a user's posts until it has params.PageLimit of
+// them left after their hide rules. The query already leaves out posts hidden
+// by state, but hide rules added since a post was stored only apply here, so
+// it keeps reading pages rather than return a short one.
+func visiblePosts(ctx context.Context, s *state, params database.GetPostsForUserPageParams, feeds map[uuid.UUID]database.GetFeedFollowsWithFoldersRow) ([]database.Post, error) {
+    hideRules, err := getHideRules(s, params.UserID)
+    if err != nil {
+        return nil, err
+    }
+
+    limit := int(params.PageLimit)
+    var visible []database.Post
+    for {
+        page, err := s.db.GetPostsForUserPage(ctx, params)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to fetch posts: %w", err)
+        }
+        for _, post := range(page) {
+            feed := feeds[post.FeedID]
+            if !hiddenByRules(hideRules, newRulePost(post, feed.FeedName, feed.FeedUrl)) {
+                visible = append(visible, post)
+            }
+        }
+        if len(visible) >= limit || len(page) < limit {
+            break
+        }
+        params.PageOffset += params.PageLimit
+    }
+
+    return visible[:min(len(visible), limit)], nil
+}
+
+// applyRules runs the rules of every follower of feed against a newly stored
+// post and records the resulting actions.
+func applyRules(ctx context.Context, s *state, rules []compiledRule, feed database.Feed, post database.Post) error {
+    target := newRulePost(post, feed.Name, feed.Url)
+    now := sql.NullTime{Time: time.Now(), Valid: true}
+
+    var errs []error
+    for _, rule := range(rules) {
+        if !rule.matches(target) {
+            continue
+        }
+
+        var err error
+        switch rule.Action {
+        case ruleActionTag:
+            err = s.db.AddPostTag(ctx, database.AddPostTagParams{
+                UserID: rule.UserID,
+                PostID: post.ID,
+                Tag: rule.Tag.String,
+                CreatedAt: now.Time,
+            })
+        default:
+            params := database.UpsertPostStateParams{
+                UserID: rule.UserID,
+                PostID: post.ID,
+            }
+            switch rule.Action {
+            case ruleActionHide:
+                params.HiddenAt = now
+            case ruleActionRead:
+                params.ReadAt = now
+            case ruleActionStar:
+                params.StarredAt = now
+            }
+            err = s.db.UpsertPostState(ctx, params)
+        }
+        if err != nil {
+            errs = append(errs, fmt.Errorf("rule %s: %w", rule.ID, err))
+        }
+    }
+
+    return errors.Join(errs...)
+}
+
+func handlerRule(s *state, cmd command, user database.User) error {
+    if len(cmd.args) < 1 {
+        return errors.New("The rule command expects a subcommand: add, list, rm or test")
+    }
+
+    switch cmd.args[0] {
+    case "add":
+        return ruleAdd(s, cmd.args[1:], user)
+    case "list":
+        return ruleList(s, cmd.args[1:], user)
+    case "rm":
+        return ruleRemove(s, cmd.args[1:], user)
+    case "test":
+        return ruleTest(s, cmd.args[1:], user)
+    default:
+        return fmt.Errorf("Unknown rule subcommand: %s", cmd.args[0])
+    }
+}
+
+// parseRule builds a rule from "<action> <field> <match> <pattern>", where
+// action is hide, read, star or tag:<name>.
+func parseRule(args []string, user database.User) (compiledRule, error) {
+    if len(args) != 4 {
+        return compiledRule{}, errors.New("A rule is given as <action> <field> <match> <pattern>")
+    }
+
+    rule := database.Rule{
+        ID: uuid.New(),
+        CreatedAt: time.Now(),
+        UserID: user.ID,
+        Field: args[1],
+        MatchType: args[2],
+        Pattern: args[3],
+    }
+
+    action, tag, hasTag := strings.Cut(args[0], ":")
+    rule.Action = action
+    if !slices.Contains(ruleActions, action) {
+        return compiledRule{}, fmt.Errorf("Unknown action %s, expected one of %s", action, strings.Join(ruleActions, ", "))
+    }
+    if action == ruleActionTag {
+        if !hasTag || tag == "" {
+            return compiledRule{}, errors.New("The tag action is given as tag:<name>")
+        }
+        rule.Tag = sql.NullString{String: tag, Valid: true}
+    } else if hasTag {
+        return compiledRule{}, fmt.Errorf("The %s action takes no value", action)
+    }
+
+    if !slices.Contains(ruleFields, rule.Field) {
+        return compiledRule{}, fmt.Errorf("Unknown field %s, expected one of %s", rule.Field, strings.Join(ruleFields, ", "))
+    }
+    if !slices.Contains(ruleMatchTypes, rule.MatchType) {
+        return compiledRule{}, fmt.Errorf("Unknown match type %s, expected one of %s", rule.MatchType, strings.Join(ruleMatchTypes, ", "))
+    }
+
+    return compileRule(rule)
+}
+
+func ruleAdd(s *state, args []string, user database.User) error {
+    rule, err := parseRule(args, user)
+    if err != nil {
+        return err
+    }
+
+    _, err = s.db.CreateRule(context.Background(), database.CreateRuleParams{
+        ID: rule.ID,
+        CreatedAt: rule.CreatedAt,
+        UserID: rule.UserID,
+        Field: rule.Field,
+        MatchType: rule.MatchType,
+        Pattern: rule.Pattern,
+        Action: rule.Action,
+        Tag: rule.Tag,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to store rule: %w", err)
+    }
+
+    fmt.Printf("Rule %s added: %s\n", rule.ID, rule)
+    return nil
+}
+
+func ruleList(s *state, args []string, user database.User) error {
+    if len(args) != 0 {
+        return errors.New("The rule list command expects ZERO arguments")
+    }
+
+    rules, err := s.db.GetRulesForUser(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to fetch rules: %w", err)
+    }
+
+    for _, rule := range(rules) {
+        fmt.Printf("%s  %s\n", rule.ID, compiledRule{Rule: rule})
+    }
+
+    return nil
+}
+
+func ruleRemove(s *state, args []string, user database.User) error {
+    if len(args) != 1 {
+        return errors.New("The rule rm command expects ONE argument")
+    }
+
+    id, err := uuid.Parse(args[0])
+    if err != nil {
+        return fmt.Errorf("Invalid rule ID: %w", err)
+    }
+
+    removed, err := s.db.DeleteRule(context.Background(), database.DeleteRuleParams{
+        ID: id,
+        UserID: user.ID,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to remove rule: %w", err)
+    }
+    if removed == 0 {
+        return fmt.Errorf("No rule with ID %s", id)
+    }
+
+    fmt.Printf("Rule %s removed\n", id)
+    return nil
+}
+
+// ruleTest dry-runs a rule, either a stored one by ID or one given like for
+// rule add, against the user's latest posts.
+func ruleTest(s *state, args []string, user database.User) error {
+    var rule compiledRule
+    if len(args) == 1 {
+        id, err := uuid.Parse(args[0])
+        if err != nil {
+            return fmt.Errorf("Invalid rule ID: %w", err)
+        }
+        rules, err := s.db.GetRulesForUser(context.Background(), user.ID)
+        if err != nil {
+            return fmt.Errorf("Failed to fetch rules: %w", err)
+        }
+        i := slices.IndexFunc(rules, func(r database.Rule) bool { return r.ID == id })
+        if i < 0 {
+            return fmt.Errorf("No rule with ID %s", id)
+        }
+        rule, err = compileRule(rules[i])
+        if err != nil {
+            return err
+        }
+    } else {
+        var err error
+        rule, err = parseRule(args, user)
+        if err != nil {
+            return err
+        }
+    }
+
+    feeds, err := followedFeeds(s, user)
+    if err != nil {
+        return err
+    }
+
+    posts, err := s.db.GetPostsForUser(context.Background(), database.GetPostsForUserParams{
+        UserID: user.ID,
+        Limit: ruleTestLimit,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to fetch posts: %w", err)
+    }
+
+    matched := 0
+    for _, post := range(posts) {
+        feed := feeds[post.FeedID]
+        if !rule.matches(newRulePost(post, feed.FeedName, feed.FeedUrl)) {
+            continue
+        }
+        matched++
+        fmt.Printf("- %s (%s)\n", post.Title, feed.FeedName)
+    }
+
+    fmt.Printf("%s would apply to %d of the latest %d posts\n", rule, matched, len(posts))
+    return nil
+}
+
+// dropHiddenRiver drops the river rows matching a hide rule. The river query
+// already leaves out posts hidden by state, and its callers page by the rows
+// it returned, so nothing is skipped over.
+func dropHiddenRiver(s *state, user database.User, rows []database.GetRiverPostsRow) ([]database.GetRiverPostsRow, error) {
+    hideRules, err := getHideRules(s, user.ID)
+    if err != nil {
+        return nil, err
+    }
+    if len(hideRules) == 0 {
+        return rows, nil
+    }
+    feeds, err := followedFeeds(s, user)
+    if err != nil {
+        return nil, err
+    }
+
+    visible := make([]database.GetRiverPostsRow, 0, len(rows))
+    for _, row := range(rows) {
+        feed := feeds[row.FeedID]
+        post := database.Post{
+            ID: row.ID,
+            Title: row.Title,
+            Url: row.Url,
+            Description: row.Description,
+            FeedID: row.FeedID,
+            Author: row.Author,
+            Categories: row.Categories,
+        }
+        if hiddenByRules(hideRules, newRulePost(post, feed.FeedName, feed.FeedUrl)) {
+            continue
+        }
+        visible = append(visible, row)
+    }
+    return visible, nil
+}
+
+// followedFeeds indexes the feeds a user follows by feed ID.
+func followedFeeds(s *state, user database.User) (map[uuid.UUID]database.GetFeedFollowsWithFoldersRow, error) {
+    follows, err := s.db.GetFeedFollowsWithFolders(context.Background(), user.ID)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to fetch followed feeds: %w", err)
+    }
+
+    feeds := make(map[uuid.UUID]database.GetFeedFollowsWithFoldersRow, len(follows))
+    for _, follow := range(follows) {
+        feeds[follow.FeedID] = follow
+    }
+    return feeds, nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/zulkou/blog-aggregator/internal/config"
+	"github.com/zulkou/blog-aggregator/internal/database"
+	"github.com/zulkou/blog-aggregator/rss"
+)
+
+// runScraper fetches one feed per interval for as long as the process runs.
+func runScraper(s *state, interval time.Duration) {
+    slog.Info("Collecting feeds", "interval", interval)
+    go runWebhookDeliveries(s)
+
+    var lastPrune, lastDigests time.Time
+    ticker := time.NewTicker(interval)
+    for ; ; <-ticker.C {
+        if time.Since(lastPrune) > 24 * time.Hour {
+            err := pruneFeedFetches(s)
+            if err != nil {
+                slog.Error("Failed to prune fetch history", "error", err)
+            }
+            lastPrune = time.Now()
+        }
+        // Digests need a mail server, so without one they are never due.
+        if s.cfg.SMTP.Host != "" && time.Since(lastDigests) > digestCheckInterval {
+            err := sendDueDigests(s)
+            if err != nil {
+                slog.Error("Failed to send digests", "error", err)
+            }
+            lastDigests = time.Now()
+        }
+        scrapeFeeds(s)
+
+        err := updateQueueMetrics(s, interval)
+        if err != nil {
+            slog.Error("Failed to update queue metrics", "error", err)
+        }
+    }
+}
+
+func scrapeFeeds(s *state) error {
+    now := time.Now()
+    feed, err := s.db.GetNextFeedToFetch(context.Background(), database.GetNextFeedToFetchParams{
+        LeaseExpiresAt: sql.NullTime{Time: now, Valid: true},
+        LastFetchedAt: sql.NullTime{Time: now.Add(-websubPollInterval), Valid: true},
+    })
+    if errors.Is(err, sql.ErrNoRows) {
+        // Every feed is dead or pushed to by a hub.
+        slog.Debug("No feed to fetch")
+        return nil
+    } else if err != nil {
+        dbErrors.WithLabelValues("GetNextFeedToFetch").Inc()
+        slog.Error("Failed to read next feed", "error", err)
+        return fmt.Errorf("Failed to fetch next feed: %w", err)
+    }
+
+    err = s.db.MarkFeedFetched(context.Background(), database.MarkFeedFetchedParams{
+        ID: feed.ID,
+        LastFetchedAt: sql.NullTime{Time: time.Now(), Valid: true},
+        UpdatedAt: time.Now(),
+    })
+    if err != nil {
+        dbErrors.WithLabelValues("MarkFeedFetched").Inc()
+        slog.Error("Failed to mark feed fetched", "feed_id", feed.ID, "url", feed.Url, "error", err)
+        return fmt.Errorf("Failed to mark fetched feed: %w", err)
+    }
+
+    logger := slog.With("feed_id", feed.ID, "url", feed.Url)
+
+    record := database.CreateFeedFetchParams{
+        ID: uuid.New(),
+        FeedID: feed.ID,
+        StartedAt: time.Now(),
+    }
+
+    err = scrapeFeed(s, logger, feed, &record)
+
+    record.FinishedAt = time.Now()
+    observeFetch(record)
+
+    attrs := []any{
+        "status", record.StatusCode.Int32,
+        "duration", record.FinishedAt.Sub(record.StartedAt),
+        "bytes", record.Bytes,
+        "items", record.ItemCount,
+        "new_posts", record.NewPosts,
+        "updated_posts", record.UpdatedPosts,
+    }
+    if err != nil {
+        record.Error = sql.NullString{String: err.Error(), Valid: true}
+        logger.Error("Feed fetch failed", append(attrs, "error", err)...)
+    } else {
+        logger.Info("Feed fetched", attrs...)
+    }
+
+    logErr := s.db.CreateFeedFetch(context.Background(), record)
+    if logErr != nil {
+        dbErrors.WithLabelValues("CreateFeedFetch").Inc()
+        logger.Error("Failed to record fetch", "error", logErr)
+    }
+
+    return err
+}
+
+// scrapeFeed fetches a single feed and stores its items, filling in record
+// as it goes so the caller can log the attempt whatever the outcome.
+func scrapeFeed(s *state, logger *slog.Logger, feed database.Feed, record *database.CreateFeedFetchParams) error {
+    result, err := s.fetcher.Fetch(context.Background(), feed.Url)
+    if err != nil {
+        var statusErr *rss.StatusError
+        if errors.As(err, &statusErr) {
+            record.StatusCode = sql.NullInt32{Int32: int32(statusErr.StatusCode), Valid: true}
+        }
+        if rss.IsGone(err) {
+            markErr := s.db.MarkFeedDead(context.Background(), database.MarkFeedDeadParams{
+                ID: feed.ID,
+                DeadAt: sql.NullTime{Time: time.Now(), Valid: true},
+            })
+            if markErr != nil {
+                dbErrors.WithLabelValues("MarkFeedDead").Inc()
+                logger.Error("Failed to mark feed dead", "error", markErr)
+            } else {
+                logger.Warn("Feed is gone, it will no longer be fetched")
+            }
+        }
+        return fmt.Errorf("Failed to fetch feed content: %w", err)
+    }
+
+    record.StatusCode = sql.NullInt32{Int32: int32(result.StatusCode), Valid: true}
+    record.Bytes = result.Bytes
+    record.ItemCount = int32(len(result.Feed.Channel.Item))
+
+    if result.MovedTo != "" && result.MovedTo != feed.Url {
+        moved, err := moveFeed(s, logger, feed, result.MovedTo)
+        if err != nil {
+            logger.Error("Failed to move feed", "new_url", result.MovedTo, "error", err)
+        } else {
+            // After a merge the feed already at the new URL stores the
+            // items, and the attempt is recorded against it.
+            feed = moved
+            record.FeedID = feed.ID
+        }
+    }
+
+    recordWebSubHub(s, logger, feed, result.Feed)
+    storeItems(s, logger, feed, result.Feed.Channel.Item, record)
+    return nil
+}
+
+// moveFeed follows a permanent redirect to newURL, keeping the old URL as an
+// alias and the feed's fetch options, and returns the feed as it now is.
+// When another feed already has newURL the two are the same feed, so this
+// one is merged into it and moveFeed returns that other feed.
+func moveFeed(s *state, logger *slog.Logger, feed database.Feed, newURL string) (database.Feed, error) {
+    err := s.db.MoveFeedURL(context.Background(), database.MoveFeedURLParams{
+        ID: feed.ID,
+        Url: newURL,
+        UpdatedAt: time.Now(),
+    })
+    var pqErr *pq.Error
+    if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+        into, err := s.db.GetFeedByURL(context.Background(), newURL)
+        if err != nil {
+            return feed, err
+        }
+        err = s.db.MergeFeeds(context.Background(), database.MergeFeedsParams{
+            IntoID: into.ID,
+            UpdatedAt: time.Now(),
+            FromID: feed.ID,
+        })
+        if err != nil {
+            dbErrors.WithLabelValues("MergeFeeds").Inc()
+            return feed, err
+        }
+        s.fetcher.MoveFeed(feed.Url, newURL)
+        logger.Info("Feed permanently moved to another feed, merged into it", "new_url", newURL, "into_feed_id", into.ID)
+        return into, nil
+    } else if err != nil {
+        dbErrors.WithLabelValues("MoveFeedURL").Inc()
+        return feed, err
+    }
+
+    s.fetcher.MoveFeed(feed.Url, newURL)
+    logger.Info("Feed permanently moved", "new_url", newURL)
+    feed.Url = newURL
+    return feed, nil
+}
+
+// storeItems stores a feed's items and runs the new ones through rules,
+// watches, webhooks and event streams, counting them in record. Fetched and
+// pushed items both go through here.
+func storeItems(s *state, logger *slog.Logger, feed database.Feed, items []rss.RSSItem, record *database.CreateFeedFetchParams) {
+    rules, err := s.db.GetRulesForFeed(context.Background(), feed.ID)
+    if err != nil {
+        dbErrors.WithLabelValues("GetRulesForFeed").Inc()
+        logger.Error("Failed to load follower rules", "error", err)
+    }
+    followerRules := compileRules(rules)
+
+    watches, err := s.db.GetWatchesForFeed(context.Background(), feed.ID)
+    if err != nil {
+        dbErrors.WithLabelValues("GetWatchesForFeed").Inc()
+        logger.Error("Failed to load follower watches", "error", err)
+    }
+    followerWatches := compileWatches(watches)
+
+    for _, rssitem := range(items) {
+        pubDate, err := time.Parse(time.RFC1123Z, rssitem.PubDate)
+        if err != nil {
+            pubDate, err = time.Parse(time.RFC1123, rssitem.PubDate)
+            if err != nil {
+                pubDate, err = time.Parse(time.RFC822, rssitem.PubDate)
+                if err != nil {
+                    pubDate, err = time.Parse("2006-01-02T15:04:05Z", rssitem.PubDate)
+                    if err != nil {
+                        logger.Warn("Could not parse date", "pub_date", rssitem.PubDate, "error", err)
+                        pubDate = time.Now()
+                    }
+                }
+            }
+        }
+
+        var description sql.NullString
+        if rssitem.Description != "" {
+            description = sql.NullString{
+                String: rssitem.Description,
+                Valid: true,
+            }
+        } else {
+            description = sql.NullString{
+                Valid: false,
+            }
+        }
+
+        var author sql.NullString
+        if name := rssitem.AuthorName(); name != "" {
+            author = sql.NullString{String: name, Valid: true}
+        }
+        // A nil slice would be stored as NULL rather than an empty array.
+        categories := rssitem.Categories
+        if categories == nil {
+            categories = []string{}
+        }
+
+        post, err := s.db.CreatePost(context.Background(), database.CreatePostParams{
+            ID: uuid.New(),
+            CreatedAt: time.Now(),
+            UpdatedAt: time.Now(),
+            Title: rssitem.Title,
+            Url: rssitem.Link,
+            Description: description,
+            PublishedAt: pubDate,
+            FeedID: feed.ID,
+            Author: author,
+            Categories: categories,
+        })
+        if err != nil {
+            // No row comes back when the post is already stored unchanged.
+            if errors.Is(err, sql.ErrNoRows) {
+                postsTotal.WithLabelValues("duplicate").Inc()
+                continue
+            }
+            dbErrors.WithLabelValues("CreatePost").Inc()
+            logger.Error("Failed to store post", "title", rssitem.Title, "post_url", rssitem.Link, "error", err)
+            continue
+        }
+
+        if post.Inserted {
+            record.NewPosts++
+            postsTotal.WithLabelValues("inserted").Inc()
+
+            stored := database.Post{
+                ID: post.ID,
+                CreatedAt: post.CreatedAt,
+                UpdatedAt: post.UpdatedAt,
+                Title: post.Title,
+                Url: post.Url,
+                Description: post.Description,
+                PublishedAt: post.PublishedAt,
+                FeedID: post.FeedID,
+                Author: post.Author,
+                Categories: post.Categories,
+                ItemID: post.ItemID,
+            }
+
+            err = clusterPost(context.Background(), s, stored)
+            if err != nil {
+                dbErrors.WithLabelValues("CreatePostFingerprint").Inc()
+                logger.Error("Failed to cluster post", "post_url", post.Url, "error", err)
+            }
+            err = applyRules(context.Background(), s, followerRules, feed, stored)
+            if err != nil {
+                logger.Error("Failed to apply rules", "post_url", post.Url, "error", err)
+            }
+            checkWatches(context.Background(), s, logger, followerWatches, feed, stored)
+            err = enqueueWebhooks(context.Background(), s, stored)
+            if err != nil {
+                logger.Error("Failed to queue webhooks", "post_url", post.Url, "error", err)
+            }
+            // Announced last, so listeners see the post with rules applied.
+            err = s.db.NotifyNewPost(context.Background(), post.ItemID)
+            if err != nil {
+                dbErrors.WithLabelValues("NotifyNewPost").Inc()
+                logger.Error("Failed to announce post", "post_url", post.Url, "error", err)
+            }
+        } else {
+            record.UpdatedPosts++
+            postsTotal.WithLabelValues("updated").Inc()
+        }
+    }
+}
+
+// pruneFeedFetches drops fetch history older than the configured retention.
+func pruneFeedFetches(s *state) error {
+    days := s.cfg.FetchLogDays
+    if days <= 0 {
+        days = config.DefaultFetchLogDays
+    }
+
+    _, err := s.db.DeleteFeedFetchesBefore(context.Background(), time.Now().AddDate(0, 0, -days))
+    if err != nil {
+        dbErrors.WithLabelValues("DeleteFeedFetchesBefore").Inc()
+        return fmt.Errorf("Failed to prune fetch history: %w", err)
+    }
+
+    return nil
+}
+
+func observeFetch(record database.CreateFeedFetchParams) {
+    status := "error"
+    if record.StatusCode.Valid {
+        status = strconv.Itoa(int(record.StatusCode.Int32))
+    }
+
+    fetchesTotal.WithLabelValues(status).Inc()
+    fetchDuration.Observe(record.FinishedAt.Sub(record.StartedAt).Seconds())
+    fetchBytes.Add(float64(record.Bytes))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+func handlerServe(s *state, cmd command) error {
+    if len(cmd.args) < 1 || len(cmd.args) > 2 {
+        return errors.New("The serve command expects ONE or TWO arguments")
+    }
+
+    addr := cmd.args[0]
+
+    if len(cmd.args) == 2 {
+        interval, err := time.ParseDuration(cmd.args[1])
+        if err != nil {
+            return fmt.Errorf("Failed to parse scrape interval: %w", err)
+        }
+        go runScraper(s, interval)
+    }
+    go runWebSub(s)
+
+    events, err := listenPostEvents(s.cfg.DBURL)
+    if err != nil {
+        return err
+    }
+
+    srv := &http.Server{
+        Addr: addr,
+        Handler: newServerHandler(s, events),
+        ReadHeaderTimeout: 10 * time.Second,
+    }
+
+    slog.Info("Serving HTTP", "addr", addr)
+    return srv.ListenAndServe()
+}
+
+func newServerHandler(s *state, events *postEvents) http.Handler {
+    mux := http.NewServeMux()
+    registerAPIRoutes(mux, s)
+    registerFeedRoutes(mux, s)
+    registerEventRoutes(mux, s, events)
+    registerWebSubRoutes(mux, s)
+    registerWebRoutes(mux, s)
+    registerReaderRoutes(mux, s)
+    mux.HandleFunc("GET /metrics", apiLoggedIn(s, apiMetrics))
+
+    return logRequests(mux)
+}
+
+// apiMetrics serves the Prometheus metrics to admins. Scrapers send an
+// admin's API key like any other client.
+func apiMetrics(s *state, w http.ResponseWriter, r *http.Request, user database.User) {
+    if !isAdmin(user) {
+        respondWithError(w, http.StatusForbidden, "Only admins with a password can read metrics")
+        return
+    }
+    metricsHandler.ServeHTTP(w, r)
+}
+
+type statusRecorder struct {
+    http.ResponseWriter
+    status  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+    r.status = status
+    r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the connection, so streaming
+// handlers can flush through the recorder.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+    return r.ResponseWriter
+}
+
+// redactPath hides the token in /feeds/{token}/... paths, which works as a
+// password for the user's published feeds.
+func redactPath(path string) string {
+    rest, ok := strings.CutPrefix(path, "/feeds/")
+    if !ok {
+        return path
+    }
+    _, tail, _ := strings.Cut(rest, "/")
+    return "/feeds/<redacted>/" + tail
+}
+
+func logRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+        next.ServeHTTP(rec, r)
+
+        slog.Debug("HTTP request",
+            "method", r.Method,
+            "path", redactPath(r.URL.Path),
+            "status", rec.status,
+            "duration", time.Since(start),
+        )
+    })
+}
+
+func respondWithJSON(w http.ResponseWriter, code int, payload any) {
+    data, err := json.Marshal(payload)
+    if err != nil {
+        slog.Error("Failed to marshal response", "error", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    w.Write(data)
+}
+
+func respondWithError(w http.ResponseWriter, code int, msg string) {
+    respondWithJSON(w, code, map[string]string{"error": msg})
+}
+
+// respondWithDBError maps a failed query onto the closest HTTP status.
+func respondWithDBError(w http.ResponseWriter, err error) {
+    var pqErr *pq.Error
+    switch {
+    case errors.Is(err, sql.ErrNoRows):
+        respondWithError(w, http.StatusNotFound, "Not found")
+    case errors.As(err, &pqErr) && pqErr.Code == "23505":
+        respondWithError(w, http.StatusConflict, "Already exists")
+    default:
+        slog.Error("Database error", "error", err)
+        respondWithError(w, http.StatusInternalServerError, "Internal server error")
+    }
+}
+
+func decodeJSON(r *http.Request, v any) error {
+    decoder := json.NewDecoder(r.Body)
+    decoder.DisallowUnknownFields()
+    return decoder.Decode(v)
+}
+
+// pageParams reads ?limit= and ?offset= from the query string.
+func pageParams(r *http.Request) (int32, int32, error) {
+    limit := int64(defaultPageSize)
+    offset := int64(0)
+
+    if value := r.URL.Query().Get("limit"); value != "" {
+        parsed, err := strconv.ParseInt(value, 10, 32)
+        if err != nil || parsed < 1 || parsed > maxPageSize {
+            return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+        }
+        limit = parsed
+    }
+
+    if value := r.URL.Query().Get("offset"); value != "" {
+        parsed, err := strconv.ParseInt(value, 10, 32)
+        if err != nil || parsed < 0 {
+            return 0, 0, errors.New("offset must be a non-negative integer")
+        }
+        offset = parsed
+    }
+
+    return int32(limit), int32(offset), nil
+}
+
+// apiLoggedIn is the HTTP counterpart of middlewareLoggedIn. Callers must
+// send one of their API keys as "Authorization: Bearer <key>".
+func apiLoggedIn(s *state, handler func(s *state, w http.ResponseWriter, r *http.Request, user database.User)) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if !ok || key == "" {
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            respondWithError(w, http.StatusUnauthorized, "Missing API key")
+            return
+        }
+
+        hash := hashToken(key)
+        user, err := s.db.GetUserByAPIKey(r.Context(), hash)
+        if err != nil {
+            if errors.Is(err, sql.ErrNoRows) {
+                w.Header().Set("WWW-Authenticate", "Bearer")
+                respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+                return
+            }
+            respondWithDBError(w, err)
+            return
+        }
+
+        err = s.db.TouchAPIKey(r.Context(), database.TouchAPIKeyParams{
+            KeyHash: hash,
+            LastUsedAt: sql.NullTime{Time: time.Now(), Valid: true},
+        })
+        if err != nil {
+            slog.Warn("Failed to record API key use", "user", user.Name, "error", err)
+        }
+
+        handler(s, w, r, user)
+    }
+}
@@ -0,0 +1,39 @@
+-- name: UpsertDigest :one
+INSERT INTO digests (user_id, created_at, updated_at, email, frequency)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+ON CONFLICT (user_id) DO UPDATE
+SET email = EXCLUDED.email, frequency = EXCLUDED.frequency, updated_at = EXCLUDED.updated_at
+RETURNING *;
+
+-- name: GetDigest :one
+SELECT * FROM digests
+WHERE user_id = $1;
+
+-- name: GetDueDigests :many
+SELECT digests.*, users.name AS user_name FROM digests
+JOIN users ON users.id = digests.user_id
+WHERE (digests.frequency = 'daily' AND (digests.last_digest_at IS NULL OR digests.last_digest_at <= sqlc.arg(daily_before)))
+   OR (digests.frequency = 'weekly' AND (digests.last_digest_at IS NULL OR digests.last_digest_at <= sqlc.arg(weekly_before)))
+ORDER BY digests.user_id;
+
+-- name: SetLastDigestAt :exec
+UPDATE digests
+SET last_digest_at = $2
+WHERE user_id = $1;
+
+-- name: GetDigestPosts :many
+SELECT p.*, COALESCE(ff.title, f.name)::text AS feed_name, f.url AS feed_url FROM posts p
+JOIN feed_follows ff ON p.feed_id = ff.feed_id
+JOIN feeds f ON f.id = p.feed_id
+LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = ff.user_id
+WHERE ff.user_id = sqlc.arg(user_id)
+  AND p.created_at > sqlc.arg(since) AND p.created_at <= sqlc.arg(until)
+  AND ps.read_at IS NULL AND ps.hidden_at IS NULL
+ORDER BY p.created_at, p.id
+LIMIT sqlc.arg(max_posts);
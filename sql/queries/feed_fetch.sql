@@ -5,7 +5,58 @@ WHERE id = $1
 RETURNING *;
 
 -- name: GetNextFeedToFetch :one
+-- GetNextFeedToFetch skips feeds a WebSub hub pushes to, unless they were
+-- last fetched before $2 so they are still polled now and then.
 SELECT *
 FROM feeds
+WHERE dead_at IS NULL
+  AND NOT EXISTS (
+      SELECT 1 FROM websub_subscriptions ws
+      WHERE ws.feed_id = feeds.id
+        AND ws.state = 'active'
+        AND ws.lease_expires_at > $1
+        AND feeds.last_fetched_at > $2
+  )
 ORDER BY last_fetched_at NULLS FIRST, id
 LIMIT 1;
+
+-- name: MoveFeedURL :exec
+WITH alias AS (
+    INSERT INTO feed_aliases (url, created_at, feed_id)
+    SELECT url, $3, id FROM feeds WHERE id = $1
+    ON CONFLICT (url) DO NOTHING
+)
+UPDATE feeds
+SET url = $2, updated_at = $3
+WHERE id = $1;
+
+-- name: MergeFeeds :exec
+-- MergeFeeds folds a feed into the one whose URL it redirected to.
+-- Followers and webhooks move across unless already there, the old URLs
+-- become aliases and the merged feed is deleted along with its posts.
+WITH follows AS (
+    UPDATE feed_follows
+    SET feed_id = sqlc.arg(into_id), updated_at = sqlc.arg(updated_at)
+    WHERE feed_id = sqlc.arg(from_id)
+      AND user_id NOT IN (SELECT user_id FROM feed_follows WHERE feed_id = sqlc.arg(into_id))
+), hooks AS (
+    UPDATE webhook_feeds
+    SET feed_id = sqlc.arg(into_id)
+    WHERE feed_id = sqlc.arg(from_id)
+      AND webhook_id NOT IN (SELECT webhook_id FROM webhook_feeds WHERE feed_id = sqlc.arg(into_id))
+), aliases AS (
+    UPDATE feed_aliases
+    SET feed_id = sqlc.arg(into_id)
+    WHERE feed_id = sqlc.arg(from_id)
+), alias AS (
+    INSERT INTO feed_aliases (url, created_at, feed_id)
+    SELECT url, sqlc.arg(updated_at), sqlc.arg(into_id) FROM feeds WHERE id = sqlc.arg(from_id)
+    ON CONFLICT (url) DO NOTHING
+)
+DELETE FROM feeds
+WHERE id = sqlc.arg(from_id);
+
+-- name: MarkFeedDead :exec
+UPDATE feeds
+SET dead_at = $2, updated_at = $2
+WHERE id = $1;
@@ -0,0 +1,31 @@
+-- name: CreateFeedFetch :exec
+INSERT INTO feed_fetches (id, feed_id, started_at, finished_at, status_code, bytes, item_count, new_posts, updated_posts, error)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    $10
+);
+
+-- name: GetFeedFetches :many
+SELECT feed_fetches.*, feeds.name AS feed_name
+FROM feed_fetches
+JOIN feeds ON feed_fetches.feed_id = feeds.id
+ORDER BY feed_fetches.started_at DESC
+LIMIT $1;
+
+-- name: GetFeedFetchesForFeed :many
+SELECT * FROM feed_fetches
+WHERE feed_id = $1
+ORDER BY started_at DESC
+LIMIT $2;
+
+-- name: DeleteFeedFetchesBefore :execrows
+DELETE FROM feed_fetches
+WHERE started_at < $1;
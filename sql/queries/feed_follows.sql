@@ -36,6 +36,41 @@ JOIN users ON feed_follows.user_id = users.id
 JOIN feeds ON feed_follows.feed_id = feeds.id
 WHERE feed_follows.user_id = $1;
 
--- name: DeleteFeedFollow :exec
+-- name: DeleteFeedFollow :execrows
 DELETE FROM feed_follows
 WHERE user_id = $1 AND feed_id = $2;
+
+-- name: GetFeedFollow :one
+SELECT * FROM feed_follows
+WHERE user_id = $1 AND feed_id = $2;
+
+-- name: SetFeedFollowTitle :exec
+UPDATE feed_follows
+SET title = $2, updated_at = $3
+WHERE id = $1;
+
+-- name: AddFollowFolder :exec
+INSERT INTO follow_folders (feed_follow_id, folder, created_at)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveFollowFolder :execrows
+DELETE FROM follow_folders
+WHERE feed_follow_id = $1 AND folder = $2;
+
+-- name: GetFeedFollowsWithFolders :many
+SELECT
+    feed_follows.feed_id,
+    feeds.name AS feed_name,
+    feeds.url AS feed_url,
+    feed_follows.title,
+    follow_folders.folder
+FROM feed_follows
+JOIN feeds ON feed_follows.feed_id = feeds.id
+LEFT JOIN follow_folders ON follow_folders.feed_follow_id = feed_follows.id
+WHERE feed_follows.user_id = $1
+ORDER BY follow_folders.folder NULLS LAST, COALESCE(feed_follows.title, feeds.name);
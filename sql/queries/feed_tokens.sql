@@ -0,0 +1,24 @@
+-- name: SetFeedToken :exec
+INSERT INTO feed_tokens (user_id, created_at, token_hash)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (user_id) DO UPDATE
+SET created_at = EXCLUDED.created_at, token_hash = EXCLUDED.token_hash;
+
+-- name: GetUserByFeedToken :one
+SELECT users.* FROM users
+JOIN feed_tokens ON feed_tokens.user_id = users.id
+WHERE feed_tokens.token_hash = $1;
+
+-- name: DeleteFeedToken :execrows
+DELETE FROM feed_tokens
+WHERE user_id = $1;
+
+-- name: GetRiverChangedAt :one
+-- GetRiverChangedAt returns when the user's follows, folders, rules or
+-- hidden posts last changed.
+SELECT changed_at FROM river_changes
+WHERE user_id = $1;
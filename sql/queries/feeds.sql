@@ -15,4 +15,41 @@ SELECT * FROM feeds;
 
 -- name: GetFeedByURL :one
 SELECT * FROM feeds
-WHERE url = $1;
+WHERE url = $1
+   OR id IN (SELECT feed_id FROM feed_aliases WHERE feed_aliases.url = $1)
+ORDER BY url = $1 DESC
+LIMIT 1;
+
+-- name: GetFeedsPage :many
+SELECT * FROM feeds
+ORDER BY created_at, id
+LIMIT $1 OFFSET $2;
+
+-- name: GetFeedByID :one
+SELECT * FROM feeds
+WHERE id = $1;
+
+-- name: GetFeedImpact :one
+SELECT
+    (SELECT COUNT(*) FROM feed_follows WHERE feed_follows.feed_id = $1) AS followers,
+    (SELECT COUNT(*) FROM posts WHERE posts.feed_id = $1) AS posts;
+
+-- name: GetFeedFollowerNames :many
+SELECT users.name FROM feed_follows
+JOIN users ON feed_follows.user_id = users.id
+WHERE feed_follows.feed_id = $1
+ORDER BY users.name;
+
+-- name: RenameFeed :exec
+UPDATE feeds
+SET name = $2, updated_at = $3
+WHERE id = $1;
+
+-- name: SetFeedURL :exec
+UPDATE feeds
+SET url = $2, updated_at = $3, last_fetched_at = NULL, dead_at = NULL
+WHERE id = $1;
+
+-- name: DeleteFeed :exec
+DELETE FROM feeds
+WHERE id = $1;
@@ -0,0 +1,33 @@
+-- name: CreatePostFingerprint :exec
+INSERT INTO post_fingerprints (post_id, published_at, fingerprint, cluster_id, feed_id, bands)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+ON CONFLICT (post_id) DO NOTHING;
+
+-- name: GetClusterCandidates :many
+-- GetClusterCandidates returns the fingerprints of other feeds' posts
+-- published between since and until that share a band with bands.
+SELECT cluster_id, fingerprint FROM post_fingerprints
+WHERE bands && sqlc.arg(bands)::integer[]
+  AND published_at BETWEEN sqlc.arg(since) AND sqlc.arg(until)
+  AND feed_id <> sqlc.arg(feed_id);
+
+-- name: GetUnfingerprintedPosts :many
+-- GetUnfingerprintedPosts pages through the posts without a fingerprint,
+-- oldest first, after the given post.
+SELECT p.* FROM posts p
+LEFT JOIN post_fingerprints pf ON pf.post_id = p.id
+WHERE pf.post_id IS NULL
+  AND (p.published_at, p.id) > (sqlc.arg(after_published_at)::timestamp, sqlc.arg(after_id)::uuid)
+ORDER BY p.published_at, p.id
+LIMIT sqlc.arg(page_limit);
+
+-- name: GetPostClusters :many
+SELECT post_id, cluster_id FROM post_fingerprints
+WHERE post_id = ANY(sqlc.arg(post_ids)::uuid[]);
@@ -0,0 +1,68 @@
+-- name: UpsertPostState :exec
+INSERT INTO post_states (user_id, post_id, read_at, starred_at, hidden_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+ON CONFLICT (user_id, post_id) DO UPDATE
+SET read_at = COALESCE(post_states.read_at, EXCLUDED.read_at),
+    starred_at = COALESCE(post_states.starred_at, EXCLUDED.starred_at),
+    hidden_at = COALESCE(post_states.hidden_at, EXCLUDED.hidden_at);
+
+-- name: AddPostTag :exec
+INSERT INTO post_tags (user_id, post_id, tag, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT DO NOTHING;
+
+-- name: GetPostStates :many
+SELECT * FROM post_states
+WHERE user_id = sqlc.arg(user_id) AND post_id = ANY(sqlc.arg(post_ids)::uuid[]);
+
+-- name: GetPostTags :many
+SELECT * FROM post_tags
+WHERE user_id = sqlc.arg(user_id) AND post_id = ANY(sqlc.arg(post_ids)::uuid[])
+ORDER BY tag;
+
+-- name: SetPostRead :exec
+INSERT INTO post_states (user_id, post_id, read_at)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (user_id, post_id) DO UPDATE
+SET read_at = EXCLUDED.read_at;
+
+-- name: SetPostStarred :exec
+INSERT INTO post_states (user_id, post_id, starred_at)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (user_id, post_id) DO UPDATE
+SET starred_at = EXCLUDED.starred_at;
+
+-- name: MarkReadBefore :execrows
+-- MarkReadBefore marks every followed post published before a time read,
+-- optionally only those of one feed or folder.
+INSERT INTO post_states (user_id, post_id, read_at)
+SELECT sqlc.arg(user_id), p.id, sqlc.arg(read_at)
+FROM posts p
+JOIN feed_follows ff ON ff.feed_id = p.feed_id AND ff.user_id = sqlc.arg(user_id)
+WHERE p.published_at < sqlc.arg(before)
+  AND (sqlc.narg(feed_id)::uuid IS NULL OR p.feed_id = sqlc.narg(feed_id)::uuid)
+  AND (sqlc.narg(folder)::text IS NULL OR EXISTS (
+      SELECT 1 FROM follow_folders fo
+      WHERE fo.feed_follow_id = ff.id AND fo.folder = sqlc.narg(folder)::text
+  ))
+ON CONFLICT (user_id, post_id) DO UPDATE
+SET read_at = COALESCE(post_states.read_at, EXCLUDED.read_at);
@@ -1,5 +1,5 @@
 -- name: CreatePost :one
-INSERT INTO posts (id, created_at, updated_at, title, url, description, published_at, feed_id)
+INSERT INTO posts (id, created_at, updated_at, title, url, description, published_at, feed_id, author, categories)
 VALUES (
     $1,
     $2,
@@ -8,13 +8,102 @@ VALUES (
     $5,
     $6,
     $7,
-    $8
+    $8,
+    $9,
+    $10
 )
-RETURNING *;
+ON CONFLICT (url) DO UPDATE
+SET title = EXCLUDED.title, description = EXCLUDED.description, updated_at = EXCLUDED.updated_at,
+    author = EXCLUDED.author, categories = EXCLUDED.categories
+WHERE posts.feed_id = EXCLUDED.feed_id
+  AND (posts.title IS DISTINCT FROM EXCLUDED.title OR posts.description IS DISTINCT FROM EXCLUDED.description)
+RETURNING *, (xmax = 0) AS inserted;
 
 -- name: GetPostsForUser :many
-SELECT * FROM posts p
+SELECT p.* FROM posts p
 JOIN feed_follows ff ON p.feed_id = ff.feed_id
 WHERE ff.user_id = $1
 ORDER BY published_at DESC
 LIMIT $2;
+
+-- name: GetPostsForUserPage :many
+-- GetPostsForUserPage leaves out the posts the user hid, so a page is only
+-- short once it runs out of posts.
+SELECT p.* FROM posts p
+JOIN feed_follows ff ON p.feed_id = ff.feed_id
+LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = ff.user_id
+WHERE ff.user_id = sqlc.arg(user_id)
+  AND ps.hidden_at IS NULL
+  AND (sqlc.narg(feed_id)::uuid IS NULL OR p.feed_id = sqlc.narg(feed_id)::uuid)
+  AND (sqlc.narg(folder)::text IS NULL OR EXISTS (
+      SELECT 1 FROM follow_folders fo
+      WHERE fo.feed_follow_id = ff.id AND fo.folder = sqlc.narg(folder)::text
+  ))
+  AND (sqlc.narg(since)::timestamp IS NULL OR p.published_at >= sqlc.narg(since)::timestamp)
+  AND (sqlc.narg(query)::text IS NULL OR strpos(lower(p.title), lower(sqlc.narg(query)::text)) > 0)
+ORDER BY p.published_at DESC, p.id
+LIMIT sqlc.arg(page_limit) OFFSET sqlc.arg(page_offset);
+
+-- name: GetLatestPosts :many
+SELECT * FROM posts
+WHERE sqlc.narg(feed_id)::uuid IS NULL OR feed_id = sqlc.narg(feed_id)::uuid
+ORDER BY published_at DESC, id
+LIMIT sqlc.arg(max_posts);
+
+-- name: GetRiverPosts :many
+-- GetRiverPosts pages through the posts a user follows with their read and
+-- star state, leaving out hidden ones. The other arguments narrow it down to
+-- one feed or folder, a state, a time range or a set of item ids.
+SELECT p.*, COALESCE(ff.title, f.name)::text AS feed_title, ps.read_at, ps.starred_at
+FROM posts p
+JOIN feed_follows ff ON ff.feed_id = p.feed_id AND ff.user_id = sqlc.arg(user_id)
+JOIN feeds f ON f.id = p.feed_id
+LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = sqlc.arg(user_id)
+WHERE ps.hidden_at IS NULL
+  AND (sqlc.narg(feed_id)::uuid IS NULL OR p.feed_id = sqlc.narg(feed_id)::uuid)
+  AND (sqlc.narg(folder)::text IS NULL OR EXISTS (
+      SELECT 1 FROM follow_folders fo
+      WHERE fo.feed_follow_id = ff.id AND fo.folder = sqlc.narg(folder)::text
+  ))
+  AND (NOT sqlc.arg(unread_only)::boolean OR ps.read_at IS NULL)
+  AND (NOT sqlc.arg(starred_only)::boolean OR ps.starred_at IS NOT NULL)
+  AND (NOT sqlc.arg(read_only)::boolean OR ps.read_at IS NOT NULL)
+  AND (sqlc.narg(since)::timestamp IS NULL OR p.published_at >= sqlc.narg(since)::timestamp)
+  AND (sqlc.narg(until)::timestamp IS NULL OR p.published_at < sqlc.narg(until)::timestamp)
+  AND (sqlc.narg(item_ids)::bigint[] IS NULL OR p.item_id = ANY(sqlc.narg(item_ids)::bigint[]))
+ORDER BY
+    CASE WHEN sqlc.arg(oldest_first)::boolean THEN p.published_at END ASC,
+    p.published_at DESC, p.id
+LIMIT sqlc.arg(page_limit) OFFSET sqlc.arg(page_offset);
+
+-- name: GetUnreadCounts :many
+SELECT p.feed_id, COUNT(*) AS unread
+FROM posts p
+JOIN feed_follows ff ON ff.feed_id = p.feed_id AND ff.user_id = $1
+LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = $1
+WHERE ps.read_at IS NULL AND ps.hidden_at IS NULL
+GROUP BY p.feed_id;
+
+-- name: GetPostIDsByItemID :many
+SELECT id, item_id FROM posts
+WHERE item_id = ANY(sqlc.arg(item_ids)::bigint[]);
+
+-- name: GetReplayItemIDs :many
+-- GetReplayItemIDs pages through the item ids of posts a user follows that
+-- came after last_item_id, in id order from cursor. Ids are taken when a post
+-- is inserted rather than committed, so a lower id can show up after a
+-- higher one; posts stored up to lookback_seconds before last_item_id's are
+-- included again for that.
+SELECT p.item_id FROM posts p
+JOIN feed_follows ff ON ff.feed_id = p.feed_id AND ff.user_id = sqlc.arg(user_id)
+WHERE p.item_id > sqlc.arg(cursor)
+  AND (p.item_id > sqlc.arg(last_item_id)
+       OR p.created_at >= (SELECT created_at FROM posts WHERE item_id = sqlc.arg(last_item_id))
+                          - make_interval(secs => sqlc.arg(lookback_seconds)::int))
+ORDER BY p.item_id
+LIMIT sqlc.arg(max_items);
+
+-- name: NotifyNewPost :exec
+-- NotifyNewPost tells every serve process listening on gator_new_post about
+-- a stored post, by its item id.
+SELECT pg_notify('gator_new_post', sqlc.arg(item_id)::bigint::text);
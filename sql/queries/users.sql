@@ -21,3 +21,35 @@ DELETE FROM users;
 
 -- name: GetUsers :many
 SELECT * FROM users;
+
+-- name: SetUserPassword :exec
+UPDATE users
+SET password_hash = $2, updated_at = $3
+WHERE id = $1;
+
+-- name: SetUserRole :exec
+UPDATE users
+SET role = $2, updated_at = $3
+WHERE id = $1;
+
+-- name: LockRoles :exec
+-- LockRoles makes transactions that check who is admin before granting the
+-- role wait for each other, until the surrounding transaction ends.
+SELECT pg_advisory_xact_lock(hashtext('gator_roles'));
+
+-- name: GrantFirstAdmin :execrows
+UPDATE users
+SET role = 'admin', updated_at = $2
+WHERE id = $1
+  AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin');
+
+-- name: CountAdmins :one
+SELECT COUNT(*) FROM users
+WHERE role = 'admin';
+
+-- name: GetDatabaseCounts :one
+SELECT
+    (SELECT COUNT(*) FROM users) AS users,
+    (SELECT COUNT(*) FROM feeds) AS feeds,
+    (SELECT COUNT(*) FROM feed_follows) AS feed_follows,
+    (SELECT COUNT(*) FROM posts) AS posts;
@@ -0,0 +1,47 @@
+-- name: CreateWatch :one
+INSERT INTO watches (id, created_at, user_id, query, notifier, target)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+RETURNING *;
+
+-- name: GetWatchesForUser :many
+SELECT * FROM watches
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: GetWatchesForFeed :many
+SELECT watches.* FROM watches
+JOIN feed_follows ON feed_follows.user_id = watches.user_id
+WHERE feed_follows.feed_id = $1
+ORDER BY watches.created_at;
+
+-- name: DeleteWatch :execrows
+DELETE FROM watches
+WHERE id = $1 AND user_id = $2;
+
+-- name: RecordWatchMatch :execrows
+INSERT INTO watch_matches (watch_id, post_id, matched_at)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT DO NOTHING;
+
+-- name: SetWatchMatchResult :exec
+UPDATE watch_matches
+SET notified_at = $3, error = $4
+WHERE watch_id = $1 AND post_id = $2;
+
+-- name: GetWatchMatchCounts :many
+SELECT watch_id, COUNT(*) AS matches, COUNT(notified_at) AS notified
+FROM watch_matches
+JOIN watches ON watches.id = watch_matches.watch_id
+WHERE watches.user_id = $1
+GROUP BY watch_id;
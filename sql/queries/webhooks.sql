@@ -0,0 +1,119 @@
+-- name: CreateWebhook :one
+INSERT INTO webhooks (id, created_at, user_id, url, secret, all_feeds)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+RETURNING *;
+
+-- name: AddWebhookFeed :exec
+INSERT INTO webhook_feeds (webhook_id, feed_id)
+VALUES (
+    $1,
+    $2
+)
+ON CONFLICT DO NOTHING;
+
+-- name: GetWebhooksForUser :many
+SELECT * FROM webhooks
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: GetWebhookFeedNames :many
+SELECT webhook_feeds.webhook_id, feeds.name FROM webhook_feeds
+JOIN feeds ON feeds.id = webhook_feeds.feed_id
+JOIN webhooks ON webhooks.id = webhook_feeds.webhook_id
+WHERE webhooks.user_id = $1
+ORDER BY feeds.name;
+
+-- name: GetWebhookDeliveryCounts :many
+SELECT
+    webhook_deliveries.webhook_id,
+    COUNT(webhook_deliveries.delivered_at) AS delivered,
+    COUNT(webhook_deliveries.failed_at) AS failed,
+    COUNT(*) FILTER (WHERE webhook_deliveries.delivered_at IS NULL AND webhook_deliveries.failed_at IS NULL) AS pending
+FROM webhook_deliveries
+JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+WHERE webhooks.user_id = $1
+GROUP BY webhook_deliveries.webhook_id;
+
+-- name: DeleteWebhook :execrows
+DELETE FROM webhooks
+WHERE id = $1 AND user_id = $2;
+
+-- name: EnqueueWebhookDeliveries :execrows
+-- A webhook sees posts from the feeds it is filtered to, or else from every
+-- feed its owner follows. Admin webhooks with all_feeds see every post.
+INSERT INTO webhook_deliveries (id, created_at, webhook_id, post_id, next_attempt_at)
+SELECT gen_random_uuid(), sqlc.arg(now)::timestamp, webhooks.id, sqlc.arg(post_id)::uuid, sqlc.arg(now)::timestamp
+FROM webhooks
+WHERE CASE
+    WHEN EXISTS (SELECT 1 FROM webhook_feeds WHERE webhook_feeds.webhook_id = webhooks.id)
+        THEN EXISTS (SELECT 1 FROM webhook_feeds WHERE webhook_feeds.webhook_id = webhooks.id AND webhook_feeds.feed_id = sqlc.arg(feed_id)::uuid)
+    WHEN webhooks.all_feeds THEN TRUE
+    ELSE EXISTS (SELECT 1 FROM feed_follows WHERE feed_follows.user_id = webhooks.user_id AND feed_follows.feed_id = sqlc.arg(feed_id)::uuid)
+END
+ON CONFLICT DO NOTHING;
+
+-- name: ClaimWebhookDeliveries :many
+-- Claimed deliveries are pushed back by the lease so another worker does not
+-- pick them up while they are being sent.
+UPDATE webhook_deliveries
+SET next_attempt_at = sqlc.arg(lease_until)
+WHERE webhook_deliveries.id IN (
+    SELECT pending.id FROM webhook_deliveries pending
+    WHERE pending.delivered_at IS NULL AND pending.failed_at IS NULL
+      AND pending.next_attempt_at <= sqlc.arg(now)
+    ORDER BY pending.next_attempt_at
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: GetWebhookDeliveryPayload :one
+SELECT
+    webhooks.url AS webhook_url,
+    webhooks.secret,
+    posts.id AS post_id,
+    posts.title,
+    posts.url,
+    posts.description,
+    posts.published_at,
+    posts.author,
+    posts.categories,
+    feeds.id AS feed_id,
+    feeds.name AS feed_name,
+    feeds.url AS feed_url
+FROM webhook_deliveries
+JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+JOIN posts ON posts.id = webhook_deliveries.post_id
+JOIN feeds ON feeds.id = posts.feed_id
+WHERE webhook_deliveries.id = $1;
+
+-- name: RecordWebhookAttempt :exec
+INSERT INTO webhook_attempts (id, delivery_id, attempted_at, duration_ms, status_code, error)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: FinishWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET attempts = $2, next_attempt_at = $3, delivered_at = $4, failed_at = $5
+WHERE id = $1;
+
+-- name: GetWebhookAttempts :many
+SELECT webhook_attempts.*, webhook_deliveries.post_id, webhook_deliveries.attempts FROM webhook_attempts
+JOIN webhook_deliveries ON webhook_deliveries.id = webhook_attempts.delivery_id
+JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+WHERE webhooks.id = $1 AND webhooks.user_id = $2
+ORDER BY webhook_attempts.attempted_at DESC
+LIMIT $3;
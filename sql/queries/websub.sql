@@ -0,0 +1,68 @@
+-- name: SetWebSubHub :exec
+-- SetWebSubHub records the hub a feed advertises. A different hub or topic
+-- than before starts the subscription over.
+INSERT INTO websub_subscriptions (feed_id, created_at, updated_at, hub, topic, secret)
+VALUES (
+    $1,
+    $2,
+    $2,
+    $3,
+    $4,
+    $5
+)
+ON CONFLICT (feed_id) DO UPDATE
+SET hub = EXCLUDED.hub,
+    topic = EXCLUDED.topic,
+    secret = EXCLUDED.secret,
+    state = 'new',
+    requested_at = NULL,
+    lease_expires_at = NULL,
+    error = NULL,
+    updated_at = EXCLUDED.updated_at
+WHERE websub_subscriptions.hub <> EXCLUDED.hub
+   OR websub_subscriptions.topic <> EXCLUDED.topic;
+
+-- name: DeleteWebSubSubscription :exec
+DELETE FROM websub_subscriptions
+WHERE feed_id = $1;
+
+-- name: GetWebSubSubscription :one
+SELECT * FROM websub_subscriptions
+WHERE feed_id = $1;
+
+-- name: GetDueWebSubSubscriptions :many
+-- GetDueWebSubSubscriptions returns the subscriptions to request: new ones,
+-- pending ones the hub never verified, and active ones whose lease ends
+-- soon. Each is asked for at most once per retry period.
+SELECT * FROM websub_subscriptions
+WHERE (requested_at IS NULL OR requested_at < sqlc.arg(retry_before))
+  AND (state IN ('new', 'pending')
+       OR (state = 'active' AND lease_expires_at < sqlc.arg(renew_before)))
+ORDER BY created_at;
+
+-- name: MarkWebSubRequested :exec
+-- MarkWebSubRequested records a subscription request, and then its error if
+-- the hub refused it. A new subscription, or an active one being renewed,
+-- is pending until the hub verifies it.
+UPDATE websub_subscriptions
+SET requested_at = sqlc.arg(requested_at),
+    updated_at = sqlc.arg(requested_at),
+    error = sqlc.narg(error),
+    state = CASE WHEN state IN ('new', 'active') THEN 'pending' ELSE state END
+WHERE feed_id = sqlc.arg(feed_id);
+
+-- name: ActivateWebSubSubscription :exec
+UPDATE websub_subscriptions
+SET state = 'active', lease_expires_at = $2, error = NULL, updated_at = $3
+WHERE feed_id = $1;
+
+-- name: DenyWebSubSubscription :exec
+UPDATE websub_subscriptions
+SET state = 'denied', error = $2, updated_at = $3
+WHERE feed_id = $1;
+
+-- name: GetWebSubSubscriptions :many
+SELECT websub_subscriptions.*, feeds.name AS feed_name, feeds.url AS feed_url
+FROM websub_subscriptions
+JOIN feeds ON feeds.id = websub_subscriptions.feed_id
+ORDER BY feeds.name;
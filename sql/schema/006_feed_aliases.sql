@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE feeds
+ADD dead_at TIMESTAMP;
+
+CREATE TABLE feed_aliases (
+    url TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    feed_id UUID NOT NULL REFERENCES feeds ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE feed_aliases;
+
+ALTER TABLE feeds
+DROP COLUMN dead_at;
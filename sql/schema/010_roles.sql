@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE users
+ADD role VARCHAR(16) NOT NULL DEFAULT 'member' CHECK (role IN ('admin', 'member'));
+
+-- Existing installations keep working: their oldest user becomes the admin.
+UPDATE users
+SET role = 'admin'
+WHERE id = (SELECT id FROM users ORDER BY created_at, id LIMIT 1);
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN role;
@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE feed_follows
+ADD title VARCHAR(255);
+
+CREATE TABLE follow_folders (
+    feed_follow_id UUID NOT NULL REFERENCES feed_follows ON DELETE CASCADE,
+    folder VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (feed_follow_id, folder)
+);
+
+-- +goose Down
+DROP TABLE follow_folders;
+
+ALTER TABLE feed_follows
+DROP COLUMN title;
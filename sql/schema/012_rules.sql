@@ -0,0 +1,41 @@
+-- +goose Up
+ALTER TABLE posts
+ADD author TEXT,
+ADD categories TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE rules (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    field VARCHAR(16) NOT NULL CHECK (field IN ('any', 'title', 'description', 'author', 'category', 'feed')),
+    match_type VARCHAR(16) NOT NULL CHECK (match_type IN ('substring', 'regex', 'keywords')),
+    pattern TEXT NOT NULL,
+    action VARCHAR(16) NOT NULL CHECK (action IN ('hide', 'read', 'star', 'tag')),
+    tag VARCHAR(255)
+);
+
+CREATE TABLE post_states (
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    post_id UUID NOT NULL REFERENCES posts ON DELETE CASCADE,
+    read_at TIMESTAMP,
+    starred_at TIMESTAMP,
+    hidden_at TIMESTAMP,
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE TABLE post_tags (
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    post_id UUID NOT NULL REFERENCES posts ON DELETE CASCADE,
+    tag VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, post_id, tag)
+);
+
+-- +goose Down
+DROP TABLE post_tags;
+DROP TABLE post_states;
+DROP TABLE rules;
+
+ALTER TABLE posts
+DROP COLUMN categories,
+DROP COLUMN author;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE watches (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    query TEXT NOT NULL,
+    notifier VARCHAR(16) NOT NULL CHECK (notifier IN ('desktop', 'webhook', 'email')),
+    target TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE watch_matches (
+    watch_id UUID NOT NULL REFERENCES watches ON DELETE CASCADE,
+    post_id UUID NOT NULL REFERENCES posts ON DELETE CASCADE,
+    matched_at TIMESTAMP NOT NULL,
+    notified_at TIMESTAMP,
+    error TEXT,
+    PRIMARY KEY (watch_id, post_id)
+);
+
+-- +goose Down
+DROP TABLE watch_matches;
+DROP TABLE watches;
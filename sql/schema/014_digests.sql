@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE digests (
+    user_id UUID PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    frequency VARCHAR(16) NOT NULL CHECK (frequency IN ('off', 'daily', 'weekly')),
+    last_digest_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE digests;
@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE feed_tokens (
+    user_id UUID PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL
+);
+
+-- +goose Down
+DROP TABLE feed_tokens;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- A row exists while a feed advertises a WebSub hub. state moves from new
+-- to pending once the hub is asked, to active once it verifies the request,
+-- or to denied.
+CREATE TABLE websub_subscriptions (
+    feed_id UUID PRIMARY KEY REFERENCES feeds ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    hub TEXT NOT NULL,
+    topic TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT 'new',
+    requested_at TIMESTAMP,
+    lease_expires_at TIMESTAMP,
+    error TEXT
+);
+
+-- +goose Down
+DROP TABLE websub_subscriptions;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- cluster_id is the id of the first post seen of a story, shared by the
+-- near-duplicates of it stored later.
+CREATE TABLE post_fingerprints (
+    post_id UUID PRIMARY KEY REFERENCES posts ON DELETE CASCADE,
+    published_at TIMESTAMP NOT NULL,
+    fingerprint BIGINT NOT NULL,
+    cluster_id UUID NOT NULL
+);
+
+CREATE INDEX post_fingerprints_published_at_idx ON post_fingerprints (published_at);
+
+-- +goose Down
+DROP TABLE post_fingerprints;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- bands splits the fingerprint into 13 runs of 5 bits, each tagged with its
+-- position as position * 32 + bits. Two fingerprints at most 12 bits apart
+-- share at least one run, so candidates are found through the index rather
+-- than by comparing every post in the window. feed_id keeps copies within
+-- one feed apart.
+ALTER TABLE post_fingerprints
+    ADD COLUMN feed_id UUID REFERENCES feeds ON DELETE CASCADE,
+    ADD COLUMN bands INTEGER[];
+
+UPDATE post_fingerprints pf
+SET feed_id = p.feed_id,
+    bands = ARRAY(
+        SELECT (i * 32 + ((pf.fingerprint >> (5 * i)) & CASE WHEN i = 12 THEN 15 ELSE 31 END))::integer
+        FROM generate_series(0, 12) AS i
+        ORDER BY i
+    )
+FROM posts p
+WHERE p.id = pf.post_id;
+
+ALTER TABLE post_fingerprints
+    ALTER COLUMN feed_id SET NOT NULL,
+    ALTER COLUMN bands SET NOT NULL;
+
+CREATE INDEX post_fingerprints_bands_idx ON post_fingerprints USING GIN (bands);
+
+-- +goose Down
+DROP INDEX post_fingerprints_bands_idx;
+ALTER TABLE post_fingerprints
+    DROP COLUMN bands,
+    DROP COLUMN feed_id;
@@ -0,0 +1,76 @@
+-- +goose Up
+-- river_changes records when a user's follows, folders, rules or hidden
+-- posts last changed, so published feeds can tell readers polling with
+-- If-Modified-Since that posts were added or dropped even though no newer
+-- post arrived. Triggers keep it current whichever command, API or reader
+-- made the change, including deletions that leave no row behind.
+CREATE TABLE river_changes (
+    user_id UUID PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    changed_at TIMESTAMP NOT NULL
+);
+
+-- +goose StatementBegin
+CREATE FUNCTION touch_river(changed_user UUID) RETURNS void AS $$
+BEGIN
+    -- Skips users being deleted, whose rows cascade away here.
+    INSERT INTO river_changes (user_id, changed_at)
+    SELECT id, now() FROM users WHERE id = changed_user
+    ON CONFLICT (user_id) DO UPDATE SET changed_at = EXCLUDED.changed_at;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION touch_river_by_user() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM touch_river(OLD.user_id);
+    ELSE
+        PERFORM touch_river(NEW.user_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION touch_river_by_follow() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM touch_river(user_id) FROM feed_follows WHERE id = OLD.feed_follow_id;
+    ELSE
+        PERFORM touch_river(user_id) FROM feed_follows WHERE id = NEW.feed_follow_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER feed_follows_touch_river AFTER INSERT OR UPDATE OR DELETE ON feed_follows
+FOR EACH ROW EXECUTE FUNCTION touch_river_by_user();
+
+CREATE TRIGGER rules_touch_river AFTER INSERT OR UPDATE OR DELETE ON rules
+FOR EACH ROW EXECUTE FUNCTION touch_river_by_user();
+
+CREATE TRIGGER follow_folders_touch_river AFTER INSERT OR UPDATE OR DELETE ON follow_folders
+FOR EACH ROW EXECUTE FUNCTION touch_river_by_follow();
+
+-- Only hiding counts; reading and starring do not change published feeds.
+CREATE TRIGGER post_states_insert_touch_river AFTER INSERT ON post_states
+FOR EACH ROW WHEN (NEW.hidden_at IS NOT NULL)
+EXECUTE FUNCTION touch_river_by_user();
+
+CREATE TRIGGER post_states_update_touch_river AFTER UPDATE OF hidden_at ON post_states
+FOR EACH ROW WHEN (OLD.hidden_at IS DISTINCT FROM NEW.hidden_at)
+EXECUTE FUNCTION touch_river_by_user();
+
+-- +goose Down
+DROP TRIGGER post_states_update_touch_river ON post_states;
+DROP TRIGGER post_states_insert_touch_river ON post_states;
+DROP TRIGGER follow_folders_touch_river ON follow_folders;
+DROP TRIGGER rules_touch_river ON rules;
+DROP TRIGGER feed_follows_touch_river ON feed_follows;
+DROP FUNCTION touch_river_by_follow();
+DROP FUNCTION touch_river_by_user();
+DROP FUNCTION touch_river(UUID);
+DROP TABLE river_changes;
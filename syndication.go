@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+// outFeed is a feed gator publishes from stored posts, ready to be written
+// as RSS 2.0, Atom or JSON Feed.
+type outFeed struct {
+    ID          string
+    Title       string
+    Description string
+    HomeURL     string
+    SelfURL     string
+    Updated     time.Time
+    Items       []outItem
+}
+
+type outItem struct {
+    ID          uuid.UUID
+    Title       string
+    URL         string
+    Content     string
+    Author      string
+    Categories  []string
+    Published   time.Time
+    Updated     time.Time
+    Source      string
+    SourceURL   string
+}
+
+func newOutItem(post database.Post, feedName, feedURL string) outItem {
+    return outItem{
+        ID: post.ID,
+        Title: post.Title,
+        URL: post.Url,
+        Content: post.Description.String,
+        Author: post.Author.String,
+        Categories: post.Categories,
+        Published: post.PublishedAt,
+        Updated: post.UpdatedAt,
+        Source: feedName,
+        SourceURL: feedURL,
+    }
+}
+
+// newestUpdate is the time the feed last changed, used for its updated
+// fields and Last-Modified.
+func newestUpdate(items []outItem) time.Time {
+    var newest time.Time
+    for _, item := range(items) {
+        if item.Updated.After(newest) {
+            newest = item.Updated
+        }
+        if item.Published.After(newest) {
+            newest = item.Published
+        }
+    }
+    return newest
+}
+
+type rssOut struct {
+    XMLName xml.Name        `xml:"rss"`
+    Version string          `xml:"version,attr"`
+    AtomNS  string          `xml:"xmlns:atom,attr"`
+    DCNS    string          `xml:"xmlns:dc,attr"`
+    Channel rssOutChannel   `xml:"channel"`
+}
+
+type rssOutChannel struct {
+    Title           string          `xml:"title"`
+    Link            string          `xml:"link"`
+    Description     string          `xml:"description"`
+    SelfLink        rssOutAtomLink  `xml:"atom:link"`
+    LastBuildDate   string          `xml:"lastBuildDate,omitempty"`
+    Generator       string          `xml:"generator"`
+    Items           []rssOutItem    `xml:"item"`
+}
+
+type rssOutAtomLink struct {
+    Href    string  `xml:"href,attr"`
+    Rel     string  `xml:"rel,attr"`
+    Type    string  `xml:"type,attr"`
+}
+
+type rssOutItem struct {
+    Title       string          `xml:"title"`
+    Link        string          `xml:"link"`
+    Description string          `xml:"description,omitempty"`
+    GUID        rssOutGUID      `xml:"guid"`
+    PubDate     string          `xml:"pubDate"`
+    Creator     string          `xml:"dc:creator,omitempty"`
+    Categories  []string        `xml:"category"`
+    Source      *rssOutSource   `xml:"source"`
+}
+
+type rssOutGUID struct {
+    Value       string  `xml:",chardata"`
+    IsPermaLink bool    `xml:"isPermaLink,attr"`
+}
+
+type rssOutSource struct {
+    URL     string  `xml:"url,attr"`
+    Name    string  `xml:",chardata"`
+}
+
+func (f outFeed) writeRSS(w io.Writer) error {
+    out := rssOut{
+        Version: "2.0",
+        AtomNS: "http://www.w3.org/2005/Atom",
+        DCNS: "http://purl.org/dc/elements/1.1/",
+        Channel: rssOutChannel{
+            Title: f.Title,
+            Link: f.HomeURL,
+            Description: f.Description,
+            SelfLink: rssOutAtomLink{Href: f.SelfURL, Rel: "self", Type: "application/rss+xml"},
+            Generator: "gator",
+        },
+    }
+    if !f.Updated.IsZero() {
+        out.Channel.LastBuildDate = f.Updated.Format(time.RFC1123Z)
+    }
+
+    for _, item := range(f.Items) {
+        rssItem := rssOutItem{
+            Title: item.Title,
+            Link: item.URL,
+            Description: item.Content,
+            GUID: rssOutGUID{Value: item.URL, IsPermaLink: true},
+            PubDate: item.Published.Format(time.RFC1123Z),
+            Creator: item.Author,
+            Categories: item.Categories,
+        }
+        if item.SourceURL != "" {
+            rssItem.Source = &rssOutSource{URL: item.SourceURL, Name: item.Source}
+        }
+        out.Channel.Items = append(out.Channel.Items, rssItem)
+    }
+
+    return writeXML(w, out)
+}
+
+type atomOut struct {
+    XMLName xml.Name        `xml:"http://www.w3.org/2005/Atom feed"`
+    ID      string          `xml:"id"`
+    Title   string          `xml:"title"`
+    Subtitle string         `xml:"subtitle,omitempty"`
+    Updated string          `xml:"updated"`
+    Author  atomOutPerson   `xml:"author"`
+    Links   []atomOutLink   `xml:"link"`
+    Generator string        `xml:"generator"`
+    Entries []atomOutEntry  `xml:"entry"`
+}
+
+type atomOutPerson struct {
+    Name    string  `xml:"name"`
+}
+
+type atomOutLink struct {
+    Href    string  `xml:"href,attr"`
+    Rel     string  `xml:"rel,attr,omitempty"`
+    Type    string  `xml:"type,attr,omitempty"`
+}
+
+type atomOutEntry struct {
+    ID          string              `xml:"id"`
+    Title       string              `xml:"title"`
+    Links       []atomOutLink       `xml:"link"`
+    Published   string              `xml:"published"`
+    Updated     string              `xml:"updated"`
+    Author      *atomOutPerson      `xml:"author"`
+    Categories  []atomOutCategory   `xml:"category"`
+    Summary     *atomOutText        `xml:"summary"`
+    Source      *atomOutSource      `xml:"source"`
+}
+
+type atomOutCategory struct {
+    Term    string  `xml:"term,attr"`
+}
+
+type atomOutText struct {
+    Type    string  `xml:"type,attr"`
+    Body    string  `xml:",chardata"`
+}
+
+type atomOutSource struct {
+    Title   string          `xml:"title"`
+    Links   []atomOutLink   `xml:"link"`
+}
+
+func (f outFeed) writeAtom(w io.Writer) error {
+    // Atom requires updated, and a zero one reads as a long dead feed.
+    updated := f.Updated
+    if updated.IsZero() {
+        updated = time.Now()
+    }
+
+    out := atomOut{
+        ID: f.ID,
+        Title: f.Title,
+        Subtitle: f.Description,
+        Updated: updated.UTC().Format(time.RFC3339),
+        Author: atomOutPerson{Name: "gator"},
+        Links: []atomOutLink{
+            {Href: f.SelfURL, Rel: "self", Type: "application/atom+xml"},
+        },
+        Generator: "gator",
+    }
+    if f.HomeURL != "" {
+        out.Links = append(out.Links, atomOutLink{Href: f.HomeURL, Rel: "alternate", Type: "text/html"})
+    }
+
+    for _, item := range(f.Items) {
+        entry := atomOutEntry{
+            ID: "urn:uuid:" + item.ID.String(),
+            Title: item.Title,
+            Links: []atomOutLink{{Href: item.URL, Rel: "alternate"}},
+            Published: item.Published.UTC().Format(time.RFC3339),
+            Updated: item.Updated.UTC().Format(time.RFC3339),
+        }
+        if item.Author != "" {
+            entry.Author = &atomOutPerson{Name: item.Author}
+        }
+        for _, category := range(item.Categories) {
+            entry.Categories = append(entry.Categories, atomOutCategory{Term: category})
+        }
+        if item.Content != "" {
+            entry.Summary = &atomOutText{Type: "html", Body: item.Content}
+        }
+        if item.SourceURL != "" {
+            entry.Source = &atomOutSource{
+                Title: item.Source,
+                Links: []atomOutLink{{Href: item.SourceURL, Rel: "self"}},
+            }
+        }
+        out.Entries = append(out.Entries, entry)
+    }
+
+    return writeXML(w, out)
+}
+
+func writeXML(w io.Writer, v any) error {
+    _, err := io.WriteString(w, xml.Header)
+    if err != nil {
+        return err
+    }
+
+    encoder := xml.NewEncoder(w)
+    encoder.Indent("", "  ")
+    err = encoder.Encode(v)
+    if err != nil {
+        return err
+    }
+    _, err = io.WriteString(w, "\n")
+    return err
+}
+
+type jsonFeedOut struct {
+    Version     string          `json:"version"`
+    Title       string          `json:"title"`
+    Description string          `json:"description,omitempty"`
+    HomePageURL string          `json:"home_page_url,omitempty"`
+    FeedURL     string          `json:"feed_url,omitempty"`
+    Items       []jsonFeedItem  `json:"items"`
+}
+
+type jsonFeedItem struct {
+    ID              string              `json:"id"`
+    URL             string              `json:"url"`
+    Title           string              `json:"title"`
+    ContentHTML     string              `json:"content_html"`
+    DatePublished   time.Time           `json:"date_published"`
+    DateModified    time.Time           `json:"date_modified"`
+    Authors         []jsonFeedAuthor    `json:"authors,omitempty"`
+    Tags            []string            `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+    Name    string  `json:"name"`
+}
+
+func (f outFeed) writeJSONFeed(w io.Writer) error {
+    out := jsonFeedOut{
+        Version: "https://jsonfeed.org/version/1.1",
+        Title: f.Title,
+        Description: f.Description,
+        HomePageURL: f.HomeURL,
+        FeedURL: f.SelfURL,
+        Items: make([]jsonFeedItem, 0, len(f.Items)),
+    }
+
+    for _, item := range(f.Items) {
+        jsonItem := jsonFeedItem{
+            ID: item.ID.String(),
+            URL: item.URL,
+            Title: item.Title,
+            ContentHTML: item.Content,
+            DatePublished: item.Published,
+            DateModified: item.Updated,
+            Tags: item.Categories,
+        }
+        if item.Author != "" {
+            jsonItem.Authors = []jsonFeedAuthor{{Name: item.Author}}
+        }
+        out.Items = append(out.Items, jsonItem)
+    }
+
+    encoder := json.NewEncoder(w)
+    encoder.SetEscapeHTML(false)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(out)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// templateFuncs are available to every template.
+var templateFuncs = map[string]any{
+    "date": func(t time.Time) string { return t.Format("Jan 2, 2006") },
+    "datetime": func(t time.Time) string { return t.Format(time.RFC1123) },
+}
+
+// overlayFS opens files from upper, falling back to lower for the ones
+// upper does not have.
+type overlayFS struct {
+    upper   fs.FS
+    lower   fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+    f, err := o.upper.Open(name)
+    if errors.Is(err, fs.ErrNotExist) {
+        return o.lower.Open(name)
+    }
+    return f, err
+}
+
+// templateFS returns the built-in templates, with the files in dir taking
+// their place when the user configured one, so any template can be
+// overridden by a file of the same name.
+func templateFS(dir string) fs.FS {
+    builtin, _ := fs.Sub(embeddedTemplates, "templates")
+    if dir != "" {
+        return overlayFS{upper: os.DirFS(dir), lower: builtin}
+    }
+    return builtin
+}
+
+// parseHTMLTemplate parses name along with any shared files whose
+// definitions it uses, such as a common layout.
+func parseHTMLTemplate(dir, name string, shared ...string) (*htmltemplate.Template, error) {
+    files := append([]string{name}, shared...)
+    return htmltemplate.New(name).Funcs(templateFuncs).ParseFS(templateFS(dir), files...)
+}
+
+func parseTextTemplate(dir, name string) (*texttemplate.Template, error) {
+    return texttemplate.New(name).Funcs(templateFuncs).ParseFS(templateFS(dir), name)
+}
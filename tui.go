@@ -0,0 +1,693 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+	"github.com/zulkou/blog-aggregator/tui"
+)
+
+const (
+    // tuiDefaultRefresh is how often the reader looks for posts agg has
+    // stored since, unless tui is given --refresh.
+    tuiDefaultRefresh = 30 * time.Second
+
+    // tuiMaxPosts caps the post list of one feed, folder or the river.
+    tuiMaxPosts = 500
+)
+
+type tuiPane int
+
+const (
+    paneFeeds tuiPane = iota
+    panePosts
+    paneArticle
+)
+
+// tuiEntry is a row of the feed pane: the river, starred posts, a folder or
+// a feed.
+type tuiEntry struct {
+    key     string
+    label   string
+    indent  int
+    unread  int64
+    params  database.GetRiverPostsParams
+}
+
+type tuiApp struct {
+    s       *state
+    user    database.User
+    screen  *tui.Screen
+
+    entries     []tuiEntry
+    entry       int
+    entryTop    int
+
+    posts       []database.GetRiverPostsRow
+    post        int
+    postTop     int
+
+    // article is the selected post laid out for articleWidth.
+    article     []tui.Line
+    articleID   uuid.UUID
+    articleWidth int
+    articleTop  int
+
+    focus   tuiPane
+    showAll bool
+    status  string
+    width   int
+    height  int
+}
+
+// handlerTUI runs a full-screen reader: feeds on the left, posts and the
+// selected article on the right. It polls the database, so posts stored by
+// an agg running elsewhere show up while reading.
+func handlerTUI(s *state, cmd command, user database.User) error {
+    refreshArg, args, err := cutOption(cmd.args, "refresh")
+    if err != nil {
+        return err
+    }
+    if len(args) != 0 {
+        return errors.New("The tui command expects no arguments besides --refresh <interval>")
+    }
+    refresh := tuiDefaultRefresh
+    if refreshArg != "" {
+        refresh, err = time.ParseDuration(refreshArg)
+        if err != nil || refresh <= 0 {
+            return fmt.Errorf("Invalid refresh interval %q", refreshArg)
+        }
+    }
+
+    app := &tuiApp{s: s, user: user}
+    err = app.reload()
+    if err != nil {
+        return err
+    }
+
+    app.screen, err = tui.Open()
+    if err != nil {
+        return err
+    }
+    defer app.screen.Close()
+
+    return app.run(refresh)
+}
+
+func (app *tuiApp) run(refresh time.Duration) error {
+    refreshTicker := time.NewTicker(refresh)
+    defer refreshTicker.Stop()
+    // Terminals only report resizes through a signal, which not every
+    // platform has, so the size is polled instead.
+    resizeTicker := time.NewTicker(250 * time.Millisecond)
+    defer resizeTicker.Stop()
+
+    dirty := true
+    for {
+        if dirty {
+            err := app.draw()
+            if err != nil {
+                return err
+            }
+            dirty = false
+        }
+
+        select {
+        case key, ok := <-app.screen.Keys():
+            if !ok || app.handleKey(key) {
+                return nil
+            }
+            dirty = true
+        case <-refreshTicker.C:
+            app.refresh()
+            dirty = true
+        case <-resizeTicker.C:
+            width, height, err := app.screen.Size()
+            dirty = err != nil || width != app.width || height != app.height
+        }
+    }
+}
+
+// refresh reloads everything, reporting failures in the status line rather
+// than ending the session.
+func (app *tuiApp) refresh() {
+    err := app.reload()
+    if err != nil {
+        app.status = err.Error()
+    }
+}
+
+// reload fetches the feed pane and the posts of the selected entry again,
+// keeping both selections where they were.
+func (app *tuiApp) reload() error {
+    ctx := context.Background()
+    nav, err := loadNav(ctx, app.s, app.user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to load feeds: %w", err)
+    }
+
+    var selected string
+    if app.entry < len(app.entries) {
+        selected = app.entries[app.entry].key
+    }
+
+    entries := []tuiEntry{
+        {key: "all", label: "All posts", unread: nav.Unread},
+        {key: "starred", label: "Starred", params: database.GetRiverPostsParams{StarredOnly: true}},
+    }
+    feedEntry := func(feed webNavFeed, indent int) tuiEntry {
+        return tuiEntry{
+            key: "feed:" + feed.ID.String(),
+            label: feed.Title,
+            indent: indent,
+            unread: feed.Unread,
+            params: database.GetRiverPostsParams{FeedID: uuid.NullUUID{UUID: feed.ID, Valid: true}},
+        }
+    }
+    for _, folder := range(nav.Folders) {
+        entries = append(entries, tuiEntry{
+            key: "folder:" + folder.Name,
+            label: folder.Name + "/",
+            unread: folder.Unread,
+            params: database.GetRiverPostsParams{Folder: sql.NullString{String: folder.Name, Valid: true}},
+        })
+        for _, feed := range(folder.Feeds) {
+            entry := feedEntry(feed, 1)
+            entry.key += ":" + folder.Name
+            entries = append(entries, entry)
+        }
+    }
+    for _, feed := range(nav.Unfiled) {
+        entries = append(entries, feedEntry(feed, 0))
+    }
+
+    app.entries = entries
+    app.entry = max(0, slices.IndexFunc(entries, func(e tuiEntry) bool { return e.key == selected }))
+    return app.loadPosts()
+}
+
+// loadPosts fetches the selected entry's posts. In the unread view the post
+// being read stays listed after it was marked read, until another is picked.
+func (app *tuiApp) loadPosts() error {
+    entry := app.entries[app.entry]
+    params := entry.params
+    params.UserID = app.user.ID
+    params.UnreadOnly = !app.showAll && !params.StarredOnly
+    params.PageLimit = tuiMaxPosts
+
+    rows, err := app.s.db.GetRiverPosts(context.Background(), params)
+    if err != nil {
+        return fmt.Errorf("Failed to load posts: %w", err)
+    }
+    rows, err = dropHiddenRiver(app.s, app.user, rows)
+    if err != nil {
+        return err
+    }
+
+    var current *database.GetRiverPostsRow
+    if app.post < len(app.posts) {
+        current = &app.posts[app.post]
+    }
+
+    app.post = 0
+    if current != nil {
+        i := slices.IndexFunc(rows, func(row database.GetRiverPostsRow) bool { return row.ID == current.ID })
+        if i < 0 && app.articleID == current.ID && current.ReadAt.Valid && params.UnreadOnly {
+            i, _ = slices.BinarySearchFunc(rows, *current, func(a, b database.GetRiverPostsRow) int {
+                return b.PublishedAt.Compare(a.PublishedAt)
+            })
+            rows = slices.Insert(rows, i, *current)
+        }
+        app.post = max(0, i)
+    }
+    app.posts = rows
+    return nil
+}
+
+// selectEntry shows the posts of another entry of the feed pane.
+func (app *tuiApp) selectEntry(i int) {
+    i = max(0, min(i, len(app.entries) - 1))
+    if i == app.entry && app.posts != nil {
+        return
+    }
+    app.entry = i
+    app.posts = nil
+    app.post = 0
+    app.postTop = 0
+    err := app.loadPosts()
+    if err != nil {
+        app.status = err.Error()
+    }
+}
+
+func (app *tuiApp) selectedPost() *database.GetRiverPostsRow {
+    if app.post < len(app.posts) {
+        return &app.posts[app.post]
+    }
+    return nil
+}
+
+func (app *tuiApp) movePost(delta int) {
+    if len(app.posts) == 0 {
+        return
+    }
+    app.post = max(0, min(app.post + delta, len(app.posts) - 1))
+}
+
+func (app *tuiApp) setRead(post *database.GetRiverPostsRow, read bool) {
+    if post.ReadAt.Valid == read {
+        return
+    }
+    at := sql.NullTime{}
+    if read {
+        at = sql.NullTime{Time: time.Now(), Valid: true}
+    }
+    err := app.s.db.SetPostRead(context.Background(), database.SetPostReadParams{
+        UserID: app.user.ID,
+        PostID: post.ID,
+        ReadAt: at,
+    })
+    if err != nil {
+        app.status = fmt.Sprintf("Failed to mark post: %v", err)
+        return
+    }
+    post.ReadAt = at
+
+    delta := int64(-1)
+    if !read {
+        delta = 1
+    }
+    app.adjustUnread(post.FeedID, delta)
+}
+
+// adjustUnread keeps the feed pane's counts right between refreshes.
+func (app *tuiApp) adjustUnread(feedID uuid.UUID, delta int64) {
+    feedKey := "feed:" + feedID.String()
+    var folders []string
+    for i := range(app.entries) {
+        entry := &app.entries[i]
+        if entry.key == feedKey || strings.HasPrefix(entry.key, feedKey + ":") {
+            entry.unread += delta
+            if folder, ok := strings.CutPrefix(entry.key, feedKey + ":"); ok {
+                folders = append(folders, "folder:" + folder)
+            }
+        }
+    }
+    for i := range(app.entries) {
+        entry := &app.entries[i]
+        if entry.key == "all" || slices.Contains(folders, entry.key) {
+            entry.unread += delta
+        }
+    }
+}
+
+func (app *tuiApp) toggleStar(post *database.GetRiverPostsRow) {
+    at := sql.NullTime{}
+    if !post.StarredAt.Valid {
+        at = sql.NullTime{Time: time.Now(), Valid: true}
+    }
+    err := app.s.db.SetPostStarred(context.Background(), database.SetPostStarredParams{
+        UserID: app.user.ID,
+        PostID: post.ID,
+        StarredAt: at,
+    })
+    if err != nil {
+        app.status = fmt.Sprintf("Failed to star post: %v", err)
+        return
+    }
+    post.StarredAt = at
+}
+
+// openURL hands link to the desktop's browser without waiting for it. Only
+// http and https URLs are opened, since feeds choose the links and the
+// openers also accept local files, other schemes and options.
+func openURL(link string) error {
+    u, err := url.Parse(link)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("Not an http or https link: %q", link)
+    }
+    link = u.String()
+
+    name, args := "xdg-open", []string{link}
+    switch runtime.GOOS {
+    case "darwin":
+        name = "open"
+    case "windows":
+        name, args = "rundll32", []string{"url.dll,FileProtocolHandler", link}
+    }
+
+    cmd := exec.Command(name, args...)
+    err = cmd.Start()
+    if err != nil {
+        return err
+    }
+    go cmd.Wait()
+    return nil
+}
+
+// handleKey acts on one key and reports whether to quit.
+func (app *tuiApp) handleKey(key tui.Key) bool {
+    app.status = ""
+    pageSize := max(1, app.paneHeight(app.focus) - 1)
+
+    move := 0
+    switch key.Code {
+    case tui.KeyCtrlC:
+        return true
+    case tui.KeyUp:
+        move = -1
+    case tui.KeyDown:
+        move = 1
+    case tui.KeyPgUp:
+        move = -pageSize
+    case tui.KeyPgDn:
+        move = pageSize
+    case tui.KeyHome:
+        move = -1 << 30
+    case tui.KeyEnd:
+        move = 1 << 30
+    case tui.KeyTab:
+        app.focus = (app.focus + 1) % 3
+    case tui.KeyBacktab:
+        app.focus = (app.focus + 2) % 3
+    case tui.KeyLeft, tui.KeyEsc, tui.KeyBackspace:
+        app.focus = max(paneFeeds, app.focus - 1)
+    case tui.KeyRight:
+        app.focus = min(paneArticle, app.focus + 1)
+    case tui.KeyEnter:
+        app.enter()
+    case tui.KeyRune:
+        switch key.Rune {
+        case 'q':
+            return true
+        case 'k':
+            move = -1
+        case 'j':
+            move = 1
+        case 'b':
+            move = -pageSize
+        case ' ':
+            move = pageSize
+        case 'g':
+            move = -1 << 30
+        case 'G':
+            move = 1 << 30
+        case 'h':
+            app.focus = max(paneFeeds, app.focus - 1)
+        case 'l':
+            app.focus = min(paneArticle, app.focus + 1)
+        case 'n', 'p':
+            delta := 1
+            if key.Rune == 'p' {
+                delta = -1
+            }
+            app.movePost(delta)
+            if post := app.selectedPost(); post != nil {
+                app.focus = paneArticle
+                app.setRead(post, true)
+            }
+        case 'm':
+            if post := app.selectedPost(); post != nil {
+                app.setRead(post, !post.ReadAt.Valid)
+            }
+        case 's':
+            if post := app.selectedPost(); post != nil {
+                app.toggleStar(post)
+            }
+        case 'o':
+            if post := app.selectedPost(); post != nil {
+                err := openURL(post.Url)
+                if err != nil {
+                    app.status = fmt.Sprintf("Failed to open browser: %v", err)
+                } else {
+                    app.setRead(post, true)
+                }
+            }
+        case 'u':
+            app.showAll = !app.showAll
+            err := app.loadPosts()
+            if err != nil {
+                app.status = err.Error()
+            }
+        case 'r':
+            app.refresh()
+            if app.status == "" {
+                app.status = "Refreshed"
+            }
+        }
+    }
+
+    if move != 0 {
+        switch app.focus {
+        case paneFeeds:
+            app.selectEntry(max(0, min(app.entry + move, len(app.entries) - 1)))
+        case panePosts:
+            app.movePost(move)
+        case paneArticle:
+            app.articleTop = max(0, min(app.articleTop + move, len(app.article) - app.paneHeight(paneArticle)))
+        }
+    }
+    return false
+}
+
+func (app *tuiApp) enter() {
+    switch app.focus {
+    case paneFeeds:
+        app.focus = panePosts
+    case panePosts:
+        if post := app.selectedPost(); post != nil {
+            app.focus = paneArticle
+            app.setRead(post, true)
+        }
+    case paneArticle:
+        if post := app.selectedPost(); post != nil {
+            err := openURL(post.Url)
+            if err != nil {
+                app.status = fmt.Sprintf("Failed to open browser: %v", err)
+            }
+        }
+    }
+}
+
+// layout splits the screen: a title row, the feed pane on the left, posts
+// above the article on the right, and a status row.
+func (app *tuiApp) layout() (feedsWidth, postsHeight int) {
+    feedsWidth = max(18, min(app.width / 4, 32))
+    middle := app.height - 2
+    postsHeight = max(3, middle * 2 / 5)
+    return feedsWidth, postsHeight
+}
+
+func (app *tuiApp) paneHeight(pane tuiPane) int {
+    _, postsHeight := app.layout()
+    middle := app.height - 2
+    switch pane {
+    case panePosts:
+        return postsHeight
+    case paneArticle:
+        // One row separates posts and article.
+        return max(1, middle - postsHeight - 1)
+    default:
+        return middle
+    }
+}
+
+// scrollTo keeps the selected row visible in a pane of height rows.
+func scrollTo(top, selected, height int) int {
+    if selected < top {
+        return selected
+    }
+    if selected >= top + height {
+        return selected - height + 1
+    }
+    return top
+}
+
+func (app *tuiApp) layoutArticle(width int) {
+    post := app.selectedPost()
+    if post == nil {
+        app.article = nil
+        app.articleID = uuid.Nil
+        return
+    }
+    if post.ID == app.articleID && width == app.articleWidth {
+        return
+    }
+    if post.ID != app.articleID {
+        app.articleTop = 0
+    }
+    app.articleID = post.ID
+    app.articleWidth = width
+
+    var lines []tui.Line
+    for _, line := range(tui.Wrap(post.Title, width)) {
+        lines = append(lines, tui.Line{{Text: line, Style: tui.Bold}})
+    }
+    meta := post.FeedTitle + " · " + post.PublishedAt.Format("Mon, 02 Jan 2006 15:04")
+    if post.Author.Valid && post.Author.String != "" {
+        meta += " · " + post.Author.String
+    }
+    for _, line := range(tui.Wrap(meta, width)) {
+        lines = append(lines, tui.Line{{Text: line, Style: tui.Dim}})
+    }
+    lines = append(lines, tui.Line{{Text: post.Url, Style: tui.Cyan}})
+    if len(post.Categories) > 0 {
+        lines = append(lines, tui.Line{{Text: "#" + strings.Join(post.Categories, " #"), Style: tui.Dim}})
+    }
+
+    paragraphs := plainParagraphs(post.Description.String)
+    if len(paragraphs) == 0 {
+        paragraphs = []string{"No content in the feed, press o to open the post in your browser."}
+    }
+    for _, paragraph := range(paragraphs) {
+        lines = append(lines, nil)
+        for _, line := range(tui.Wrap(paragraph, width)) {
+            lines = append(lines, tui.Line{{Text: line}})
+        }
+    }
+    app.article = lines
+}
+
+func (app *tuiApp) draw() error {
+    width, height, err := app.screen.Size()
+    if err != nil {
+        return err
+    }
+    return app.screen.Draw(app.render(width, height))
+}
+
+// render lays out the whole screen for a terminal of the given size.
+func (app *tuiApp) render(width, height int) []tui.Line {
+    app.width, app.height = width, height
+    if height < 6 || width < 40 {
+        return []tui.Line{{{Text: "Terminal too small"}}}
+    }
+
+    feedsWidth, postsHeight := app.layout()
+    rightWidth := width - feedsWidth - 1
+    middle := height - 2
+
+    // Feed pane.
+    app.entryTop = scrollTo(app.entryTop, app.entry, middle)
+    feedRows := make([]tui.Line, middle)
+    for y := range(feedRows) {
+        i := app.entryTop + y
+        if i >= len(app.entries) {
+            feedRows[y] = tui.Line{{Text: strings.Repeat(" ", feedsWidth)}}
+            continue
+        }
+        entry := app.entries[i]
+        count := ""
+        if entry.unread > 0 {
+            count = fmt.Sprintf(" %d", entry.unread)
+        }
+        label := tui.Pad(strings.Repeat("  ", entry.indent) + entry.label, feedsWidth - tui.Width(count))
+        style := tui.Style(0)
+        if entry.unread > 0 {
+            style = tui.Bold
+        }
+        if i == app.entry {
+            style |= app.selectionStyle(paneFeeds)
+        }
+        feedRows[y] = tui.Line{{Text: label + count, Style: style}}
+    }
+
+    // Post pane.
+    app.postTop = scrollTo(app.postTop, app.post, postsHeight)
+    postRows := make([]tui.Line, postsHeight)
+    singleFeed := app.entries[app.entry].params.FeedID.Valid
+    for y := range(postRows) {
+        i := app.postTop + y
+        if i >= len(app.posts) {
+            if i == 0 {
+                empty := "Nothing unread here, press u to show read posts too"
+                if app.showAll || app.entries[app.entry].params.StarredOnly {
+                    empty = "No posts here"
+                }
+                postRows[y] = tui.Line{{Text: empty, Style: tui.Dim}}
+            }
+            continue
+        }
+        post := app.posts[i]
+        flags := "  "
+        if !post.ReadAt.Valid {
+            flags = "● "
+        }
+        star := "  "
+        if post.StarredAt.Valid {
+            star = "★ "
+        }
+        prefix := flags + star + post.PublishedAt.Format("Jan 02") + "  "
+        if !singleFeed {
+            prefix += tui.Pad(post.FeedTitle, 16) + "  "
+        }
+        style := tui.Style(0)
+        if post.ReadAt.Valid {
+            style = tui.Dim
+        }
+        if i == app.post {
+            style = app.selectionStyle(panePosts)
+        }
+        postRows[y] = tui.Line{{Text: tui.Pad(prefix + post.Title, rightWidth), Style: style}}
+    }
+
+    // Article pane.
+    app.layoutArticle(rightWidth)
+    articleHeight := app.paneHeight(paneArticle)
+    app.articleTop = max(0, min(app.articleTop, len(app.article) - articleHeight))
+
+    // Compose rows.
+    view := "unread"
+    if app.showAll {
+        view = "all"
+    }
+    title := fmt.Sprintf(" gator · %s · %s (%s) · %d posts", app.user.Name, app.entries[app.entry].label, view, len(app.posts))
+    lines := []tui.Line{{{Text: tui.Pad(title, width), Style: tui.Reverse}}}
+
+    separator := tui.Span{Text: "│", Style: tui.Dim}
+    for y := 0; y < middle; y++ {
+        line := append(tui.Line{}, feedRows[y]...)
+        line = append(line, separator)
+        switch {
+        case y < postsHeight:
+            line = append(line, postRows[y]...)
+        case y == postsHeight:
+            rule := tui.Span{Text: strings.Repeat("─", rightWidth), Style: tui.Dim}
+            if app.focus == paneArticle {
+                rule.Style = tui.Yellow
+            }
+            line = append(line, rule)
+        default:
+            i := app.articleTop + y - postsHeight - 1
+            if i < len(app.article) {
+                line = append(line, app.article[i]...)
+            }
+        }
+        lines = append(lines, line)
+    }
+
+    status := app.status
+    if status == "" {
+        status = "j/k move  tab pane  enter read  n/p next  m read  s star  o open  u all  r refresh  q quit"
+    }
+    lines = append(lines, tui.Line{{Text: tui.Pad(" " + status, width), Style: tui.Reverse}})
+
+    return lines
+}
+
+func (app *tuiApp) selectionStyle(pane tuiPane) tui.Style {
+    if app.focus == pane {
+        return tui.Reverse
+    }
+    return tui.Underline
+}
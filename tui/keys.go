@@ -0,0 +1,120 @@
+package tui
+
+import (
+	"unicode/utf8"
+)
+
+// Code identifies a key that is not a plain character.
+type Code int
+
+const (
+    KeyRune Code = iota
+    KeyUp
+    KeyDown
+    KeyLeft
+    KeyRight
+    KeyPgUp
+    KeyPgDn
+    KeyHome
+    KeyEnd
+    KeyEnter
+    KeyTab
+    KeyBacktab
+    KeyEsc
+    KeyBackspace
+    KeyCtrlC
+)
+
+// Key is one key press: Rune is set when Code is KeyRune.
+type Key struct {
+	Code Code
+	Rune rune
+}
+
+var escapeKeys = map[string]Code{
+    "[A": KeyUp,
+    "[B": KeyDown,
+    "[C": KeyRight,
+    "[D": KeyLeft,
+    "OA": KeyUp,
+    "OB": KeyDown,
+    "OC": KeyRight,
+    "OD": KeyLeft,
+    "[H": KeyHome,
+    "[F": KeyEnd,
+    "OH": KeyHome,
+    "OF": KeyEnd,
+    "[1~": KeyHome,
+    "[4~": KeyEnd,
+    "[7~": KeyHome,
+    "[8~": KeyEnd,
+    "[5~": KeyPgUp,
+    "[6~": KeyPgDn,
+    "[Z": KeyBacktab,
+}
+
+func (s *Screen) readKeys() {
+    defer close(s.keys)
+
+    buf := make([]byte, 256)
+    for {
+        n, err := s.in.Read(buf)
+        if err != nil {
+            return
+        }
+        for _, key := range(parseKeys(buf[:n])) {
+            s.keys <- key
+        }
+    }
+}
+
+// parseKeys splits what one read returned into keys. A lone escape byte is
+// the Esc key; one followed by a known sequence is a cursor or paging key.
+func parseKeys(b []byte) []Key {
+    var keys []Key
+    for len(b) > 0 {
+        switch c := b[0]; {
+        case c == 0x1b:
+            if len(b) == 1 {
+                keys = append(keys, Key{Code: KeyEsc})
+                return keys
+            }
+            end := 2
+            // The sequence runs from its introducer, [ or O, to a final
+            // letter or tilde.
+            for end < len(b) && end < 8 && (end == 2 || !isFinal(b[end-1])) {
+                end++
+            }
+            if code, ok := escapeKeys[string(b[1:end])]; ok {
+                keys = append(keys, Key{Code: code})
+                b = b[end:]
+                continue
+            }
+            keys = append(keys, Key{Code: KeyEsc})
+            b = b[1:]
+        case c == '\r' || c == '\n':
+            keys = append(keys, Key{Code: KeyEnter})
+            b = b[1:]
+        case c == '\t':
+            keys = append(keys, Key{Code: KeyTab})
+            b = b[1:]
+        case c == 0x7f || c == 0x08:
+            keys = append(keys, Key{Code: KeyBackspace})
+            b = b[1:]
+        case c == 0x03:
+            keys = append(keys, Key{Code: KeyCtrlC})
+            b = b[1:]
+        case c < 0x20:
+            b = b[1:]
+        default:
+            r, size := utf8.DecodeRune(b)
+            keys = append(keys, Key{Code: KeyRune, Rune: r})
+            b = b[size:]
+        }
+    }
+    return keys
+}
+
+func isFinal(c byte) bool {
+    return c >= 'A' && c <= 'Z' || c == '~'
+}
@@ -0,0 +1,143 @@
+// Package tui draws full-screen terminal interfaces with plain ANSI escape
+// sequences and reads keys from a terminal in raw mode.
+package tui
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+
+	"golang.org/x/term"
+)
+
+// Style is a set of text attributes.
+type Style uint8
+
+const (
+    Bold Style = 1 << iota
+    Dim
+    Reverse
+    Underline
+    Yellow
+    Cyan
+)
+
+var styleCodes = []struct {
+	style Style
+	code  int
+}{
+    {Bold, 1},
+    {Dim, 2},
+    {Underline, 4},
+    {Reverse, 7},
+    {Yellow, 33},
+    {Cyan, 36},
+}
+
+func (s Style) sgr() string {
+    seq := "\x1b[0"
+    for _, c := range(styleCodes) {
+        if s&c.style != 0 {
+            seq += ";" + strconv.Itoa(c.code)
+        }
+    }
+    return seq + "m"
+}
+
+// Span is a run of text in one style.
+type Span struct {
+	Text  string
+	Style Style
+}
+
+// Line is one row of the screen.
+type Line []Span
+
+// Screen is the terminal, switched to its alternate screen and raw mode
+// until Close.
+type Screen struct {
+	in    *os.File
+	out   *os.File
+	state *term.State
+	keys  chan Key
+}
+
+// Open takes over the terminal on stdin and stdout.
+func Open() (*Screen, error) {
+    in, out := os.Stdin, os.Stdout
+    if !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+        return nil, errors.New("tui: stdin and stdout must be a terminal")
+    }
+
+    state, err := term.MakeRaw(int(in.Fd()))
+    if err != nil {
+        return nil, err
+    }
+
+    s := &Screen{
+        in: in,
+        out: out,
+        state: state,
+        keys: make(chan Key, 16),
+    }
+    // Alternate screen, cursor hidden.
+    _, err = out.WriteString("\x1b[?1049h\x1b[?25l")
+    if err != nil {
+        term.Restore(int(in.Fd()), state)
+        return nil, err
+    }
+
+    go s.readKeys()
+    return s, nil
+}
+
+// Close gives the terminal back as it was.
+func (s *Screen) Close() error {
+    s.out.WriteString("\x1b[0m\x1b[?25h\x1b[?1049l")
+    return term.Restore(int(s.in.Fd()), s.state)
+}
+
+// Size returns the terminal's width and height in cells.
+func (s *Screen) Size() (int, int, error) {
+    return term.GetSize(int(s.out.Fd()))
+}
+
+// Keys delivers key presses. It is closed when stdin is.
+func (s *Screen) Keys() <-chan Key {
+    return s.keys
+}
+
+// Draw replaces the whole screen with lines, cutting them at the right edge
+// and blanking the rows below them. Control characters in spans are left
+// out.
+func (s *Screen) Draw(lines []Line) error {
+    width, height, err := s.Size()
+    if err != nil {
+        return err
+    }
+
+    var buf bytes.Buffer
+    buf.WriteString("\x1b[H")
+    for y := 0; y < height; y++ {
+        if y > 0 {
+            buf.WriteString("\r\n")
+        }
+        left := width
+        if y < len(lines) {
+            for _, span := range(lines[y]) {
+                if left <= 0 {
+                    break
+                }
+                text := Truncate(Printable(span.Text), left)
+                left -= Width(text)
+                buf.WriteString(span.Style.sgr())
+                buf.WriteString(text)
+            }
+        }
+        buf.WriteString("\x1b[0m\x1b[K")
+    }
+
+    _, err = s.out.Write(buf.Bytes())
+    return err
+}
@@ -0,0 +1,121 @@
+package tui
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// RuneWidth is how many cells r takes up: two for wide East Asian
+// characters, none for combining marks and control characters.
+func RuneWidth(r rune) int {
+    if r < 0x20 || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) {
+        return 0
+    }
+    switch width.LookupRune(r).Kind() {
+    case width.EastAsianWide, width.EastAsianFullwidth:
+        return 2
+    }
+    return 1
+}
+
+// Printable drops control characters from s, including ESC, DEL and the C1
+// controls, so text from feeds cannot send escape sequences to the terminal.
+func Printable(s string) string {
+    return strings.Map(func(r rune) rune {
+        if unicode.IsControl(r) {
+            return -1
+        }
+        return r
+    }, s)
+}
+
+// Width is how many cells s takes up.
+func Width(s string) int {
+    n := 0
+    for _, r := range(s) {
+        n += RuneWidth(r)
+    }
+    return n
+}
+
+// Truncate cuts s to at most w cells, ending it with an ellipsis when
+// anything was cut.
+func Truncate(s string, w int) string {
+    if Width(s) <= w {
+        return s
+    }
+    if w <= 0 {
+        return ""
+    }
+
+    var b strings.Builder
+    used := 0
+    for _, r := range(s) {
+        rw := RuneWidth(r)
+        if used+rw > w-1 {
+            break
+        }
+        b.WriteRune(r)
+        used += rw
+    }
+    b.WriteRune('…')
+    return b.String()
+}
+
+// Pad truncates or fills s with spaces to exactly w cells.
+func Pad(s string, w int) string {
+    s = Truncate(s, w)
+    return s + strings.Repeat(" ", max(0, w-Width(s)))
+}
+
+// Wrap breaks text into lines of at most w cells at spaces, splitting words
+// longer than a line.
+func Wrap(text string, w int) []string {
+    if w < 1 {
+        return nil
+    }
+
+    var lines []string
+    var line strings.Builder
+    used := 0
+    for _, word := range(strings.Fields(text)) {
+        ww := Width(word)
+        if used > 0 && used+1+ww > w {
+            lines = append(lines, line.String())
+            line.Reset()
+            used = 0
+        }
+        if used > 0 {
+            line.WriteByte(' ')
+            used++
+        }
+        for ww > w-used {
+            // Only a word longer than a whole line gets here.
+            var head strings.Builder
+            hw := 0
+            rest := []rune(word)
+            i := 0
+            for ; i < len(rest) && hw+RuneWidth(rest[i]) <= w-used; i++ {
+                head.WriteRune(rest[i])
+                hw += RuneWidth(rest[i])
+            }
+            if i == 0 {
+                break
+            }
+            line.WriteString(head.String())
+            lines = append(lines, line.String())
+            line.Reset()
+            used = 0
+            word = string(rest[i:])
+            ww = Width(word)
+        }
+        line.WriteString(word)
+        used += ww
+    }
+    if used > 0 {
+        lines = append(lines, line.String())
+    }
+    return lines
+}
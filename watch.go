@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+	"github.com/zulkou/blog-aggregator/notify"
+)
+
+const (
+    notifierDesktop = "desktop"
+    notifierWebhook = "webhook"
+    notifierEmail   = "email"
+)
+
+var notifiers = []string{notifierDesktop, notifierWebhook, notifierEmail}
+
+// notifyTimeout bounds a single alert so a slow notifier cannot hold up the
+// ones queued behind it for long.
+const notifyTimeout = 15 * time.Second
+
+// alertQueueSize is how many alerts may wait to be sent before new matches
+// are recorded as failed instead.
+const alertQueueSize = 256
+
+// pendingAlert is a recorded watch match whose alert is yet to be sent.
+type pendingAlert struct {
+    watch   database.Watch
+    postID  uuid.UUID
+    msg     notify.Message
+    logger  *slog.Logger
+}
+
+var (
+    alertQueue      = make(chan pendingAlert, alertQueueSize)
+    startAlerts     sync.Once
+)
+
+// watchTerm is one word or quoted phrase of a watch query.
+type watchTerm struct {
+    re      *regexp.Regexp
+    negate  bool
+}
+
+// watchQuery is a parsed query: it matches when every term of any one of its
+// OR separated groups does.
+type watchQuery [][]watchTerm
+
+// parseWatchQuery understands words, "quoted phrases", -negated terms and
+// OR between groups, as in `postgres OR "sqlc generate" -mysql`. Terms match
+// case-insensitively on word boundaries.
+func parseWatchQuery(query string) (watchQuery, error) {
+    var groups watchQuery
+    var group []watchTerm
+
+    closeGroup := func() error {
+        if !slices.ContainsFunc(group, func(t watchTerm) bool { return !t.negate }) {
+            return errors.New("Every part of a watch query needs at least one term that is not negated")
+        }
+        groups = append(groups, group)
+        group = nil
+        return nil
+    }
+
+    rest := strings.TrimSpace(query)
+    for rest != "" {
+        negate := false
+        if strings.HasPrefix(rest, "-") {
+            negate = true
+            rest = rest[1:]
+        }
+
+        var term string
+        if strings.HasPrefix(rest, `"`) {
+            end := strings.Index(rest[1:], `"`)
+            if end < 0 {
+                return nil, errors.New("Unterminated quote in watch query")
+            }
+            term = rest[1:end + 1]
+            rest = rest[end + 2:]
+        } else {
+            end := strings.IndexFunc(rest, unicode.IsSpace)
+            if end < 0 {
+                end = len(rest)
+            }
+            term = rest[:end]
+            rest = rest[end:]
+        }
+        rest = strings.TrimSpace(rest)
+
+        if term == "OR" && !negate {
+            err := closeGroup()
+            if err != nil {
+                return nil, err
+            }
+            continue
+        }
+        if strings.TrimSpace(term) == "" {
+            continue
+        }
+
+        group = append(group, watchTerm{re: termRegexp(term), negate: negate})
+    }
+
+    err := closeGroup()
+    if err != nil {
+        return nil, err
+    }
+    return groups, nil
+}
+
+// termRegexp matches term case-insensitively, on word boundaries wherever
+// the term starts or ends with a word character, so "c++" still matches.
+func termRegexp(term string) *regexp.Regexp {
+    words := strings.Fields(term)
+    for i, word := range(words) {
+        words[i] = regexp.QuoteMeta(word)
+    }
+    pattern := strings.Join(words, `\s+`)
+
+    isWord := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+    if first, _ := utf8.DecodeRuneInString(term); isWord(first) {
+        pattern = `\b` + pattern
+    }
+    if last, _ := utf8.DecodeLastRuneInString(term); isWord(last) {
+        pattern += `\b`
+    }
+    return regexp.MustCompile(`(?i)` + pattern)
+}
+
+func (q watchQuery) matches(text string) bool {
+    for _, group := range(q) {
+        matched := true
+        for _, term := range(group) {
+            if term.re.MatchString(text) == term.negate {
+                matched = false
+                break
+            }
+        }
+        if matched {
+            return true
+        }
+    }
+    return false
+}
+
+// watchText is everything a watch query is matched against.
+func watchText(post database.Post) string {
+    parts := []string{post.Title, post.Description.String, post.Author.String}
+    parts = append(parts, post.Categories...)
+    return strings.Join(parts, "\n")
+}
+
+type compiledWatch struct {
+    database.Watch
+    query watchQuery
+}
+
+// compileWatches parses the watches that still parse, logging the others.
+func compileWatches(watches []database.Watch) []compiledWatch {
+    var compiled []compiledWatch
+    for _, watch := range(watches) {
+        query, err := parseWatchQuery(watch.Query)
+        if err != nil {
+            slog.Warn("Skipping invalid watch", "watch_id", watch.ID, "error", err)
+            continue
+        }
+        compiled = append(compiled, compiledWatch{Watch: watch, query: query})
+    }
+    return compiled
+}
+
+func newNotifier(s *state, watch database.Watch) (notify.Notifier, error) {
+    switch watch.Notifier {
+    case notifierDesktop:
+        return notify.Desktop{}, nil
+    case notifierWebhook:
+        return notify.Webhook{URL: watch.Target}, nil
+    case notifierEmail:
+        return notify.Email{Mailer: newMailer(s), To: watch.Target}, nil
+    default:
+        return nil, fmt.Errorf("Unknown notifier: %s", watch.Notifier)
+    }
+}
+
+func newMailer(s *state) notify.Mailer {
+    return notify.Mailer{
+        Host: s.cfg.SMTP.Host,
+        Port: s.cfg.SMTP.Port,
+        Username: s.cfg.SMTP.Username,
+        Password: s.cfg.SMTP.Password,
+        From: s.cfg.SMTP.From,
+    }
+}
+
+// checkWatches alerts the owner of every watch matching a newly stored post.
+// A match is recorded before alerting, so each post alerts a watch once even
+// if it is stored again later.
+func checkWatches(ctx context.Context, s *state, logger *slog.Logger, watches []compiledWatch, feed database.Feed, post database.Post) {
+    text := watchText(post)
+
+    for _, watch := range(watches) {
+        if !watch.query.matches(text) {
+            continue
+        }
+
+        recorded, err := s.db.RecordWatchMatch(ctx, database.RecordWatchMatchParams{
+            WatchID: watch.ID,
+            PostID: post.ID,
+            MatchedAt: time.Now(),
+        })
+        if err != nil {
+            dbErrors.WithLabelValues("RecordWatchMatch").Inc()
+            logger.Error("Failed to record watch match", "watch_id", watch.ID, "error", err)
+            continue
+        }
+        if recorded == 0 {
+            continue
+        }
+
+        queueAlert(s, pendingAlert{
+            watch: watch.Watch,
+            postID: post.ID,
+            msg: notify.Message{
+                Subject: fmt.Sprintf("gator: %q in %s", watch.Query, feed.Name),
+                Body: post.Title,
+                URL: post.Url,
+            },
+            logger: logger,
+        })
+    }
+}
+
+// queueAlert hands an alert to the sender, started on first use, so slow
+// notifiers never hold up storing posts. When the queue is full the alert is
+// dropped and the match records why.
+func queueAlert(s *state, alert pendingAlert) {
+    startAlerts.Do(func() { go runAlerts(s) })
+
+    select {
+    case alertQueue <- alert:
+    default:
+        recordAlertResult(s, alert, errors.New("Too many alerts waiting to be sent"))
+    }
+}
+
+func runAlerts(s *state) {
+    for alert := range(alertQueue) {
+        err := sendAlert(context.Background(), s, alert.watch, alert.msg)
+        recordAlertResult(s, alert, err)
+    }
+}
+
+func recordAlertResult(s *state, alert pendingAlert, err error) {
+    result := database.SetWatchMatchResultParams{
+        WatchID: alert.watch.ID,
+        PostID: alert.postID,
+    }
+    if err != nil {
+        alert.logger.Warn("Failed to send alert", "watch_id", alert.watch.ID, "notifier", alert.watch.Notifier, "error", err)
+        result.Error = sql.NullString{String: err.Error(), Valid: true}
+    } else {
+        result.NotifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+    }
+
+    err = s.db.SetWatchMatchResult(context.Background(), result)
+    if err != nil {
+        dbErrors.WithLabelValues("SetWatchMatchResult").Inc()
+        alert.logger.Error("Failed to record alert result", "watch_id", alert.watch.ID, "error", err)
+    }
+}
+
+func sendAlert(ctx context.Context, s *state, watch database.Watch, msg notify.Message) error {
+    notifier, err := newNotifier(s, watch)
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+    defer cancel()
+    return notifier.Notify(ctx, msg)
+}
+
+func handlerWatch(s *state, cmd command, user database.User) error {
+    if len(cmd.args) < 1 {
+        return errors.New("The watch command expects a subcommand: add, list, rm or test")
+    }
+
+    switch cmd.args[0] {
+    case "add":
+        return watchAdd(s, cmd.args[1:], user)
+    case "list":
+        return watchList(s, cmd.args[1:], user)
+    case "rm":
+        return watchRemove(s, cmd.args[1:], user)
+    case "test":
+        return watchTest(s, cmd.args[1:], user)
+    default:
+        return fmt.Errorf("Unknown watch subcommand: %s", cmd.args[0])
+    }
+}
+
+func watchAdd(s *state, args []string, user database.User) error {
+    if len(args) < 2 || len(args) > 3 {
+        return errors.New("The watch add command expects <query> <notifier> [target]")
+    }
+
+    query, notifier := args[0], args[1]
+    var target string
+    if len(args) == 3 {
+        target = args[2]
+    }
+
+    _, err := parseWatchQuery(query)
+    if err != nil {
+        return err
+    }
+
+    switch notifier {
+    case notifierDesktop:
+        if target != "" {
+            return errors.New("The desktop notifier takes no target")
+        }
+    case notifierWebhook:
+        parsed, err := url.Parse(target)
+        if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+            return errors.New("The webhook notifier expects an http or https URL as target")
+        }
+    case notifierEmail:
+        addr, err := mail.ParseAddress(target)
+        if err != nil {
+            return fmt.Errorf("The email notifier expects an address as target: %w", err)
+        }
+        target = addr.Address
+    default:
+        return fmt.Errorf("Unknown notifier %s, expected one of %s", notifier, strings.Join(notifiers, ", "))
+    }
+
+    watch, err := s.db.CreateWatch(context.Background(), database.CreateWatchParams{
+        ID: uuid.New(),
+        CreatedAt: time.Now(),
+        UserID: user.ID,
+        Query: query,
+        Notifier: notifier,
+        Target: target,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to store watch: %w", err)
+    }
+
+    fmt.Printf("Watch %s added: %q via %s\n", watch.ID, watch.Query, describeNotifier(watch))
+    return nil
+}
+
+func describeNotifier(watch database.Watch) string {
+    if watch.Target == "" {
+        return watch.Notifier
+    }
+    return watch.Notifier + " " + watch.Target
+}
+
+func watchList(s *state, args []string, user database.User) error {
+    if len(args) != 0 {
+        return errors.New("The watch list command expects ZERO arguments")
+    }
+
+    watches, err := s.db.GetWatchesForUser(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to fetch watches: %w", err)
+    }
+
+    counts, err := s.db.GetWatchMatchCounts(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to fetch watch matches: %w", err)
+    }
+    countByWatch := make(map[uuid.UUID]database.GetWatchMatchCountsRow, len(counts))
+    for _, count := range(counts) {
+        countByWatch[count.WatchID] = count
+    }
+
+    for _, watch := range(watches) {
+        count := countByWatch[watch.ID]
+        fmt.Printf("---\nID: %s\nQuery: %s\nNotifier: %s\nMatches: %d (%d alerted)\n",
+            watch.ID, watch.Query, describeNotifier(watch), count.Matches, count.Notified)
+    }
+
+    return nil
+}
+
+func watchRemove(s *state, args []string, user database.User) error {
+    if len(args) != 1 {
+        return errors.New("The watch rm command expects ONE argument")
+    }
+
+    id, err := uuid.Parse(args[0])
+    if err != nil {
+        return fmt.Errorf("Invalid watch ID: %w", err)
+    }
+
+    removed, err := s.db.DeleteWatch(context.Background(), database.DeleteWatchParams{
+        ID: id,
+        UserID: user.ID,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to remove watch: %w", err)
+    }
+    if removed == 0 {
+        return fmt.Errorf("No watch with ID %s", id)
+    }
+
+    fmt.Printf("Watch %s removed\n", id)
+    return nil
+}
+
+// watchTest sends a sample alert through a watch's notifier.
+func watchTest(s *state, args []string, user database.User) error {
+    if len(args) != 1 {
+        return errors.New("The watch test command expects ONE argument")
+    }
+
+    id, err := uuid.Parse(args[0])
+    if err != nil {
+        return fmt.Errorf("Invalid watch ID: %w", err)
+    }
+
+    watches, err := s.db.GetWatchesForUser(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to fetch watches: %w", err)
+    }
+    i := slices.IndexFunc(watches, func(w database.Watch) bool { return w.ID == id })
+    if i < 0 {
+        return fmt.Errorf("No watch with ID %s", id)
+    }
+    watch := watches[i]
+
+    err = sendAlert(context.Background(), s, watch, notify.Message{
+        Subject: fmt.Sprintf("gator: test alert for %q", watch.Query),
+        Body: "This is how matching posts will be announced.",
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to send test alert: %w", err)
+    }
+
+    fmt.Printf("Test alert sent via %s\n", describeNotifier(watch))
+    return nil
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+var webTemplates = htmltemplate.Must(htmltemplate.New("").Funcs(templateFuncs).ParseFS(webAssets, "web/templates/*.html"))
+
+const (
+    sessionCookie = "gator_session"
+
+    webPageSize = 30
+    webSummaryLength = 300
+)
+
+// webSession is a logged in browser. Its token also keys the CSRF token of
+// every form the user is shown.
+type webSession struct {
+    user    database.User
+    token   string
+}
+
+func (sess webSession) csrfToken() string {
+    mac := hmac.New(sha256.New, []byte(sess.token))
+    mac.Write([]byte("csrf"))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+type webNavFeed struct {
+    ID      uuid.UUID
+    Title   string
+    URL     string
+    Unread  int64
+    Current bool
+}
+
+type webFolder struct {
+    Name    string
+    URL     string
+    Unread  int64
+    Current bool
+    Feeds   []webNavFeed
+}
+
+// webNav is the sidebar: followed feeds grouped by folder with their unread
+// counts.
+type webNav struct {
+    Unread  int64
+    Folders []webFolder
+    Unfiled []webNavFeed
+}
+
+type webPost struct {
+    ID          uuid.UUID
+    Title       string
+    URL         string
+    Feed        string
+    FeedURL     string
+    PublishedAt time.Time
+    Author      string
+    Summary     string
+    Read        bool
+    Starred     bool
+}
+
+type webFeed struct {
+    ID      uuid.UUID
+    Name    string
+    URL     string
+}
+
+// webPage is what every page template is rendered with; each page uses the
+// fields it needs.
+type webPage struct {
+    Title   string
+    User    string
+    CSRF    string
+    Error   string
+    // Path is the page's own path, RequestURI is it with its query so forms
+    // can come back to it.
+    Path        string
+    RequestURI  string
+    Nav         webNav
+
+    Posts       []webPost
+    ShowAll     bool
+    UnreadURL   string
+    AllURL      string
+    PrevURL     string
+    NextURL     string
+
+    Following   []webFeed
+    Available   []webFeed
+
+    Next    string
+}
+
+// registerWebRoutes serves the reading UI for people who would rather not
+// use the terminal. It signs in with the same accounts and sessions as the
+// CLI, but only accounts with a password can use it.
+func registerWebRoutes(mux *http.ServeMux, s *state) {
+    static, _ := fs.Sub(webAssets, "web/static")
+    mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServerFS(static)))
+
+    mux.HandleFunc("GET /login", func(w http.ResponseWriter, r *http.Request) { webLoginForm(w, r, "", http.StatusOK) })
+    mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) { webLogin(s, w, r) })
+    mux.HandleFunc("POST /logout", webLoggedIn(s, webLogout))
+
+    mux.HandleFunc("GET /{$}", webLoggedIn(s, webRiver))
+    mux.HandleFunc("GET /starred", webLoggedIn(s, webStarred))
+    mux.HandleFunc("GET /feed/{feedID}", webLoggedIn(s, webFeedPosts))
+    mux.HandleFunc("GET /folder/{folder}", webLoggedIn(s, webFolderPosts))
+    mux.HandleFunc("POST /posts/{postID}/read", webLoggedIn(s, webSetRead))
+    mux.HandleFunc("POST /posts/{postID}/star", webLoggedIn(s, webSetStarred))
+
+    mux.HandleFunc("GET /subscriptions", webLoggedIn(s, webSubscriptions))
+    mux.HandleFunc("POST /subscriptions", webLoggedIn(s, webSubscribe))
+    mux.HandleFunc("POST /subscriptions/{feedID}/delete", webLoggedIn(s, webUnsubscribe))
+}
+
+// webLoggedIn is the browser counterpart of apiLoggedIn. Pages redirect to
+// the login form when there is no session; form posts must also carry the
+// session's CSRF token.
+func webLoggedIn(s *state, handler func(s *state, w http.ResponseWriter, r *http.Request, sess webSession)) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var sess webSession
+        cookie, err := r.Cookie(sessionCookie)
+        if err == nil {
+            sess.token = cookie.Value
+            sess.user, err = s.db.GetUserBySession(r.Context(), database.GetUserBySessionParams{
+                TokenHash: hashToken(cookie.Value),
+                ExpiresAt: time.Now(),
+            })
+        }
+        if err != nil {
+            if !errors.Is(err, http.ErrNoCookie) && !errors.Is(err, sql.ErrNoRows) {
+                webError(w, err)
+                return
+            }
+            if r.Method != http.MethodGet {
+                http.Error(w, "Not logged in", http.StatusUnauthorized)
+                return
+            }
+            http.Redirect(w, r, "/login?next=" + url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+            return
+        }
+
+        if r.Method != http.MethodGet && !hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(sess.csrfToken())) {
+            http.Error(w, "Invalid form token, reload the page and try again", http.StatusForbidden)
+            return
+        }
+
+        handler(s, w, r, sess)
+    }
+}
+
+func webError(w http.ResponseWriter, err error) {
+    slog.Error("Web request failed", "error", err)
+    http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+func renderWeb(w http.ResponseWriter, status int, name string, data webPage) {
+    var buf bytes.Buffer
+    err := webTemplates.ExecuteTemplate(&buf, name, data)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.WriteHeader(status)
+    w.Write(buf.Bytes())
+}
+
+// localPath returns next when it is a path on this site, so login and form
+// redirects cannot be pointed elsewhere, and fallback otherwise.
+func localPath(next, fallback string) string {
+    if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+        return fallback
+    }
+    return next
+}
+
+func isHTTPS(r *http.Request) bool {
+    return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func webLoginForm(w http.ResponseWriter, r *http.Request, errMsg string, status int) {
+    renderWeb(w, status, "login.html", webPage{
+        Title: "Log in",
+        Error: errMsg,
+        Next: localPath(r.FormValue("next"), "/"),
+    })
+}
+
+func webLogin(s *state, w http.ResponseWriter, r *http.Request) {
+    user, err := s.db.GetUserByName(r.Context(), r.PostFormValue("name"))
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        webError(w, err)
+        return
+    }
+    // checkPassword lets accounts without a password in, which is fine for a
+    // local CLI but not for a server anyone can reach.
+    if err != nil || !user.PasswordHash.Valid || checkPassword(user, r.PostFormValue("password")) != nil {
+        webLoginForm(w, r, "Invalid username or password. Accounts need a password, set with the passwd command, to log in here.", http.StatusUnauthorized)
+        return
+    }
+
+    token, err := createSession(r.Context(), s, user)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name: sessionCookie,
+        Value: token,
+        Path: "/",
+        Expires: time.Now().Add(sessionTTL),
+        HttpOnly: true,
+        Secure: isHTTPS(r),
+        SameSite: http.SameSiteLaxMode,
+    })
+    http.Redirect(w, r, localPath(r.PostFormValue("next"), "/"), http.StatusSeeOther)
+}
+
+func webLogout(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    err := s.db.DeleteSession(r.Context(), hashToken(sess.token))
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name: sessionCookie,
+        Path: "/",
+        MaxAge: -1,
+        HttpOnly: true,
+        Secure: isHTTPS(r),
+        SameSite: http.SameSiteLaxMode,
+    })
+    http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// newWebPage fills in what every logged in page shows.
+func newWebPage(s *state, r *http.Request, sess webSession, title string) (webPage, error) {
+    page := webPage{
+        Title: title,
+        User: sess.user.Name,
+        CSRF: sess.csrfToken(),
+        Path: r.URL.Path,
+        RequestURI: r.URL.RequestURI(),
+    }
+
+    nav, err := loadNav(r.Context(), s, sess.user.ID)
+    if err != nil {
+        return webPage{}, err
+    }
+    for i := range(nav.Folders) {
+        folder := &nav.Folders[i]
+        folder.Current = folder.URL == r.URL.EscapedPath()
+        for j := range(folder.Feeds) {
+            folder.Feeds[j].Current = folder.Feeds[j].URL == page.Path
+        }
+    }
+    for i := range(nav.Unfiled) {
+        nav.Unfiled[i].Current = nav.Unfiled[i].URL == page.Path
+    }
+    page.Nav = nav
+
+    return page, nil
+}
+
+// loadNav groups a user's followed feeds by folder with their unread counts.
+// A feed filed in several folders is listed under each.
+func loadNav(ctx context.Context, s *state, userID uuid.UUID) (webNav, error) {
+    var nav webNav
+
+    follows, err := s.db.GetFeedFollowsWithFolders(ctx, userID)
+    if err != nil {
+        return webNav{}, err
+    }
+    counts, err := s.db.GetUnreadCounts(ctx, userID)
+    if err != nil {
+        return webNav{}, err
+    }
+    unread := make(map[uuid.UUID]int64, len(counts))
+    for _, count := range(counts) {
+        unread[count.FeedID] = count.Unread
+        nav.Unread += count.Unread
+    }
+
+    // Rows come sorted by folder, unfiled feeds last.
+    for _, follow := range(follows) {
+        title := follow.FeedName
+        if follow.Title.Valid {
+            title = follow.Title.String
+        }
+        feed := webNavFeed{
+            ID: follow.FeedID,
+            Title: title,
+            URL: "/feed/" + follow.FeedID.String(),
+            Unread: unread[follow.FeedID],
+        }
+
+        if !follow.Folder.Valid {
+            nav.Unfiled = append(nav.Unfiled, feed)
+            continue
+        }
+        if len(nav.Folders) == 0 || nav.Folders[len(nav.Folders) - 1].Name != follow.Folder.String {
+            nav.Folders = append(nav.Folders, webFolder{
+                Name: follow.Folder.String,
+                URL: "/folder/" + url.PathEscape(follow.Folder.String),
+            })
+        }
+        folder := &nav.Folders[len(nav.Folders) - 1]
+        folder.Feeds = append(folder.Feeds, feed)
+        folder.Unread += feed.Unread
+    }
+
+    return nav, nil
+}
+
+func webRiver(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    serveRiver(s, w, r, sess, "All posts", database.GetRiverPostsParams{})
+}
+
+func webStarred(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    serveRiver(s, w, r, sess, "Starred", database.GetRiverPostsParams{StarredOnly: true})
+}
+
+func webFeedPosts(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    feedID, err := uuid.Parse(r.PathValue("feedID"))
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+
+    follow, err := s.db.GetFeedFollow(r.Context(), database.GetFeedFollowParams{
+        UserID: sess.user.ID,
+        FeedID: feedID,
+    })
+    if errors.Is(err, sql.ErrNoRows) {
+        http.NotFound(w, r)
+        return
+    } else if err != nil {
+        webError(w, err)
+        return
+    }
+
+    title := follow.Title.String
+    if !follow.Title.Valid {
+        feed, err := s.db.GetFeedByID(r.Context(), feedID)
+        if err != nil {
+            webError(w, err)
+            return
+        }
+        title = feed.Name
+    }
+
+    serveRiver(s, w, r, sess, title, database.GetRiverPostsParams{
+        FeedID: uuid.NullUUID{UUID: feedID, Valid: true},
+    })
+}
+
+func webFolderPosts(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    folder := r.PathValue("folder")
+    serveRiver(s, w, r, sess, folder, database.GetRiverPostsParams{
+        Folder: sql.NullString{String: folder, Valid: true},
+    })
+}
+
+// serveRiver renders a page of posts. Unread posts are shown unless the
+// query asks for ?show=all; the starred view always shows everything.
+func serveRiver(s *state, w http.ResponseWriter, r *http.Request, sess webSession, title string, params database.GetRiverPostsParams) {
+    page, err := newWebPage(s, r, sess, title)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    query := r.URL.Query()
+    pageNum, err := strconv.Atoi(query.Get("page"))
+    if err != nil || pageNum < 1 {
+        pageNum = 1
+    }
+    page.ShowAll = params.StarredOnly || query.Get("show") == "all"
+
+    params.UserID = sess.user.ID
+    params.UnreadOnly = !page.ShowAll
+    // One more than a page tells whether there is a next one.
+    params.PageLimit = webPageSize + 1
+    params.PageOffset = int32((pageNum - 1) * webPageSize)
+
+    rows, err := s.db.GetRiverPosts(r.Context(), params)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+    hasNext := len(rows) > webPageSize
+    if hasNext {
+        rows = rows[:webPageSize]
+    }
+
+    rows, err = dropHiddenRiver(s, sess.user, rows)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    for _, row := range(rows) {
+        page.Posts = append(page.Posts, webPost{
+            ID: row.ID,
+            Title: row.Title,
+            URL: row.Url,
+            Feed: row.FeedTitle,
+            FeedURL: "/feed/" + row.FeedID.String(),
+            PublishedAt: row.PublishedAt,
+            Author: row.Author.String,
+            Summary: truncate(plainText(row.Description.String), webSummaryLength),
+            Read: row.ReadAt.Valid,
+            Starred: row.StarredAt.Valid,
+        })
+    }
+
+    pageURL := func(showAll bool, num int) string {
+        values := url.Values{}
+        if showAll && !params.StarredOnly {
+            values.Set("show", "all")
+        }
+        if num > 1 {
+            values.Set("page", strconv.Itoa(num))
+        }
+        if len(values) == 0 {
+            return r.URL.Path
+        }
+        return r.URL.Path + "?" + values.Encode()
+    }
+    page.UnreadURL = pageURL(false, 1)
+    page.AllURL = pageURL(true, 1)
+    if pageNum > 1 {
+        page.PrevURL = pageURL(page.ShowAll, pageNum - 1)
+    }
+    if hasNext {
+        page.NextURL = pageURL(page.ShowAll, pageNum + 1)
+    }
+
+    renderWeb(w, http.StatusOK, "river.html", page)
+}
+
+// finishToggle answers the keyboard shortcuts' background requests with no
+// content, and plain form posts with a redirect back to the page.
+func finishToggle(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("X-Gator-Fetch") != "" {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    http.Redirect(w, r, localPath(r.PostFormValue("next"), "/"), http.StatusSeeOther)
+}
+
+func toggleTime(r *http.Request, field string) sql.NullTime {
+    if r.PostFormValue(field) == "1" {
+        return sql.NullTime{Time: time.Now(), Valid: true}
+    }
+    return sql.NullTime{}
+}
+
+func webSetRead(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    postID, err := uuid.Parse(r.PathValue("postID"))
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+
+    err = s.db.SetPostRead(r.Context(), database.SetPostReadParams{
+        UserID: sess.user.ID,
+        PostID: postID,
+        ReadAt: toggleTime(r, "read"),
+    })
+    if err != nil {
+        webError(w, err)
+        return
+    }
+    finishToggle(w, r)
+}
+
+func webSetStarred(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    postID, err := uuid.Parse(r.PathValue("postID"))
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+
+    err = s.db.SetPostStarred(r.Context(), database.SetPostStarredParams{
+        UserID: sess.user.ID,
+        PostID: postID,
+        StarredAt: toggleTime(r, "starred"),
+    })
+    if err != nil {
+        webError(w, err)
+        return
+    }
+    finishToggle(w, r)
+}
+
+func webSubscriptions(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    renderSubscriptions(s, w, r, sess, "", http.StatusOK)
+}
+
+// renderSubscriptions lists the feeds the user follows and the other feeds
+// they could, with errMsg from a failed subscribe if any.
+func renderSubscriptions(s *state, w http.ResponseWriter, r *http.Request, sess webSession, errMsg string, status int) {
+    page, err := newWebPage(s, r, sess, "Subscriptions")
+    if err != nil {
+        webError(w, err)
+        return
+    }
+    page.Error = errMsg
+
+    following, err := followedFeeds(s, sess.user)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+    feeds, err := s.db.GetFeeds(r.Context())
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    for _, feed := range(feeds) {
+        item := webFeed{ID: feed.ID, Name: feed.Name, URL: feed.Url}
+        if follow, ok := following[feed.ID]; ok {
+            if follow.Title.Valid {
+                item.Name = follow.Title.String
+            }
+            page.Following = append(page.Following, item)
+        } else {
+            page.Available = append(page.Available, item)
+        }
+    }
+
+    renderWeb(w, status, "subscriptions.html", page)
+}
+
+// webSubscribe follows a feed given by the id of a listed feed or by URL.
+func webSubscribe(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    var feed database.Feed
+    var err error
+    if value := r.PostFormValue("feed_id"); value != "" {
+        feedID, parseErr := uuid.Parse(value)
+        if parseErr != nil {
+            http.Error(w, "Invalid feed ID", http.StatusBadRequest)
+            return
+        }
+        feed, err = s.db.GetFeedByID(r.Context(), feedID)
+    } else {
+        feed, err = s.db.GetFeedByURL(r.Context(), strings.TrimSpace(r.PostFormValue("feed_url")))
+    }
+    if errors.Is(err, sql.ErrNoRows) {
+        renderSubscriptions(s, w, r, sess, "No feed with that URL. Feeds are added with the addfeed command.", http.StatusNotFound)
+        return
+    } else if err != nil {
+        webError(w, err)
+        return
+    }
+
+    _, err = s.db.CreateFeedFollow(r.Context(), database.CreateFeedFollowParams{
+        ID: uuid.New(),
+        CreatedAt: time.Now(),
+        UpdatedAt: time.Now(),
+        UserID: sess.user.ID,
+        FeedID: feed.ID,
+    })
+    var pqErr *pq.Error
+    if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+        renderSubscriptions(s, w, r, sess, "You already follow " + feed.Name + ".", http.StatusConflict)
+        return
+    } else if err != nil {
+        webError(w, err)
+        return
+    }
+
+    http.Redirect(w, r, "/subscriptions", http.StatusSeeOther)
+}
+
+func webUnsubscribe(s *state, w http.ResponseWriter, r *http.Request, sess webSession) {
+    feedID, err := uuid.Parse(r.PathValue("feedID"))
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+
+    _, err = s.db.DeleteFeedFollow(r.Context(), database.DeleteFeedFollowParams{
+        UserID: sess.user.ID,
+        FeedID: feedID,
+    })
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    http.Redirect(w, r, "/subscriptions", http.StatusSeeOther)
+}
@@ -0,0 +1,92 @@
+// Keyboard shortcuts and in-place toggles for the river. Every action is
+// also a plain form, so the pages work with scripts turned off.
+(function () {
+    "use strict";
+
+    var posts = Array.prototype.slice.call(document.querySelectorAll("article.post"));
+    var current = -1;
+
+    function select(i) {
+        if (i < 0 || i >= posts.length) {
+            return;
+        }
+        if (current >= 0) {
+            posts[current].classList.remove("selected");
+        }
+        current = i;
+        posts[current].classList.add("selected");
+        posts[current].scrollIntoView({ block: "nearest" });
+    }
+
+    // toggle submits form in the background and flips its post's state.
+    function toggle(form) {
+        var article = form.closest("article.post");
+        var input = form.querySelector("input[name=read], input[name=starred]");
+        var button = form.querySelector("button");
+        fetch(form.action, {
+            method: "POST",
+            body: new FormData(form),
+            headers: { "X-Gator-Fetch": "1" },
+            credentials: "same-origin"
+        }).then(function (res) {
+            if (!res.ok) {
+                throw new Error(res.status + " " + res.statusText);
+            }
+            var on = input.value === "1";
+            article.classList.toggle(form.dataset.class, on);
+            input.value = on ? "0" : "1";
+            button.textContent = on ? button.dataset.on : button.dataset.off;
+        }).catch(function () {
+            form.submit();
+        });
+    }
+
+    document.querySelectorAll("form.toggle").forEach(function (form) {
+        form.addEventListener("submit", function (e) {
+            e.preventDefault();
+            toggle(form);
+        });
+    });
+
+    posts.forEach(function (post, i) {
+        post.addEventListener("click", function () {
+            select(i);
+        });
+    });
+
+    document.addEventListener("keydown", function (e) {
+        if (e.ctrlKey || e.metaKey || e.altKey) {
+            return;
+        }
+        var tag = e.target.tagName;
+        if (tag === "INPUT" || tag === "TEXTAREA" || tag === "SELECT" || e.target.isContentEditable) {
+            return;
+        }
+
+        switch (e.key) {
+        case "j":
+            select(current + 1);
+            break;
+        case "k":
+            select(current < 0 ? 0 : current - 1);
+            break;
+        case "o":
+            if (current >= 0) {
+                window.open(posts[current].dataset.url, "_blank", "noopener");
+            }
+            break;
+        case "m":
+        case "s":
+            if (current >= 0) {
+                var form = posts[current].querySelector("form.toggle[data-key=" + e.key + "]");
+                if (form) {
+                    toggle(form);
+                }
+            }
+            break;
+        default:
+            return;
+        }
+        e.preventDefault();
+    });
+})();
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+)
+
+const (
+    webhookPollInterval = 5 * time.Second
+    webhookBatchSize    = 20
+    webhookTimeout      = 10 * time.Second
+    webhookMaxAttempts  = 10
+    webhookBaseBackoff  = 30 * time.Second
+    webhookMaxBackoff   = 6 * time.Hour
+
+    // webhookLease keeps a claimed delivery from being picked up again
+    // while it is in flight. It must outlast a batch of timed out calls.
+    webhookLease = webhookBatchSize * webhookTimeout + time.Minute
+
+    webhookSignatureHeader = "X-Gator-Signature-256"
+    webhookSecretPrefix    = "whsec_"
+    webhookLogLimit        = 20
+)
+
+type webhookFeed struct {
+    ID      uuid.UUID   `json:"id"`
+    Name    string      `json:"name"`
+    URL     string      `json:"url"`
+}
+
+type webhookPayload struct {
+    Event       string      `json:"event"`
+    DeliveryID  uuid.UUID   `json:"delivery_id"`
+    Post        apiPost     `json:"post"`
+    Feed        webhookFeed `json:"feed"`
+}
+
+func generateWebhookSecret() (string, error) {
+    buf := make([]byte, 24)
+    _, err := rand.Read(buf)
+    if err != nil {
+        return "", fmt.Errorf("Failed to generate webhook secret: %w", err)
+    }
+    return webhookSecretPrefix + hex.EncodeToString(buf), nil
+}
+
+// signWebhook returns the signature header value for body, in the
+// "sha256=<hex hmac>" form receivers of GitHub webhooks already verify.
+func signWebhook(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the wait before the next try after attempts failures.
+func webhookBackoff(attempts int32) time.Duration {
+    backoff := webhookBaseBackoff
+    for i := int32(1); i < attempts && backoff < webhookMaxBackoff; i++ {
+        backoff *= 2
+    }
+    return min(backoff, webhookMaxBackoff)
+}
+
+// enqueueWebhooks queues a delivery of post to every interested webhook.
+// Sending happens in runWebhookDeliveries so a slow receiver never holds up
+// scraping.
+func enqueueWebhooks(ctx context.Context, s *state, post database.Post) error {
+    _, err := s.db.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+        Now: time.Now(),
+        PostID: post.ID,
+        FeedID: post.FeedID,
+    })
+    if err != nil {
+        dbErrors.WithLabelValues("EnqueueWebhookDeliveries").Inc()
+        return fmt.Errorf("Failed to queue webhook deliveries: %w", err)
+    }
+    return nil
+}
+
+// runWebhookDeliveries sends queued deliveries for as long as the process
+// runs.
+func runWebhookDeliveries(s *state) {
+    client := &http.Client{Timeout: webhookTimeout}
+
+    ticker := time.NewTicker(webhookPollInterval)
+    for ; ; <-ticker.C {
+        // Keep going while full batches come back, then wait for more.
+        for {
+            sent, err := deliverWebhooks(s, client)
+            if err != nil {
+                slog.Error("Failed to deliver webhooks", "error", err)
+                break
+            }
+            if sent < webhookBatchSize {
+                break
+            }
+        }
+    }
+}
+
+func deliverWebhooks(s *state, client *http.Client) (int, error) {
+    now := time.Now()
+    deliveries, err := s.db.ClaimWebhookDeliveries(context.Background(), database.ClaimWebhookDeliveriesParams{
+        LeaseUntil: now.Add(webhookLease),
+        Now: now,
+        BatchSize: webhookBatchSize,
+    })
+    if err != nil {
+        dbErrors.WithLabelValues("ClaimWebhookDeliveries").Inc()
+        return 0, fmt.Errorf("Failed to claim webhook deliveries: %w", err)
+    }
+
+    for _, delivery := range(deliveries) {
+        deliverWebhook(s, client, delivery)
+    }
+    return len(deliveries), nil
+}
+
+// deliverWebhook makes one attempt at a delivery, logs it and schedules the
+// next try or gives up.
+func deliverWebhook(s *state, client *http.Client, delivery database.WebhookDelivery) {
+    logger := slog.With("webhook_id", delivery.WebhookID, "delivery_id", delivery.ID)
+
+    payload, err := s.db.GetWebhookDeliveryPayload(context.Background(), delivery.ID)
+    if err != nil {
+        dbErrors.WithLabelValues("GetWebhookDeliveryPayload").Inc()
+        logger.Error("Failed to load webhook payload", "error", err)
+        return
+    }
+
+    start := time.Now()
+    status, err := postWebhook(client, delivery, payload)
+    attempt := database.RecordWebhookAttemptParams{
+        ID: uuid.New(),
+        DeliveryID: delivery.ID,
+        AttemptedAt: start,
+        DurationMs: int32(time.Since(start).Milliseconds()),
+    }
+    if status != 0 {
+        attempt.StatusCode = sql.NullInt32{Int32: int32(status), Valid: true}
+    }
+
+    finish := database.FinishWebhookDeliveryParams{
+        ID: delivery.ID,
+        Attempts: delivery.Attempts + 1,
+        NextAttemptAt: time.Now(),
+    }
+    switch {
+    case err == nil:
+        finish.DeliveredAt = sql.NullTime{Time: time.Now(), Valid: true}
+        webhookDeliveries.WithLabelValues("delivered").Inc()
+        logger.Debug("Webhook delivered", "status", status)
+    case finish.Attempts >= webhookMaxAttempts:
+        attempt.Error = sql.NullString{String: err.Error(), Valid: true}
+        finish.FailedAt = sql.NullTime{Time: time.Now(), Valid: true}
+        webhookDeliveries.WithLabelValues("failed").Inc()
+        logger.Warn("Giving up on webhook delivery", "attempts", finish.Attempts, "error", err)
+    default:
+        attempt.Error = sql.NullString{String: err.Error(), Valid: true}
+        finish.NextAttemptAt = time.Now().Add(webhookBackoff(finish.Attempts))
+        webhookDeliveries.WithLabelValues("retry").Inc()
+        logger.Info("Webhook delivery failed, will retry", "attempts", finish.Attempts, "next_attempt", finish.NextAttemptAt, "error", err)
+    }
+
+    err = s.db.RecordWebhookAttempt(context.Background(), attempt)
+    if err != nil {
+        dbErrors.WithLabelValues("RecordWebhookAttempt").Inc()
+        logger.Error("Failed to log webhook attempt", "error", err)
+    }
+    err = s.db.FinishWebhookDelivery(context.Background(), finish)
+    if err != nil {
+        dbErrors.WithLabelValues("FinishWebhookDelivery").Inc()
+        logger.Error("Failed to update webhook delivery", "error", err)
+    }
+}
+
+// postWebhook sends the signed payload and returns the response status, or
+// zero when no response came back.
+func postWebhook(client *http.Client, delivery database.WebhookDelivery, payload database.GetWebhookDeliveryPayloadRow) (int, error) {
+    body, err := json.Marshal(webhookPayload{
+        Event: "post.created",
+        DeliveryID: delivery.ID,
+        Post: toAPIPost(database.Post{
+            ID: payload.PostID,
+            Title: payload.Title,
+            Url: payload.Url,
+            Description: payload.Description,
+            PublishedAt: payload.PublishedAt,
+            FeedID: payload.FeedID,
+            Author: payload.Author,
+            Categories: payload.Categories,
+        }),
+        Feed: webhookFeed{
+            ID: payload.FeedID,
+            Name: payload.FeedName,
+            URL: payload.FeedUrl,
+        },
+    })
+    if err != nil {
+        return 0, fmt.Errorf("Failed to encode payload: %w", err)
+    }
+
+    req, err := http.NewRequest(http.MethodPost, payload.WebhookUrl, bytes.NewReader(body))
+    if err != nil {
+        return 0, fmt.Errorf("Failed to create request: %w", err)
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "gator-webhooks")
+    req.Header.Set("X-Gator-Event", "post.created")
+    req.Header.Set("X-Gator-Delivery", delivery.ID.String())
+    req.Header.Set(webhookSignatureHeader, signWebhook(payload.Secret, body))
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 1 << 16))
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode, fmt.Errorf("Receiver returned %s", resp.Status)
+    }
+    return resp.StatusCode, nil
+}
+
+func handlerWebhook(s *state, cmd command, user database.User) error {
+    if len(cmd.args) < 1 {
+        return errors.New("The webhook command expects a subcommand: add, list, rm or log")
+    }
+
+    switch cmd.args[0] {
+    case "add":
+        return webhookAdd(s, cmd.args[1:], user)
+    case "list":
+        return webhookList(s, cmd.args[1:], user)
+    case "rm":
+        return webhookRemove(s, cmd.args[1:], user)
+    case "log":
+        return webhookLog(s, cmd.args[1:], user)
+    default:
+        return fmt.Errorf("Unknown webhook subcommand: %s", cmd.args[0])
+    }
+}
+
+// webhookAdd registers "<url> [feed url...] [--all] [--secret s]". Without
+// feed URLs the webhook follows the user's subscriptions; --all, for admins,
+// sends every new post.
+func webhookAdd(s *state, args []string, user database.User) error {
+    secret, args, err := cutOption(args, "secret")
+    if err != nil {
+        return err
+    }
+    flags, args, err := splitFlags(args, "all")
+    if err != nil {
+        return err
+    }
+    if len(args) < 1 {
+        return errors.New("The webhook add command expects a URL and optionally feed URLs to filter on")
+    }
+
+    target, err := url.Parse(args[0])
+    if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+        return errors.New("The webhook URL must be an http or https URL")
+    }
+    if flags["all"] && !isAdmin(user) {
+        return errors.New("Only admins with a password can add webhooks for all feeds")
+    }
+    if flags["all"] && len(args) > 1 {
+        return errors.New("A webhook for all feeds takes no feed filters")
+    }
+
+    var feeds []database.Feed
+    for _, feedURL := range(args[1:]) {
+        feed, err := s.db.GetFeedByURL(context.Background(), feedURL)
+        if err != nil {
+            return fmt.Errorf("Failed to retrieve feed %s: %w", feedURL, err)
+        }
+        feeds = append(feeds, feed)
+    }
+
+    if secret == "" {
+        secret, err = generateWebhookSecret()
+        if err != nil {
+            return err
+        }
+    }
+
+    webhook, err := s.db.CreateWebhook(context.Background(), database.CreateWebhookParams{
+        ID: uuid.New(),
+        CreatedAt: time.Now(),
+        UserID: user.ID,
+        Url: target.String(),
+        Secret: secret,
+        AllFeeds: flags["all"],
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to store webhook: %w", err)
+    }
+
+    for _, feed := range(feeds) {
+        err = s.db.AddWebhookFeed(context.Background(), database.AddWebhookFeedParams{
+            WebhookID: webhook.ID,
+            FeedID: feed.ID,
+        })
+        if err != nil {
+            return fmt.Errorf("Failed to add feed filter: %w", err)
+        }
+    }
+
+    fmt.Printf("Webhook %s added for %s\n", webhook.ID, webhook.Url)
+    fmt.Printf("Verify deliveries with the HMAC-SHA256 of the body in %s, signed with:\n%s\n", webhookSignatureHeader, secret)
+    return nil
+}
+
+func webhookList(s *state, args []string, user database.User) error {
+    if len(args) != 0 {
+        return errors.New("The webhook list command expects ZERO arguments")
+    }
+
+    webhooks, err := s.db.GetWebhooksForUser(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to fetch webhooks: %w", err)
+    }
+
+    feedNames, err := s.db.GetWebhookFeedNames(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to fetch webhook filters: %w", err)
+    }
+    filters := make(map[uuid.UUID][]string)
+    for _, row := range(feedNames) {
+        filters[row.WebhookID] = append(filters[row.WebhookID], row.Name)
+    }
+
+    counts, err := s.db.GetWebhookDeliveryCounts(context.Background(), user.ID)
+    if err != nil {
+        return fmt.Errorf("Failed to fetch webhook deliveries: %w", err)
+    }
+    countByWebhook := make(map[uuid.UUID]database.GetWebhookDeliveryCountsRow, len(counts))
+    for _, count := range(counts) {
+        countByWebhook[count.WebhookID] = count
+    }
+
+    for _, webhook := range(webhooks) {
+        scope := "followed feeds"
+        if webhook.AllFeeds {
+            scope = "all feeds"
+        } else if len(filters[webhook.ID]) > 0 {
+            scope = strings.Join(filters[webhook.ID], ", ")
+        }
+        count := countByWebhook[webhook.ID]
+
+        fmt.Printf("---\nID: %s\nURL: %s\nFeeds: %s\nDeliveries: %d delivered, %d pending, %d failed\n",
+            webhook.ID, webhook.Url, scope, count.Delivered, count.Pending, count.Failed)
+    }
+
+    return nil
+}
+
+func webhookRemove(s *state, args []string, user database.User) error {
+    if len(args) != 1 {
+        return errors.New("The webhook rm command expects ONE argument")
+    }
+
+    id, err := uuid.Parse(args[0])
+    if err != nil {
+        return fmt.Errorf("Invalid webhook ID: %w", err)
+    }
+
+    removed, err := s.db.DeleteWebhook(context.Background(), database.DeleteWebhookParams{
+        ID: id,
+        UserID: user.ID,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to remove webhook: %w", err)
+    }
+    if removed == 0 {
+        return fmt.Errorf("No webhook with ID %s", id)
+    }
+
+    fmt.Printf("Webhook %s removed with its pending deliveries\n", id)
+    return nil
+}
+
+// webhookLog shows the latest delivery attempts of a webhook.
+func webhookLog(s *state, args []string, user database.User) error {
+    if len(args) < 1 || len(args) > 2 {
+        return errors.New("The webhook log command expects ONE or TWO arguments")
+    }
+
+    id, err := uuid.Parse(args[0])
+    if err != nil {
+        return fmt.Errorf("Invalid webhook ID: %w", err)
+    }
+
+    limit := int32(webhookLogLimit)
+    if len(args) == 2 {
+        parsed, err := strconv.ParseInt(args[1], 10, 32)
+        if err != nil {
+            return fmt.Errorf("Failed to convert input into integer: %w", err)
+        }
+        limit = int32(parsed)
+    }
+
+    attempts, err := s.db.GetWebhookAttempts(context.Background(), database.GetWebhookAttemptsParams{
+        ID: id,
+        UserID: user.ID,
+        Limit: limit,
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to fetch webhook log: %w", err)
+    }
+
+    for _, attempt := range(attempts) {
+        result := "no response"
+        if attempt.StatusCode.Valid {
+            result = strconv.Itoa(int(attempt.StatusCode.Int32))
+        }
+        if attempt.Error.Valid {
+            result += ": " + attempt.Error.String
+        }
+        fmt.Printf("%s  post %s  %dms  %s\n",
+            attempt.AttemptedAt.Format(time.RFC1123), attempt.PostID, attempt.DurationMs, result)
+    }
+
+    return nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zulkou/blog-aggregator/internal/database"
+	"github.com/zulkou/blog-aggregator/rss"
+)
+
+const (
+    // websubLease is the lease asked of hubs, which may grant another.
+    websubLease = 10 * 24 * time.Hour
+    // websubRenewBefore is how long before its lease ends a subscription is
+    // renewed.
+    websubRenewBefore = 24 * time.Hour
+    // websubRetry is the wait before asking a hub again after it failed or
+    // never verified a request.
+    websubRetry = time.Hour
+    websubCheckInterval = 5 * time.Minute
+    // websubPollInterval is how often feeds a hub pushes to are still
+    // fetched, in case the hub stops pushing.
+    websubPollInterval = 24 * time.Hour
+)
+
+// recordWebSubHub keeps the feed's subscription in step with the hub its
+// latest fetch advertised, dropping it when there is none any more.
+func recordWebSubHub(s *state, logger *slog.Logger, feed database.Feed, parsed *rss.RSSFeed) {
+    hub, topic := parsed.Hub(feed.Url)
+    if u, err := url.Parse(hub); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+        hub = ""
+    }
+
+    var err error
+    if hub == "" {
+        err = s.db.DeleteWebSubSubscription(context.Background(), feed.ID)
+    } else {
+        var secret string
+        secret, err = randomToken("")
+        if err == nil {
+            err = s.db.SetWebSubHub(context.Background(), database.SetWebSubHubParams{
+                FeedID: feed.ID,
+                CreatedAt: time.Now(),
+                Hub: hub,
+                Topic: topic,
+                Secret: secret,
+            })
+        }
+    }
+    if err != nil {
+        logger.Error("Failed to record WebSub hub", "hub", hub, "error", err)
+    }
+}
+
+// runWebSub subscribes to the hubs feeds advertise and renews leases before
+// they run out, for as long as serve runs. Hubs call back to public_url, so
+// without one nothing is subscribed and feeds are polled as usual.
+func runWebSub(s *state) {
+    if s.cfg.PublicURL == "" {
+        slog.Warn("WebSub is off, hubs need public_url in the config to reach this server")
+        return
+    }
+
+    ticker := time.NewTicker(websubCheckInterval)
+    for ; ; <-ticker.C {
+        err := requestWebSubSubscriptions(s)
+        if err != nil {
+            slog.Error("Failed to request WebSub subscriptions", "error", err)
+        }
+    }
+}
+
+func requestWebSubSubscriptions(s *state) error {
+    now := time.Now()
+    subs, err := s.db.GetDueWebSubSubscriptions(context.Background(), database.GetDueWebSubSubscriptionsParams{
+        RetryBefore: sql.NullTime{Time: now.Add(-websubRetry), Valid: true},
+        RenewBefore: sql.NullTime{Time: now.Add(websubRenewBefore), Valid: true},
+    })
+    if err != nil {
+        return err
+    }
+
+    callbackBase := strings.TrimSuffix(s.cfg.PublicURL, "/") + "/websub/"
+    for _, sub := range(subs) {
+        logger := slog.With("feed_id", sub.FeedID, "hub", sub.Hub)
+        params := database.MarkWebSubRequestedParams{
+            RequestedAt: sql.NullTime{Time: time.Now(), Valid: true},
+            FeedID: sub.FeedID,
+        }
+
+        // Recorded first, since some hubs verify before they answer.
+        err := s.db.MarkWebSubRequested(context.Background(), params)
+        if err != nil {
+            return err
+        }
+
+        err = s.fetcher.Subscribe(context.Background(), sub.Hub, rss.Subscription{
+            Topic: sub.Topic,
+            Callback: callbackBase + sub.FeedID.String(),
+            Secret: sub.Secret,
+            Lease: websubLease,
+        })
+        if err == nil {
+            logger.Info("WebSub subscription requested", "topic", sub.Topic)
+            continue
+        }
+
+        logger.Warn("WebSub subscription request failed", "error", err)
+        params.Error = sql.NullString{String: err.Error(), Valid: true}
+        err = s.db.MarkWebSubRequested(context.Background(), params)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// registerWebSubRoutes serves the callback hubs verify subscriptions with
+// and push new content to.
+func registerWebSubRoutes(mux *http.ServeMux, s *state) {
+    mux.HandleFunc("GET /websub/{feedID}", func(w http.ResponseWriter, r *http.Request) { websubVerify(s, w, r) })
+    mux.HandleFunc("POST /websub/{feedID}", func(w http.ResponseWriter, r *http.Request) { websubReceive(s, w, r) })
+}
+
+// websubVerify confirms subscriptions gator asked for by echoing the hub's
+// challenge, and records those the hub denied. The lease is at most the one
+// gator asked for.
+func websubVerify(s *state, w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    mode := query.Get("hub.mode")
+
+    feedID, err := uuid.Parse(r.PathValue("feedID"))
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+    sub, err := s.db.GetWebSubSubscription(r.Context(), feedID)
+    if errors.Is(err, sql.ErrNoRows) {
+        // Nothing wants the feed pushed any more, so agree to drop it.
+        if mode == "unsubscribe" {
+            io.WriteString(w, query.Get("hub.challenge"))
+            return
+        }
+        http.NotFound(w, r)
+        return
+    } else if err != nil {
+        webError(w, err)
+        return
+    }
+    if query.Get("hub.topic") != sub.Topic {
+        http.NotFound(w, r)
+        return
+    }
+
+    logger := slog.With("feed_id", feedID, "hub", sub.Hub)
+    switch mode {
+    case "subscribe":
+        // Only a request gator is still waiting on is verified, so an old or
+        // forged verification cannot extend the lease.
+        if sub.State != "pending" || !sub.RequestedAt.Valid || time.Since(sub.RequestedAt.Time) > websubRetry {
+            http.NotFound(w, r)
+            return
+        }
+        lease := websubLease
+        if seconds, err := strconv.Atoi(query.Get("hub.lease_seconds")); err == nil && seconds > 0 {
+            lease = min(lease, time.Duration(seconds) * time.Second)
+        }
+        err = s.db.ActivateWebSubSubscription(r.Context(), database.ActivateWebSubSubscriptionParams{
+            FeedID: feedID,
+            LeaseExpiresAt: sql.NullTime{Time: time.Now().Add(lease), Valid: true},
+            UpdatedAt: time.Now(),
+        })
+        if err != nil {
+            webError(w, err)
+            return
+        }
+        logger.Info("WebSub subscription verified", "lease", lease)
+        io.WriteString(w, query.Get("hub.challenge"))
+    case "denied":
+        reason := query.Get("hub.reason")
+        err = s.db.DenyWebSubSubscription(r.Context(), database.DenyWebSubSubscriptionParams{
+            FeedID: feedID,
+            Error: sql.NullString{String: reason, Valid: reason != ""},
+            UpdatedAt: time.Now(),
+        })
+        if err != nil {
+            webError(w, err)
+            return
+        }
+        logger.Warn("WebSub subscription denied", "reason", reason)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+// websubReceive stores the items a hub pushes like fetched ones. Content
+// without a valid X-Hub-Signature is acknowledged but ignored, as the spec
+// asks, so a forger cannot tell whether it was accepted.
+func websubReceive(s *state, w http.ResponseWriter, r *http.Request) {
+    feedID, err := uuid.Parse(r.PathValue("feedID"))
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+    sub, err := s.db.GetWebSubSubscription(r.Context(), feedID)
+    if errors.Is(err, sql.ErrNoRows) {
+        // Tells the hub to stop pushing.
+        http.Error(w, "Not subscribed", http.StatusGone)
+        return
+    } else if err != nil {
+        webError(w, err)
+        return
+    }
+
+    maxBody := s.cfg.Fetch.MaxBodyBytes
+    if maxBody <= 0 {
+        maxBody = rss.DefaultMaxBodySize
+    }
+    body, err := io.ReadAll(io.LimitReader(r.Body, maxBody + 1))
+    if err != nil {
+        http.Error(w, "Failed to read body", http.StatusBadRequest)
+        return
+    }
+    if int64(len(body)) > maxBody {
+        http.Error(w, "Body too large", http.StatusRequestEntityTooLarge)
+        return
+    }
+
+    logger := slog.With("feed_id", feedID, "hub", sub.Hub)
+    if !rss.CheckSignature(sub.Secret, body, r.Header.Get("X-Hub-Signature")) {
+        logger.Warn("Ignoring WebSub content with a bad signature")
+        w.WriteHeader(http.StatusAccepted)
+        return
+    }
+
+    parsed, err := rss.Parse(body, r.Header.Get("Content-Type"))
+    if err != nil {
+        logger.Warn("Ignoring unparsable WebSub content", "error", err)
+        w.WriteHeader(http.StatusAccepted)
+        return
+    }
+    feed, err := s.db.GetFeedByID(r.Context(), feedID)
+    if err != nil {
+        webError(w, err)
+        return
+    }
+
+    var record database.CreateFeedFetchParams
+    storeItems(s, logger, feed, parsed.Channel.Item, &record)
+    logger.Info("WebSub content received",
+        "items", len(parsed.Channel.Item),
+        "new_posts", record.NewPosts,
+        "updated_posts", record.UpdatedPosts,
+    )
+    w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerWebSub lists the feeds that advertise a hub and how far their
+// subscriptions got.
+func handlerWebSub(s *state, cmd command) error {
+    if len(cmd.args) != 0 {
+        return errors.New("The websub command expects ZERO arguments")
+    }
+
+    subs, err := s.db.GetWebSubSubscriptions(context.Background())
+    if err != nil {
+        return fmt.Errorf("Failed to fetch WebSub subscriptions: %w", err)
+    }
+    if len(subs) == 0 {
+        fmt.Println("No feed advertises a WebSub hub")
+        return nil
+    }
+
+    for _, sub := range(subs) {
+        fmt.Printf("---\nFeed: %s (%s)\nHub: %s\nState: %s\n", sub.FeedName, sub.FeedUrl, sub.Hub, sub.State)
+        if sub.LeaseExpiresAt.Valid {
+            fmt.Printf("Lease until: %s\n", sub.LeaseExpiresAt.Time.Format(time.RFC1123))
+        }
+        if sub.RequestedAt.Valid {
+            fmt.Printf("Last requested: %s\n", sub.RequestedAt.Time.Format(time.RFC1123))
+        }
+        if sub.Error.Valid {
+            fmt.Printf("Error: %s\n", sub.Error.String)
+        }
+    }
+    return nil
+}